@@ -0,0 +1,289 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var agentListenAddr string
+var agentToken string
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Run load tests for a coordinator",
+	Long: `Wait for a coordinator to push a load test scenario, run it at the time given
+by the coordinator and report its progress and result back.
+
+The RPC protocol is neither authenticated nor encrypted, besides the --token
+shared with the coordinator, which is required unless the agent listens on a
+loopback address. The scenario includes the credentials of the test, so only
+listen on a trusted network.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		runAgent()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+
+	agentCmd.Flags().StringVar(&agentListenAddr, "listen", "127.0.0.1:7700", "Address to listen on for the coordinator")
+	agentCmd.Flags().StringVar(&agentToken, "token", "", "Token the coordinator must present, required unless --listen is a loopback address")
+}
+
+func runAgent() {
+	if err := validateAgentListen(agentListenAddr, agentToken); err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	server := rpc.NewServer()
+	if err := server.RegisterName("Agent", &AgentService{executable: executable, token: agentToken}); err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	listener, err := net.Listen("tcp", agentListenAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v\n", err)
+	}
+	log.Printf("Agent listening on %v\n", listener.Addr())
+	server.Accept(listener)
+}
+
+// validateAgentListen requires a token when the agent listens on an address
+// other machines can reach, as anyone could push a scenario otherwise.
+func validateAgentListen(addr string, token string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil
+	}
+	if token == "" {
+		return fmt.Errorf("--token is required to listen on %v, which is not a loopback address", addr)
+	}
+	return nil
+}
+
+// AgentService runs the load tests pushed by the coordinator, one at a time,
+// as a child process of the same executable.
+type AgentService struct {
+	executable string
+	token      string
+
+	mutex sync.Mutex
+	run   *agentRun
+}
+
+// agentRun is a load test process of the agent.
+type agentRun struct {
+	cmd       *exec.Cmd
+	lines     []string         // log lines of the load test
+	intervals []IntervalRecord // interval statistics of the load test
+	output    bytes.Buffer
+	done      bool
+	stopped   bool
+	err       string
+}
+
+type PingArgs struct {
+	Token string
+}
+
+type PingReply struct {
+	Time time.Time // Clock of the agent
+}
+
+type StartArgs struct {
+	Token string
+	Args  []string  // Command line of the load test, e.g. --server, load-test and its flags
+	At    time.Time // Start time in the clock of the agent
+}
+
+type StartReply struct{}
+
+type ProgressArgs struct {
+	Token         string
+	From          int // Number of log lines already received
+	FromIntervals int // Number of interval statistics already received
+}
+
+type ProgressReply struct {
+	Lines     []string         // New log lines
+	Intervals []IntervalRecord // New interval statistics
+	Done      bool
+	Output    []byte // JSON result, once done
+	Err       string // Error of the load test process, once done
+}
+
+type StopArgs struct {
+	Token string
+}
+
+type StopReply struct{}
+
+func (a *AgentService) authorize(token string) error {
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		return fmt.Errorf("invalid token")
+	}
+	return nil
+}
+
+func (a *AgentService) Ping(args PingArgs, reply *PingReply) error {
+	if err := a.authorize(args.Token); err != nil {
+		return err
+	}
+	reply.Time = time.Now()
+	return nil
+}
+
+// Start runs the load test at the given time, its result is written as JSON.
+// The interval statistics are exported to a pipe, the fourth file of the
+// child process.
+func (a *AgentService) Start(args StartArgs, reply *StartReply) error {
+	if err := a.authorize(args.Token); err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.run != nil && !a.run.done {
+		return fmt.Errorf("a load test is already running")
+	}
+	cmdArgs := append(append([]string(nil), args.Args...), "--export", "interval-jsonl=/dev/fd/3", "--output-format", JSON)
+	run := &agentRun{cmd: exec.Command(a.executable, cmdArgs...)}
+	run.cmd.Stdout = &run.output
+	stderr, err := run.cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	intervals, intervalsWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	run.cmd.ExtraFiles = []*os.File{intervalsWriter}
+	a.run = run
+	log.Printf("Starting load test at %v: %v\n", args.At.Format(time.RFC3339Nano), cmdArgs)
+	go func() {
+		time.Sleep(time.Until(args.At))
+		a.mutex.Lock()
+		err := fmt.Errorf("stopped")
+		if !run.stopped {
+			err = run.cmd.Start()
+		}
+		a.mutex.Unlock()
+		// the child has its own copy, the reader ends when the child exits
+		intervalsWriter.Close()
+		if err != nil {
+			intervals.Close()
+			a.finish(run, err)
+			return
+		}
+		intervalsDone := make(chan struct{})
+		go func() {
+			defer close(intervalsDone)
+			defer intervals.Close()
+			a.readIntervals(run, intervals)
+		}()
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.Printf("%s\n", scanner.Text())
+			a.mutex.Lock()
+			run.lines = append(run.lines, scanner.Text())
+			a.mutex.Unlock()
+		}
+		<-intervalsDone
+		a.finish(run, run.cmd.Wait())
+	}()
+	return nil
+}
+
+// readIntervals keeps the interval statistics exported by the load test.
+func (a *AgentService) readIntervals(run *agentRun, r io.Reader) {
+	decoder := json.NewDecoder(r)
+	for {
+		var record IntervalRecord
+		if err := decoder.Decode(&record); err != nil {
+			if err != io.EOF {
+				log.Printf("Error: failed to read interval statistics: %v\n", err)
+				io.Copy(io.Discard, r)
+			}
+			return
+		}
+		a.mutex.Lock()
+		run.intervals = append(run.intervals, record)
+		a.mutex.Unlock()
+	}
+}
+
+func (a *AgentService) finish(run *agentRun, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	run.done = true
+	if err != nil {
+		run.err = err.Error()
+	}
+	log.Printf("Load test finished: %v\n", err)
+}
+
+// Progress returns the log lines of the load test from args.From, and its
+// result once it is done.
+func (a *AgentService) Progress(args ProgressArgs, reply *ProgressReply) error {
+	if err := a.authorize(args.Token); err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.run == nil {
+		return fmt.Errorf("no load test was started")
+	}
+	if args.From < len(a.run.lines) {
+		reply.Lines = append(reply.Lines, a.run.lines[args.From:]...)
+	}
+	if args.FromIntervals < len(a.run.intervals) {
+		reply.Intervals = append(reply.Intervals, a.run.intervals[args.FromIntervals:]...)
+	}
+	reply.Done = a.run.done
+	if reply.Done {
+		reply.Output = a.run.output.Bytes()
+		reply.Err = a.run.err
+	}
+	return nil
+}
+
+// Stop kills the running load test.
+func (a *AgentService) Stop(args StopArgs, reply *StopReply) error {
+	if err := a.authorize(args.Token); err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.run == nil || a.run.done {
+		return nil
+	}
+	a.run.stopped = true
+	if a.run.cmd.Process != nil {
+		return a.run.cmd.Process.Kill()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"net"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/montanaflynn/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startTestAgent serves an agent on localhost that runs the scenarios with
+// /bin/sh instead of this tool.
+func startTestAgent(t *testing.T) string {
+	server := rpc.NewServer()
+	require.NoError(t, server.RegisterName("Agent", &AgentService{executable: "/bin/sh", token: "secret"}))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go server.Accept(listener)
+	return listener.Addr().String()
+}
+
+func TestCoordinatorWithAgents(t *testing.T) {
+	defer func(token string) { coordinatorToken = token }(coordinatorToken)
+	coordinatorToken = "secret"
+
+	var buf bytes.Buffer
+	require.NoError(t, summaryOfLatencies("test", stats.Float64Data{1e6, 2e6, 3e6}, time.Second).WriteJson(&buf))
+	result := filepath.Join(t.TempDir(), "result.json")
+	require.NoError(t, os.WriteFile(result, buf.Bytes(), 0600))
+
+	var agents []*coordinatedAgent
+	for i := 0; i < 2; i++ {
+		address := startTestAgent(t)
+		client, err := rpc.Dial("tcp", address)
+		require.NoError(t, err)
+		defer client.Close()
+		agent := &coordinatedAgent{address: address, client: client}
+		agent.clockOffset, err = estimateClockOffset(client)
+		require.NoError(t, err)
+		assert.InDelta(t, 0, float64(agent.clockOffset), float64(time.Second), "same clock")
+		agents = append(agents, agent)
+	}
+
+	interval := `{"time":"2024-01-01T00:00:05Z","test_name":"test","statistic":{"query_number":500,"qps":100,"avg":2e6,"p99":4e6}}`
+	// the agent appends --export and --output-format, which sh ignores after -c
+	scenario := []string{"-c", "echo started >&2; echo '" + interval + "' >&3; cat " + result}
+	for _, agent := range agents {
+		require.NoError(t, agent.client.Call("Agent.Start", StartArgs{Token: coordinatorToken, Args: scenario, At: time.Now()}, &StartReply{}))
+	}
+	for _, agent := range agents {
+		require.Eventually(t, func() bool {
+			var reply ProgressReply
+			require.NoError(t, agent.client.Call("Agent.Progress", ProgressArgs{Token: coordinatorToken, From: agent.lines, FromIntervals: agent.intervals}, &reply))
+			agent.lines += len(reply.Lines)
+			agent.observeIntervals(reply.Intervals)
+			agent.done, agent.output, agent.err = reply.Done, reply.Output, reply.Err
+			return reply.Done
+		}, 5*time.Second, 10*time.Millisecond)
+		assert.Equal(t, 1, agent.lines)
+		assert.Equal(t, 1, agent.intervals)
+		assert.Empty(t, agent.err)
+	}
+
+	assert.Nil(t, mergeAgentIntervals(agents), "done agents are left out")
+	agents[0].done, agents[1].done = false, false
+	qps1, qps2 := 20.0, 60.0
+	agents[0].observeIntervals([]IntervalRecord{{Statistic: &Statistic{QueryNumber: 100, QPS: &qps1, Avg: 1e6, P99: 2e6}}})
+	assert.Nil(t, mergeAgentIntervals(agents), "waits for a new interval of every agent")
+	agents[1].observeIntervals([]IntervalRecord{{Statistic: &Statistic{QueryNumber: 300, QPS: &qps2, Avg: 3e6, P99: 6e6}}})
+	st := mergeAgentIntervals(agents)
+	require.NotNil(t, st)
+	assert.Equal(t, uint(400), st.QueryNumber)
+	assert.InDelta(t, 80, *st.QPS, 1e-9)
+	assert.InDelta(t, 2.5e6, st.Avg, 1e-6)
+	assert.InDelta(t, 5e6, st.P99, 1e-6)
+	assert.Nil(t, mergeAgentIntervals(agents), "each interval is merged once")
+	agents[0].done, agents[1].done = true, true
+
+	merged, err := mergeAgentResults(agents)
+	require.NoError(t, err)
+	require.Len(t, merged, 1)
+	assert.Equal(t, uint(6), merged[0].Result.Test.QueryNumber)
+	assert.InDelta(t, 6, *merged[0].Result.Test.QPS, 1e-9)
+	require.Len(t, merged[0].Result.Sources, 2)
+	assert.Equal(t, agents[1].address, merged[0].Result.Sources[1].Name)
+
+	var reply PingReply
+	assert.Error(t, agents[0].client.Call("Agent.Ping", PingArgs{Token: "wrong"}, &reply))
+}
+
+func TestValidateAgentListen(t *testing.T) {
+	assert.NoError(t, validateAgentListen("127.0.0.1:7700", ""))
+	assert.NoError(t, validateAgentListen("[::1]:7700", ""))
+	assert.NoError(t, validateAgentListen("localhost:7700", ""))
+	assert.EqualError(t, validateAgentListen("0.0.0.0:7700", ""), "--token is required to listen on 0.0.0.0:7700, which is not a loopback address")
+	assert.Error(t, validateAgentListen(":7700", ""), "all interfaces")
+	assert.NoError(t, validateAgentListen("0.0.0.0:7700", "secret"))
+	assert.Error(t, validateAgentListen("7700", "secret"))
+}
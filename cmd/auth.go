@@ -0,0 +1,232 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+)
+
+type appAuthMethod string
+
+const (
+	appAuthMethodAPIKey      appAuthMethod = "api-key"
+	appAuthMethodCertificate appAuthMethod = "cert"
+	appAuthMethodJWT         appAuthMethod = "jwt"
+)
+
+// impl pflag.Value interface for appAuthMethod
+
+func (m *appAuthMethod) String() string {
+	return string(*m)
+}
+
+func (m *appAuthMethod) Set(v string) error {
+	switch v {
+	case "api-key", "apikey":
+		*m = appAuthMethodAPIKey
+	case "cert", "certificate":
+		*m = appAuthMethodCertificate
+	case "jwt", "JWT":
+		*m = appAuthMethodJWT
+	default:
+		return fmt.Errorf("invalid auth method: %v", v)
+	}
+	return nil
+}
+
+func (m *appAuthMethod) Type() string {
+	return "AuthMethod"
+}
+
+type credentialStrategy string
+
+const (
+	credentialStrategyRoundRobin credentialStrategy = "round-robin"
+	credentialStrategyRandom     credentialStrategy = "random"
+)
+
+// impl pflag.Value interface for credentialStrategy
+
+func (c *credentialStrategy) String() string {
+	return string(*c)
+}
+
+func (c *credentialStrategy) Set(v string) error {
+	switch v {
+	case "round-robin", "rr":
+		*c = credentialStrategyRoundRobin
+	case "random":
+		*c = credentialStrategyRandom
+	default:
+		return fmt.Errorf("invalid credential strategy: %v", v)
+	}
+	return nil
+}
+
+func (c *credentialStrategy) Type() string {
+	return "CredentialStrategy"
+}
+
+// appCredential identifies the app a load test worker authenticates as.
+// APIKey is only used with the api-key auth method.
+type appCredential struct {
+	Name   string
+	APIKey string
+}
+
+// loadAppCredentials returns the credentials load test workers are
+// distributed over. With the api-key auth method these are read from
+// --api-key-file if given, otherwise there is exactly one credential.
+func loadAppCredentials() ([]appCredential, error) {
+	switch {
+	case authMethod == appAuthMethodCertificate:
+		return []appCredential{{Name: appID}}, nil
+	case authMethod == appAuthMethodJWT:
+		return []appCredential{{Name: "jwt"}}, nil
+	case apiKeyFile == "":
+		return []appCredential{{Name: apiKeyName(apiKey, 0), APIKey: apiKey}}, nil
+	}
+	file, err := os.Open(apiKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseAPIKeys(file)
+}
+
+// firstAppCredential returns the first app credential, used for lookups
+// made before the load test starts.
+func firstAppCredential() *appCredential {
+	creds, err := loadAppCredentials()
+	if err != nil {
+		log.Fatalf("Failed to load app credentials: %v\n", err)
+	}
+	return &creds[0]
+}
+
+// parseAPIKeys reads one API key per line, empty lines and lines starting
+// with '#' are ignored.
+func parseAPIKeys(r io.Reader) ([]appCredential, error) {
+	var creds []appCredential
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		creds = append(creds, appCredential{Name: apiKeyName(line, len(creds)), APIKey: line})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(creds) == 0 {
+		return nil, fmt.Errorf("no API keys found")
+	}
+	return creds, nil
+}
+
+// apiKeyName returns the app ID encoded in an API key, or a positional
+// name if the key can not be decoded.
+func apiKeyName(key string, index int) string {
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+		decoded, err := encoding.DecodeString(key)
+		if err != nil {
+			continue
+		}
+		if appID, _, found := strings.Cut(string(decoded), ":"); found {
+			return appID
+		}
+	}
+	return fmt.Sprintf("app-%d", index+1)
+}
+
+// appSessionRequired reports whether the selected auth method only works
+// through a session. Certificate authentication happens during the TLS
+// handshake, so the app has to exchange it for a bearer token first.
+func appSessionRequired() bool {
+	return authMethod == appAuthMethodCertificate
+}
+
+// useSession reports whether load test workers authenticate with a session.
+func useSession() bool {
+	return createSession || appSessionRequired()
+}
+
+// authenticateApp sets up the authorization of client as cred according to
+// the selected auth method. If session is true, or the auth method requires
+// it, a session is established and client.Auth is set to its bearer token.
+func authenticateApp(client *sdkms.Client, cred *appCredential, session bool) error {
+	ctx := context.Background()
+	switch authMethod {
+	case appAuthMethodAPIKey:
+		if session {
+			_, err := client.AuthenticateWithAPIKey(ctx, cred.APIKey)
+			return err
+		}
+		client.Auth = sdkms.APIKey(cred.APIKey)
+		return nil
+	case appAuthMethodCertificate:
+		if appID == "" {
+			return fmt.Errorf("--app-id is required for certificate authentication")
+		}
+		// The client certificate is presented by the transport, the app is
+		// identified by its ID with an empty secret.
+		_, err := client.AuthenticateWithAPIKey(ctx, base64.StdEncoding.EncodeToString([]byte(appID+":")))
+		return err
+	case appAuthMethodJWT:
+		if jwtToken == "" {
+			return fmt.Errorf("--jwt is required for JWT authentication")
+		}
+		if session {
+			return authenticateWithBearerToken(ctx, client, jwtToken)
+		}
+		client.Auth = sdkms.BearerToken(jwtToken)
+		return nil
+	default:
+		return fmt.Errorf("unreachable: unacceptable auth method: %v", authMethod)
+	}
+}
+
+// authenticateWithBearerToken exchanges token for a session. The SDK only
+// supports Basic authorization on the session endpoint, so the request is
+// made directly through the client's HTTP client.
+func authenticateWithBearerToken(ctx context.Context, client *sdkms.Client, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.Endpoint+"/sys/v1/session/auth", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		return &sdkms.BackendError{StatusCode: resp.StatusCode, Message: string(body)}
+	}
+	var response sdkms.AuthenticationResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+	client.Auth = sdkms.BearerToken(response.AccessToken)
+	return nil
+}
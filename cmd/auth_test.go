@@ -0,0 +1,187 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fortanix/dsm-perf-tool/mockserver"
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	key1 := base64.URLEncoding.EncodeToString([]byte("0b7a3a8e-1f1e-4b8e-9a0c-1c2d3e4f5a6b:secret1"))
+	key2 := base64.StdEncoding.EncodeToString([]byte("6c1d1f7a-2b3c-4d5e-8f90-a1b2c3d4e5f6:secret2"))
+	input := "# test apps\n" + key1 + "\n\n  " + key2 + "  \nnot-an-api-key\n"
+
+	creds, err := parseAPIKeys(strings.NewReader(input))
+	assert.NoError(t, err)
+	assert.Equal(t, []appCredential{
+		{Name: "0b7a3a8e-1f1e-4b8e-9a0c-1c2d3e4f5a6b", APIKey: key1},
+		{Name: "6c1d1f7a-2b3c-4d5e-8f90-a1b2c3d4e5f6", APIKey: key2},
+		{Name: "app-3", APIKey: "not-an-api-key"},
+	}, creds)
+
+	_, err = parseAPIKeys(strings.NewReader("# no keys\n"))
+	assert.Error(t, err)
+}
+
+// startTestServer serves handler over TLS, requesting a client certificate,
+// and points the DSM client of the commands to it.
+func startTestServer(t *testing.T, handler http.Handler) {
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.ParseUint(serverURL.Port(), 10, 16)
+	require.NoError(t, err)
+	serverName, serverPort, insecureTLS = serverURL.Hostname(), uint16(port), true
+	tlsConfigOnce = sync.Once{}
+	t.Cleanup(func() {
+		server.Close()
+		resetRootCmdStatus()
+		tlsConfigOnce = sync.Once{}
+	})
+}
+
+// withAuthMethod selects the auth method for the duration of the test.
+func withAuthMethod(t *testing.T, method appAuthMethod) {
+	saved, savedAppID, savedJWT := authMethod, appID, jwtToken
+	authMethod = method
+	t.Cleanup(func() { authMethod, appID, jwtToken = saved, savedAppID, savedJWT })
+}
+
+func TestAuthenticateWithCertificate(t *testing.T) {
+	withAuthMethod(t, appAuthMethodCertificate)
+	ca, err := generateTestCA("test CA")
+	require.NoError(t, err)
+	clientCert, err := issueClientCertificate(ca, "test client")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	clientCertFile, clientKeyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	defer func() { clientCertFile, clientKeyFile = "", "" }()
+	require.NoError(t, clientCert.writeCertificatePEM(clientCertFile, clientKeyFile))
+
+	mock := mockserver.New(mockserver.Config{})
+	kid := mock.CreateKey("aes", sdkms.ObjectTypeAes, 256)
+	withCert := true
+	startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sys/v1/session/auth" && withCert {
+			if assert.Len(t, r.TLS.PeerCertificates, 1, "client certificate presented") {
+				assert.Equal(t, "test client", r.TLS.PeerCertificates[0].Subject.CommonName)
+			}
+			assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("app-1:")), r.Header.Get("Authorization"))
+		}
+		mock.ServeHTTP(w, r)
+	}))
+
+	client := sdkmsClient()
+	cred := &appCredential{Name: "app-1"}
+	assert.EqualError(t, authenticateApp(&client, cred, false), "--app-id is required for certificate authentication")
+
+	appID = "app-1"
+	require.NoError(t, authenticateApp(&client, cred, false))
+	_, err = client.GetSobject(context.Background(), nil, *sdkms.SobjectByID(kid))
+	assert.NoError(t, err, "the session token is used")
+
+	// the mock rejects certificate authentication without a certificate
+	withCert, clientCertFile, clientKeyFile = false, "", ""
+	tlsConfigOnce = sync.Once{}
+	client = sdkmsClient()
+	assert.True(t, isUnauthorizedError(authenticateApp(&client, cred, false)))
+}
+
+func TestClientCertificateFiles(t *testing.T) {
+	ca, err := generateTestCA("test CA")
+	require.NoError(t, err)
+	clientCert, err := issueClientCertificate(ca, "test client")
+	require.NoError(t, err)
+	otherCert, err := issueClientCertificate(ca, "other client")
+	require.NoError(t, err)
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+	require.NoError(t, clientCert.writeCertificatePEM(certFile, keyFile))
+	otherKeyFile := filepath.Join(dir, "other-key.pem")
+	require.NoError(t, otherCert.writeCertificatePEM(filepath.Join(dir, "other.pem"), otherKeyFile))
+	garbage := filepath.Join(dir, "garbage.pem")
+	require.NoError(t, os.WriteFile(garbage, []byte("not a certificate"), 0600))
+	defer func() {
+		clientCertFile, clientKeyFile = "", ""
+		tlsConfigOnce = sync.Once{}
+	}()
+
+	for _, files := range [][2]string{
+		{garbage, keyFile},
+		{certFile, garbage},
+		{certFile, otherKeyFile},
+		{certFile, filepath.Join(dir, "missing.pem")},
+		{certFile, ""},
+	} {
+		clientCertFile, clientKeyFile = files[0], files[1]
+		tlsConfigOnce = sync.Once{}
+		_, err := tlsClientConfig()
+		assert.ErrorContains(t, err, "failed to load client certificate", "%v", files)
+	}
+
+	clientCertFile, clientKeyFile = certFile, keyFile
+	tlsConfigOnce = sync.Once{}
+	config, err := tlsClientConfig()
+	require.NoError(t, err)
+	assert.Len(t, config.Certificates, 1)
+}
+
+func TestAuthenticateWithJWT(t *testing.T) {
+	withAuthMethod(t, appAuthMethodJWT)
+	mock := mockserver.New(mockserver.Config{})
+	kid := mock.CreateKey("aes", sdkms.ObjectTypeAes, 256)
+	startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sys/v1/session/auth" && r.Header.Get("Authorization") == "Bearer revoked" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("invalid token"))
+			return
+		}
+		mock.ServeHTTP(w, r)
+	}))
+	cred := &appCredential{Name: "jwt"}
+	ctx := context.Background()
+
+	client := sdkmsClient()
+	assert.EqualError(t, authenticateApp(&client, cred, false), "--jwt is required for JWT authentication")
+
+	jwtToken = "header.payload.signature"
+	require.NoError(t, authenticateApp(&client, cred, false))
+	assert.Equal(t, sdkms.BearerToken(jwtToken), client.Auth)
+	_, err := client.GetSobject(ctx, nil, *sdkms.SobjectByID(kid))
+	assert.NoError(t, err)
+
+	client = sdkmsClient()
+	require.NoError(t, authenticateApp(&client, cred, true))
+	bearer, ok := client.Auth.(sdkms.BearerToken)
+	require.True(t, ok)
+	assert.NotEqual(t, jwtToken, string(bearer), "exchanged for a session token")
+	_, err = client.GetSobject(ctx, nil, *sdkms.SobjectByID(kid))
+	assert.NoError(t, err)
+
+	jwtToken = "revoked"
+	client = sdkmsClient()
+	err = authenticateApp(&client, cred, true)
+	assert.True(t, isUnauthorizedError(err), "%v", err)
+}
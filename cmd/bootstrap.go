@@ -0,0 +1,180 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	significantRegression  string = "significant regression"
+	significantImprovement string = "significant improvement"
+	inconclusive           string = "inconclusive"
+)
+
+// bootstrapMetrics are the latency metrics with bootstrap confidence intervals.
+var bootstrapMetrics = []string{"avg", "p50", "p75", "p90", "p95", "p99"}
+
+// ConfidenceInterval is a bootstrap confidence interval.
+type ConfidenceInterval struct {
+	Level float64 `json:"level" yaml:"level"` // Confidence level, e.g. 0.95
+	Low   float64 `json:"low" yaml:"low"`
+	High  float64 `json:"high" yaml:"high"`
+}
+
+func (ci *ConfidenceInterval) String() string {
+	return fmt.Sprintf("[%+.3fms, %+.3fms]", ci.Low/1e6, ci.High/1e6)
+}
+
+// latencySample holds the latencies of a run as sorted distinct values. It is
+// built from raw latencies or from a histogram, in which case the bucket
+// midpoints stand for the latencies of a bucket.
+type latencySample struct {
+	values []float64
+	// owner maps the position of each latency in sorted order to its value,
+	// so a uniformly random latency is drawn in constant time.
+	owner []int32
+}
+
+func sampleFromRaw(data []float64) *latencySample {
+	sorted := append([]float64(nil), data...)
+	sort.Float64s(sorted)
+	s := &latencySample{}
+	for _, v := range sorted {
+		if n := len(s.values); n == 0 || s.values[n-1] != v {
+			s.values = append(s.values, v)
+		}
+		s.owner = append(s.owner, int32(len(s.values)-1))
+	}
+	return s
+}
+
+func sampleFromHistogram(h *LatencyHistogram) *latencySample {
+	s := &latencySample{}
+	for i := range h.Buckets {
+		s.values = append(s.values, h.Buckets[i].midpoint())
+		for c := uint64(0); c < h.Buckets[i].Count; c++ {
+			s.owner = append(s.owner, int32(i))
+		}
+	}
+	return s
+}
+
+// latencySampleOf returns the latency data of a result, raw latencies are
+// preferred over the histogram. It returns nil if the result has neither.
+func latencySampleOf(result *TestResult) *latencySample {
+	switch {
+	case len(result.TestLatencies) != 0:
+		return sampleFromRaw(result.TestLatencies)
+	case result.TestHistogram != nil && result.TestHistogram.Count() != 0:
+		return sampleFromHistogram(result.TestHistogram)
+	default:
+		return nil
+	}
+}
+
+// resample draws as many latencies as the sample has with replacement and stores how many times
+// each value was drawn in counts.
+func (s *latencySample) resample(r *rand.Rand, counts []uint64) {
+	for i := range counts {
+		counts[i] = 0
+	}
+	n := len(s.owner)
+	for i := 0; i < n; i++ {
+		counts[s.owner[r.Intn(n)]]++
+	}
+}
+
+// estimate computes the metric, one of bootstrapMetrics, of the latencies
+// given by counts per value.
+func (s *latencySample) estimate(metric string, counts []uint64) float64 {
+	var n uint64
+	for _, c := range counts {
+		n += c
+	}
+	if metric == "avg" {
+		var sum float64
+		for i, c := range counts {
+			sum += s.values[i] * float64(c)
+		}
+		return sum / float64(n)
+	}
+	percent, _ := strconv.ParseFloat(strings.TrimPrefix(metric, "p"), 64)
+	// nearest rank percentile
+	rank := uint64(math.Ceil(percent / 100 * float64(n)))
+	if rank == 0 {
+		rank = 1
+	}
+	var cumulative uint64
+	for i, c := range counts {
+		cumulative += c
+		if cumulative >= rank {
+			return s.values[i]
+		}
+	}
+	return s.values[len(s.values)-1]
+}
+
+// bootstrapDeltas estimates confidence intervals of each metric of both
+// samples and of the difference candidate - baseline by resampling both
+// samples independently the given number of times.
+func bootstrapDeltas(baseline *latencySample, candidate *latencySample, metrics []string, iterations int, level float64, r *rand.Rand) (b, c, delta map[string]*ConfidenceInterval) {
+	bEstimates := make(map[string][]float64)
+	cEstimates := make(map[string][]float64)
+	deltas := make(map[string][]float64)
+	bCounts := make([]uint64, len(baseline.values))
+	cCounts := make([]uint64, len(candidate.values))
+	for i := 0; i < iterations; i++ {
+		baseline.resample(r, bCounts)
+		candidate.resample(r, cCounts)
+		for _, metric := range metrics {
+			be, ce := baseline.estimate(metric, bCounts), candidate.estimate(metric, cCounts)
+			bEstimates[metric] = append(bEstimates[metric], be)
+			cEstimates[metric] = append(cEstimates[metric], ce)
+			deltas[metric] = append(deltas[metric], ce-be)
+		}
+	}
+	b = make(map[string]*ConfidenceInterval)
+	c = make(map[string]*ConfidenceInterval)
+	delta = make(map[string]*ConfidenceInterval)
+	for _, metric := range metrics {
+		b[metric] = percentileInterval(bEstimates[metric], level)
+		c[metric] = percentileInterval(cEstimates[metric], level)
+		delta[metric] = percentileInterval(deltas[metric], level)
+	}
+	return b, c, delta
+}
+
+// percentileInterval returns the percentile bootstrap interval of the given
+// estimates, which are sorted in place.
+func percentileInterval(estimates []float64, level float64) *ConfidenceInterval {
+	sort.Float64s(estimates)
+	alpha := (1 - level) / 2
+	at := func(q float64) float64 {
+		i := int(math.Floor(q * float64(len(estimates)-1)))
+		return estimates[i]
+	}
+	return &ConfidenceInterval{Level: level, Low: at(alpha), High: at(1 - alpha)}
+}
+
+// significance classifies a confidence interval of a latency difference,
+// an interval entirely above zero is a significant regression.
+func significance(delta *ConfidenceInterval) string {
+	switch {
+	case delta.Low > 0:
+		return significantRegression
+	case delta.High < 0:
+		return significantImprovement
+	default:
+		return inconclusive
+	}
+}
@@ -0,0 +1,119 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const testCertificateValidity = 365 * 24 * time.Hour
+
+// testCertificate is a certificate generated locally for certificate based
+// app authentication, together with its private key.
+type testCertificate struct {
+	Cert *x509.Certificate
+	Der  []byte
+	Key  *ecdsa.PrivateKey
+}
+
+// generateTestCA creates a self-signed CA certificate with the given common name.
+func generateTestCA(commonName string) (*testCertificate, error) {
+	template := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: commonName},
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return createTestCertificate(template, nil)
+}
+
+// issueClientCertificate creates a TLS client certificate with the given
+// common name signed by ca.
+func issueClientCertificate(ca *testCertificate, commonName string) (*testCertificate, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: commonName},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return createTestCertificate(template, ca)
+}
+
+// issueServerCertificate creates a TLS server certificate for the given host
+// names and IP addresses signed by ca.
+func issueServerCertificate(ca *testCertificate, hosts []string) (*testCertificate, error) {
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: hosts[0]},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return createTestCertificate(template, ca)
+}
+
+func createTestCertificate(template *x509.Certificate, issuer *testCertificate) (*testCertificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(testCertificateValidity)
+
+	parent := template
+	var signer crypto.Signer = key
+	if issuer != nil {
+		parent = issuer.Cert
+		signer = issuer.Key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &testCertificate{Cert: cert, Der: der, Key: key}, nil
+}
+
+// writeCertificatePEM writes the certificate to certFile and, if keyFile is
+// not empty, its private key to keyFile.
+func (tc *testCertificate) writeCertificatePEM(certFile string, keyFile string) error {
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.Der})
+	if err := os.WriteFile(certFile, certPem, 0644); err != nil {
+		return err
+	}
+	if keyFile == "" {
+		return nil
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(tc.Key)
+	if err != nil {
+		return err
+	}
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer})
+	return os.WriteFile(keyFile, keyPem, 0600)
+}
@@ -0,0 +1,39 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIssueClientCertificate(t *testing.T) {
+	ca, err := generateTestCA("test CA")
+	assert.NoError(t, err)
+	clientCert, err := issueClientCertificate(ca, "test client")
+	assert.NoError(t, err)
+	assert.Equal(t, "test client", clientCert.Cert.Subject.CommonName)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	_, err = clientCert.Cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	assert.NoError(t, clientCert.writeCertificatePEM(certFile, keyFile))
+	_, err = tls.LoadX509KeyPair(certFile, keyFile)
+	assert.NoError(t, err)
+}
@@ -30,19 +30,28 @@ func sdkmsClient() sdkms.Client {
 	// same values as http.DefaultTransport unless noted explicitly
 	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
-		DialContext: (&net.Dialer{
+		DialContext: trackingDialer((&net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 30 * time.Second,
 			DualStack: true,
-		}).DialContext,
-		MaxIdleConns:          100,
+		}).DialContext, !newConnectionPerTestRequest), // counts the connections
+		MaxIdleConns:          maxIdleConnections,
+		MaxIdleConnsPerHost:   maxIdleConnections,    // different from http.DefaultTransport (2)
 		IdleConnTimeout:       idleConnectionTimeout, // different from http.DefaultTransport (90 sec)
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
+		DisableKeepAlives:     newConnectionPerTestRequest,
 	}
-	if insecureTLS {
-		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	config, err := tlsClientConfig()
+	if err != nil {
+		log.Fatalf("Invalid TLS configuration: %v\n", err)
+	}
+	transport.TLSClientConfig = config.Clone()
+	if tlsSessionResumption {
+		// each client has its own cache, like a separate process would
+		transport.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(0)
 	}
+	configureHTTPVersion(transport)
 	httpClient := &http.Client{
 		Transport: transport,
 		Timeout:   requestTimeout,
@@ -176,7 +185,12 @@ func Max(a, b int) int {
 // If an error occurs during the retrieval process, the function will log a fatal error and exit.
 func GetSobject(kid *string) *sdkms.Sobject {
 	client := sdkmsClient()
-	client.Auth = sdkms.APIKey(apiKey)
+	if err := authenticateApp(&client, firstAppCredential(), false); err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	if appSessionRequired() {
+		defer client.TerminateSession(context.Background())
+	}
 	key, err := client.GetSobject(context.Background(), nil, sdkms.SobjectDescriptor{
 		Kid: kid,
 	})
@@ -0,0 +1,134 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var compareQPSTolerance float64
+var compareLatencyTolerance float64
+var compareProfilingTolerance float64
+var compareMinLatencyDelta time.Duration
+var compareGateMetrics []string
+var compareForce bool
+var compareConfidence float64
+var compareBootstrapIterations int
+
+var compareCmd = &cobra.Command{
+	Use:   "compare baseline.json candidate.json",
+	Short: "Compare two load test results",
+	Long: `Compare two load test results written with --output-format json.
+
+The test statistic and all profiling statistics, including additional ones,
+are aligned and reported with absolute and percentage deltas. The command
+exits with status 1 if any checked metric regressed by more than its
+tolerance. If both results contain latency data (a histogram, or raw latencies
+with --store-latencies), bootstrap confidence intervals of the test latency
+changes are computed, and a change beyond the tolerance only counts if it is
+significant. Results of tests with a different name, target QPS, number of
+connections or key type are refused unless --force is given.`,
+	Args: cobra.ExactArgs(2),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		for _, metric := range compareGateMetrics {
+			if !isLatencyMetric(metric) {
+				return fmt.Errorf("unknown latency metric: %v, supported: %v", metric, strings.Join(latencyMetrics, ", "))
+			}
+		}
+		if compareConfidence <= 0 || compareConfidence >= 1 {
+			return fmt.Errorf("confidence must be between 0 and 1: %v", compareConfidence)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		compareResults(args[0], args[1])
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+
+	compareCmd.Flags().Float64Var(&compareQPSTolerance, "qps-tolerance", 5, "Allowed QPS decrease in percent, 0 disables the check")
+	compareCmd.Flags().Float64Var(&compareLatencyTolerance, "latency-tolerance", 10, "Allowed latency increase in percent, 0 disables the check")
+	compareCmd.Flags().Float64Var(&compareProfilingTolerance, "profiling-tolerance", 0, "Allowed increase of profiling stages in percent, 0 disables the check")
+	compareCmd.Flags().DurationVar(&compareMinLatencyDelta, "min-latency-delta", 0, "Latency changes smaller than this are never a regression")
+	compareCmd.Flags().StringSliceVar(&compareGateMetrics, "gate-metrics", []string{"p50", "p95", "p99"}, "Latency metrics checked against the tolerances")
+	compareCmd.Flags().Float64Var(&compareConfidence, "confidence", 0.95, "Level of the bootstrap confidence intervals of test latencies")
+	compareCmd.Flags().IntVar(&compareBootstrapIterations, "bootstrap-iterations", 1000, "Number of bootstrap resamples, 0 disables confidence intervals")
+	compareCmd.Flags().BoolVar(&compareForce, "force", false, "Compare results even if their test configurations differ")
+}
+
+func isLatencyMetric(metric string) bool {
+	for _, m := range latencyMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+func compareResults(baselineFile string, candidateFile string) {
+	baseline, err := readTestSummaryFile(baselineFile)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	candidate, err := readTestSummaryFile(candidateFile)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	comparison := compareTestSummaries(baseline, candidate, ComparisonThresholds{
+		QPSTolerance:        compareQPSTolerance,
+		LatencyTolerance:    compareLatencyTolerance,
+		ProfilingTolerance:  compareProfilingTolerance,
+		MinLatencyDelta:     float64(compareMinLatencyDelta),
+		GateMetrics:         compareGateMetrics,
+		Confidence:          compareConfidence,
+		BootstrapIterations: compareBootstrapIterations,
+	})
+	comparison.Baseline = baselineFile
+	comparison.Candidate = candidateFile
+	if len(comparison.Mismatches) != 0 {
+		for _, m := range comparison.Mismatches {
+			log.Printf("Config mismatch: %v\n", m)
+		}
+		if !compareForce {
+			log.Fatalf("Refusing to compare results of different test configurations, use --force to compare anyway\n")
+		}
+	}
+
+	switch outputFormat {
+	case Plain:
+		err = comparison.WritePlain(os.Stdout)
+	case JSON:
+		err = comparison.WriteJson(os.Stdout)
+	case JUnit:
+		err = comparison.WriteJUnit(os.Stdout)
+	case Markdown:
+		err = comparison.WriteMarkdown(os.Stdout)
+	case CSV:
+		err = comparison.WriteCsv(os.Stdout)
+	// TODO: See issue: #19
+	case YAML:
+		log.Fatalf("write comparison in yaml is not yet supported\n")
+	default:
+		log.Fatalf("unreachable: unacceptable output format option: %v\n", outputFormat)
+	}
+	if err != nil {
+		log.Fatalf("failed to write comparison: %v\n", err)
+	}
+	if comparison.Regressions > 0 {
+		log.Printf("%d regression(s) found\n", comparison.Regressions)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,394 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+)
+
+const (
+	verdictOk          string = "ok"
+	verdictRegression  string = "regression"
+	verdictImprovement string = "improvement"
+)
+
+// latencyMetrics are the latency fields of a Statistic that can be compared,
+// in the order they are reported.
+var latencyMetrics = []string{"avg", "min", "max", "p50", "p75", "p90", "p95", "p99", "sd"}
+
+// ComparisonThresholds decides which deltas between two results count as a
+// regression. Tolerances are in percent, a tolerance of 0 disables the check.
+type ComparisonThresholds struct {
+	QPSTolerance        float64  `json:"qps_tolerance" yaml:"qps_tolerance"`               // Allowed QPS decrease of the test
+	LatencyTolerance    float64  `json:"latency_tolerance" yaml:"latency_tolerance"`       // Allowed latency increase of the test
+	ProfilingTolerance  float64  `json:"profiling_tolerance" yaml:"profiling_tolerance"`   // Allowed increase of profiling stages
+	MinLatencyDelta     float64  `json:"min_latency_delta" yaml:"min_latency_delta"`       // Latency deltas below this (in nanoseconds) are never a regression
+	GateMetrics         []string `json:"gate_metrics" yaml:"gate_metrics"`                 // Latency metrics checked against the tolerances
+	Confidence          float64  `json:"confidence" yaml:"confidence"`                     // Level of the bootstrap confidence intervals
+	BootstrapIterations int      `json:"bootstrap_iterations" yaml:"bootstrap_iterations"` // Number of bootstrap resamples, 0 disables confidence intervals
+}
+
+// MetricDelta is the change of one metric between the baseline and the
+// candidate result.
+type MetricDelta struct {
+	Metric       string   `json:"metric" yaml:"metric"`
+	Unit         string   `json:"unit" yaml:"unit"`
+	Baseline     float64  `json:"baseline" yaml:"baseline"`
+	Candidate    float64  `json:"candidate" yaml:"candidate"`
+	Delta        float64  `json:"delta" yaml:"delta"`
+	DeltaPercent *float64 `json:"delta_percent" yaml:"delta_percent"`             // nil if the baseline is 0
+	Tolerance    *float64 `json:"tolerance,omitempty" yaml:"tolerance,omitempty"` // nil if the metric is not checked
+	Verdict      string   `json:"verdict,omitempty" yaml:"verdict,omitempty"`     // empty if the metric is not checked
+	// Bootstrap confidence intervals, only for test latencies when both
+	// results have raw or histogram latency data
+	BaselineCI   *ConfidenceInterval `json:"baseline_ci,omitempty" yaml:"baseline_ci,omitempty"`
+	CandidateCI  *ConfidenceInterval `json:"candidate_ci,omitempty" yaml:"candidate_ci,omitempty"`
+	DeltaCI      *ConfidenceInterval `json:"delta_ci,omitempty" yaml:"delta_ci,omitempty"`
+	Significance string              `json:"significance,omitempty" yaml:"significance,omitempty"`
+}
+
+// Comparison is the result of comparing a candidate load test result with a
+// baseline.
+type Comparison struct {
+	Baseline    string               `json:"baseline" yaml:"baseline"`
+	Candidate   string               `json:"candidate" yaml:"candidate"`
+	Thresholds  ComparisonThresholds `json:"thresholds" yaml:"thresholds"`
+	Mismatches  []string             `json:"config_mismatches" yaml:"config_mismatches"`
+	Warnings    []string             `json:"warnings" yaml:"warnings"`
+	Deltas      []MetricDelta        `json:"deltas" yaml:"deltas"`
+	Regressions uint                 `json:"regressions" yaml:"regressions"`
+}
+
+// readTestSummaryFile reads a test summary written with --output-format json.
+func readTestSummaryFile(path string) (*TestSummary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTestSummary(data, path)
+}
+
+// parseTestSummary parses a test summary written with --output-format json,
+// name is used in errors.
+func parseTestSummary(data []byte, name string) (*TestSummary, error) {
+	var summary TestSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %v", name, err)
+	}
+	var repeated RepeatedTestSummary
+	if json.Unmarshal(data, &repeated) == nil && len(repeated.Trials) != 0 {
+		return nil, fmt.Errorf("%v is the result of %d repeated trials, only single results can be compared", name, len(repeated.Trials))
+	}
+	if summary.Config == nil || summary.Result == nil || summary.Result.Test == nil {
+		return nil, fmt.Errorf("%v is not a load test result", name)
+	}
+	return &summary, nil
+}
+
+// configMismatches lists the test configuration values that differ between
+// the two results in a way that makes their statistics incomparable.
+func configMismatches(baseline *TestConfig, candidate *TestConfig) []string {
+	var mismatches []string
+	if baseline.TestName != candidate.TestName {
+		mismatches = append(mismatches, fmt.Sprintf("test name: %q vs %q", baseline.TestName, candidate.TestName))
+	}
+	if baseline.TargetQPS != candidate.TargetQPS {
+		mismatches = append(mismatches, fmt.Sprintf("target QPS: %v vs %v", baseline.TargetQPS, candidate.TargetQPS))
+	}
+	if baseline.Connections != candidate.Connections {
+		mismatches = append(mismatches, fmt.Sprintf("connections: %v vs %v", baseline.Connections, candidate.Connections))
+	}
+	if b, c := describeKeyType(baseline.Sobject), describeKeyType(candidate.Sobject); b != c {
+		mismatches = append(mismatches, fmt.Sprintf("key type: %v vs %v", b, c))
+	}
+	return mismatches
+}
+
+func describeKeyType(sobject *sdkms.Sobject) string {
+	if sobject == nil {
+		return "none"
+	}
+	desc := string(sobject.ObjType)
+	if sobject.KeySize != nil {
+		desc += fmt.Sprintf(" %d", *sobject.KeySize)
+	}
+	if sobject.EllipticCurve != nil {
+		desc += " " + string(*sobject.EllipticCurve)
+	}
+	return desc
+}
+
+// compareTestSummaries compares the candidate with the baseline, aligning the
+// test statistic and all profiling statistics including additional ones.
+func compareTestSummaries(baseline *TestSummary, candidate *TestSummary, thresholds ComparisonThresholds) *Comparison {
+	c := &Comparison{
+		Thresholds: thresholds,
+		Mismatches: configMismatches(baseline.Config, candidate.Config),
+	}
+	c.compareStatistic("test", baseline.Result.Test, candidate.Result.Test, thresholds.QPSTolerance, thresholds.LatencyTolerance)
+	if thresholds.BootstrapIterations > 0 {
+		bs, cs := latencySampleOf(baseline.Result), latencySampleOf(candidate.Result)
+		if bs != nil && cs != nil {
+			c.addConfidenceIntervals("test", bs, cs)
+		} else {
+			c.Warnings = append(c.Warnings, "latency data is missing, significance of test latency changes is not computed")
+		}
+	}
+
+	bp, cp := baseline.Result.ProfilingResults, candidate.Result.ProfilingResults
+	switch {
+	case bp == nil && cp == nil:
+	case bp == nil:
+		c.Warnings = append(c.Warnings, "profiling data is only available in the candidate")
+	case cp == nil:
+		c.Warnings = append(c.Warnings, "profiling data is only available in the baseline")
+	default:
+		bStages, cStages := profilingStages(bp), profilingStages(cp)
+		for _, stage := range alignStages(bStages, cStages, &c.Warnings) {
+			c.compareStatistic("profiling."+stage, bStages[stage], cStages[stage], 0, thresholds.ProfilingTolerance)
+		}
+	}
+	for _, d := range c.Deltas {
+		if d.Verdict == verdictRegression {
+			c.Regressions++
+		}
+	}
+	return c
+}
+
+// profilingStages returns all profiling statistics by name, the fixed stages
+// use their JSON names and additional stages their /action/sub_action path.
+func profilingStages(ps *ProfilingStatistics) map[string]*Statistic {
+	stages := map[string]*Statistic{
+		"in_queue":       &ps.InQueue,
+		"parse_request":  &ps.ParseRequest,
+		"session_lookup": &ps.SessionLookup,
+		"validate_input": &ps.ValidateInput,
+		"check_access":   &ps.CheckAccess,
+		"operate":        &ps.Operate,
+		"db_flush":       &ps.DbFlush,
+		"total":          &ps.Total,
+	}
+	for key, value := range ps.Additional {
+		value := value
+		stages[key] = &value
+	}
+	return stages
+}
+
+var fixedProfilingStages = []string{"in_queue", "parse_request", "session_lookup", "validate_input", "check_access", "operate", "db_flush", "total"}
+
+// alignStages returns the stages present in both results, fixed stages first
+// and additional stages sorted. Stages present in only one are warned about.
+func alignStages(baseline map[string]*Statistic, candidate map[string]*Statistic, warnings *[]string) []string {
+	stages := append([]string(nil), fixedProfilingStages...)
+	var additional []string
+	for key := range baseline {
+		if _, ok := candidate[key]; ok && !isFixedProfilingStage(key) {
+			additional = append(additional, key)
+		} else if !ok {
+			*warnings = append(*warnings, fmt.Sprintf("profiling stage %v is only available in the baseline", key))
+		}
+	}
+	for key := range candidate {
+		if _, ok := baseline[key]; !ok {
+			*warnings = append(*warnings, fmt.Sprintf("profiling stage %v is only available in the candidate", key))
+		}
+	}
+	sort.Strings(additional)
+	sort.Strings(*warnings)
+	return append(stages, additional...)
+}
+
+func isFixedProfilingStage(stage string) bool {
+	for _, s := range fixedProfilingStages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// compareStatistic adds the deltas of all fields of a statistic, QPS and the
+// gated latency metrics are judged against the given tolerances.
+func (c *Comparison) compareStatistic(prefix string, baseline *Statistic, candidate *Statistic, qpsTolerance float64, tolerance float64) {
+	c.Deltas = append(c.Deltas, newMetricDelta(prefix+".query_number", "count", float64(baseline.QueryNumber), float64(candidate.QueryNumber)))
+	if baseline.QPS != nil && candidate.QPS != nil {
+		d := newMetricDelta(prefix+".qps", "qps", *baseline.QPS, *candidate.QPS)
+		if qpsTolerance > 0 {
+			d.judge(qpsTolerance, true, 0)
+		}
+		c.Deltas = append(c.Deltas, d)
+	}
+	for _, metric := range latencyMetrics {
+		d := newMetricDelta(prefix+"."+metric, "ns", latencyMetric(baseline, metric), latencyMetric(candidate, metric))
+		if tolerance > 0 && c.isGated(metric) {
+			d.judge(tolerance, false, c.Thresholds.MinLatencyDelta)
+		}
+		c.Deltas = append(c.Deltas, d)
+	}
+}
+
+// addConfidenceIntervals bootstraps confidence intervals of the latency
+// metrics of the statistic with the given prefix. A change beyond the
+// tolerance then only counts as a regression or improvement if significant.
+func (c *Comparison) addConfidenceIntervals(prefix string, baseline *latencySample, candidate *latencySample) {
+	r := rand.New(rand.NewSource(1)) // fixed seed, so comparisons are reproducible
+	bCI, cCI, deltaCI := bootstrapDeltas(baseline, candidate, bootstrapMetrics, c.Thresholds.BootstrapIterations, c.Thresholds.Confidence, r)
+	for i := range c.Deltas {
+		d := &c.Deltas[i]
+		metric := strings.TrimPrefix(d.Metric, prefix+".")
+		if _, ok := deltaCI[metric]; !ok || !strings.HasPrefix(d.Metric, prefix+".") {
+			continue
+		}
+		d.BaselineCI, d.CandidateCI, d.DeltaCI = bCI[metric], cCI[metric], deltaCI[metric]
+		d.Significance = significance(d.DeltaCI)
+		switch {
+		case d.Verdict == verdictRegression && d.Significance != significantRegression:
+			d.Verdict = verdictOk
+		case d.Verdict == verdictImprovement && d.Significance != significantImprovement:
+			d.Verdict = verdictOk
+		}
+	}
+}
+
+func (c *Comparison) isGated(metric string) bool {
+	for _, m := range c.Thresholds.GateMetrics {
+		if m == metric {
+			return true
+		}
+	}
+	return false
+}
+
+func latencyMetric(st *Statistic, metric string) float64 {
+	switch metric {
+	case "avg":
+		return st.Avg
+	case "min":
+		return st.Min
+	case "max":
+		return st.Max
+	case "p50":
+		return st.P50
+	case "p75":
+		return st.P75
+	case "p90":
+		return st.P90
+	case "p95":
+		return st.P95
+	case "p99":
+		return st.P99
+	case "sd":
+		return st.Sd
+	default:
+		panic("unknown latency metric: " + metric)
+	}
+}
+
+func newMetricDelta(metric string, unit string, baseline float64, candidate float64) MetricDelta {
+	d := MetricDelta{
+		Metric:    metric,
+		Unit:      unit,
+		Baseline:  baseline,
+		Candidate: candidate,
+		Delta:     candidate - baseline,
+	}
+	if baseline != 0 {
+		p := d.Delta / baseline * 100
+		d.DeltaPercent = &p
+	}
+	return d
+}
+
+// judge sets the verdict of the delta given the tolerance in percent. When
+// higherIsBetter is false an increase is a regression. Deltas smaller than
+// minDelta in absolute value are always ok.
+func (d *MetricDelta) judge(tolerance float64, higherIsBetter bool, minDelta float64) {
+	d.Tolerance = &tolerance
+	d.Verdict = verdictOk
+	if d.DeltaPercent == nil || math.Abs(d.Delta) < minDelta {
+		return
+	}
+	change := *d.DeltaPercent
+	if higherIsBetter {
+		change = -change
+	}
+	switch {
+	case change > tolerance:
+		d.Verdict = verdictRegression
+	case change < -tolerance:
+		d.Verdict = verdictImprovement
+	}
+}
+
+func (c *Comparison) WritePlain(w io.Writer) error {
+	fmt.Fprintf(w, "----- Comparison -----\n")
+	fmt.Fprintf(w, "Baseline:  %s\n", c.Baseline)
+	fmt.Fprintf(w, "Candidate: %s\n", c.Candidate)
+	for _, m := range c.Mismatches {
+		fmt.Fprintf(w, "Config mismatch: %s\n", m)
+	}
+	for _, warning := range c.Warnings {
+		fmt.Fprintf(w, "Warning: %s\n", warning)
+	}
+	fmt.Fprintf(w, "\n")
+	maxKeyLen := len("Metric")
+	for _, d := range c.Deltas {
+		maxKeyLen = Max(maxKeyLen, len(d.Metric))
+	}
+	pad := "RIGHT"
+	fmt.Fprintf(w, "%s  %12s  %12s  %12s  %9s  %-11s  %s\n", StrPad("Metric", maxKeyLen, " ", pad), "Baseline", "Candidate", "Delta", "Delta%", "Verdict", "Significance")
+	for _, d := range c.Deltas {
+		percent := "n/a"
+		if d.DeltaPercent != nil {
+			percent = fmt.Sprintf("%+.2f%%", *d.DeltaPercent)
+		}
+		sig := ""
+		if d.DeltaCI != nil {
+			sig = fmt.Sprintf("%s, %.0f%% CI of delta %s", d.Significance, d.DeltaCI.Level*100, d.DeltaCI.String())
+		}
+		fmt.Fprintf(w, "%s  %12s  %12s  %12s  %9s  %-11s  %s\n", StrPad(d.Metric, maxKeyLen, " ", pad),
+			formatMetricValue(d.Baseline, d.Unit, false), formatMetricValue(d.Candidate, d.Unit, false),
+			formatMetricValue(d.Delta, d.Unit, true), percent, d.Verdict, sig)
+	}
+	fmt.Fprintf(w, "\nRegressions: %d\n", c.Regressions)
+	return nil
+}
+
+func (c *Comparison) WriteJson(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(c)
+}
+
+func formatMetricValue(v float64, unit string, signed bool) string {
+	format := "%.3f"
+	if signed {
+		format = "%+.3f"
+	}
+	switch unit {
+	case "ns":
+		return fmt.Sprintf(format+"ms", v/1e6)
+	case "count":
+		if signed {
+			return fmt.Sprintf("%+.0f", v)
+		}
+		return fmt.Sprintf("%.0f", v)
+	default:
+		return fmt.Sprintf(format, v)
+	}
+}
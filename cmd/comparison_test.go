@@ -0,0 +1,112 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func findDelta(c *Comparison, metric string) *MetricDelta {
+	for i := range c.Deltas {
+		if c.Deltas[i].Metric == metric {
+			return &c.Deltas[i]
+		}
+	}
+	return nil
+}
+
+func TestCompareTestSummaries(t *testing.T) {
+	baseline := newTestSummary()
+	candidate := newTestSummary()
+	*candidate.Result.Test = *baseline.Result.Test
+	qps := *baseline.Result.Test.QPS * 0.5
+	candidate.Result.Test.QPS = &qps
+	candidate.Result.Test.P99 = baseline.Result.Test.P99 * 1.05
+	candidate.Result.Test.P50 = baseline.Result.Test.P50 * 0.5
+	baseline.Result.ProfilingResults.Additional = map[string]Statistic{"/plugin": *newRandomStatistic(), "/old": *newRandomStatistic()}
+	candidate.Result.ProfilingResults.Additional = map[string]Statistic{"/plugin": *newRandomStatistic()}
+
+	c := compareTestSummaries(baseline, candidate, ComparisonThresholds{
+		QPSTolerance:     5,
+		LatencyTolerance: 10,
+		GateMetrics:      []string{"p50", "p99"},
+	})
+	assert.Empty(t, c.Mismatches)
+	assert.Equal(t, []string{"profiling stage /old is only available in the baseline"}, c.Warnings)
+
+	assert.Equal(t, verdictRegression, findDelta(c, "test.qps").Verdict)
+	assert.InDelta(t, -50, *findDelta(c, "test.qps").DeltaPercent, 1e-9)
+	assert.Equal(t, verdictOk, findDelta(c, "test.p99").Verdict)
+	assert.Equal(t, verdictImprovement, findDelta(c, "test.p50").Verdict)
+	assert.Empty(t, findDelta(c, "test.max").Verdict)
+	assert.NotNil(t, findDelta(c, "profiling.total.p99"))
+	assert.NotNil(t, findDelta(c, "profiling./plugin.p99"))
+	assert.Nil(t, findDelta(c, "profiling./old.p99"))
+	assert.Equal(t, uint(1), c.Regressions)
+
+	candidate.Config.TargetQPS = 2 * baseline.Config.TargetQPS
+	c = compareTestSummaries(baseline, candidate, ComparisonThresholds{})
+	assert.Len(t, c.Mismatches, 1)
+	assert.Equal(t, uint(0), c.Regressions)
+}
+
+func TestCompareSignificance(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	latencies := func(mean float64) []float64 {
+		var data []float64
+		for i := 0; i < 2000; i++ {
+			data = append(data, mean+r.NormFloat64()*mean/10)
+		}
+		return data
+	}
+	summary := func(data []float64) *TestSummary {
+		s := newTestSummary()
+		s.Result.Test = StatisticFromFloat64Data(data, nil)
+		s.Result.TestHistogram = HistogramFromFloat64Data(data)
+		s.Result.ProfilingResults = nil
+		return s
+	}
+	thresholds := ComparisonThresholds{LatencyTolerance: 1, GateMetrics: []string{"p50"}, Confidence: 0.95, BootstrapIterations: 200}
+
+	c := compareTestSummaries(summary(latencies(1e6)), summary(latencies(1.1e6)), thresholds)
+	assert.Equal(t, significantRegression, findDelta(c, "test.p50").Significance)
+	assert.Equal(t, verdictRegression, findDelta(c, "test.p50").Verdict)
+
+	c = compareTestSummaries(summary(latencies(1e6)), summary(latencies(0.9e6)), thresholds)
+	assert.Equal(t, significantImprovement, findDelta(c, "test.avg").Significance)
+
+	baseline := summary(latencies(1e6))
+	baseline.Result.TestLatencies = latencies(1e6)
+	candidate := summary(latencies(1e6))
+	candidate.Result.TestLatencies = latencies(1e6)
+	candidate.Result.Test.P50 = baseline.Result.Test.P50 * 1.02
+	c = compareTestSummaries(baseline, candidate, thresholds)
+	assert.Equal(t, inconclusive, findDelta(c, "test.p50").Significance)
+	assert.Equal(t, verdictOk, findDelta(c, "test.p50").Verdict)
+	assert.Nil(t, findDelta(c, "test.max").DeltaCI)
+}
+
+func TestParseTestSummary(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, newTestSummary().WriteJson(&buf))
+	summary, err := parseTestSummary(buf.Bytes(), "single.json")
+	require.NoError(t, err)
+	assert.Equal(t, "Test1", summary.Config.TestName)
+
+	buf.Reset()
+	require.NoError(t, newRepeatedTestSummary([]*TestSummary{newTestSummary(), newTestSummary()}).WriteJson(&buf))
+	_, err = parseTestSummary(buf.Bytes(), "repeated.json")
+	assert.EqualError(t, err, "repeated.json is the result of 2 repeated trials, only single results can be compared")
+
+	_, err = parseTestSummary([]byte(`{"config": {}}`), "other.json")
+	assert.EqualError(t, err, "other.json is not a load test result")
+}
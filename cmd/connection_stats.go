@@ -0,0 +1,196 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var httpVersion = httpVersion11
+var tcpConnections uint
+var maxIdleConnections int
+
+type httpVersionOpt string
+
+const (
+	httpVersion11 httpVersionOpt = "1.1"
+	httpVersion2  httpVersionOpt = "2"
+)
+
+// impl pflag.Value interface for httpVersionOpt
+
+func (v *httpVersionOpt) String() string {
+	return string(*v)
+}
+
+func (v *httpVersionOpt) Set(s string) error {
+	switch s {
+	case "1.1", "http/1.1", "HTTP/1.1":
+		*v = httpVersion11
+	case "2", "h2", "http/2", "HTTP/2":
+		*v = httpVersion2
+	default:
+		return fmt.Errorf("invalid HTTP version: %v", s)
+	}
+	return nil
+}
+
+func (v *httpVersionOpt) Type() string {
+	return "HTTPVersion"
+}
+
+// configureHTTPVersion makes the transport use only the selected HTTP version.
+func configureHTTPVersion(transport *http.Transport) {
+	if httpVersion == httpVersion2 {
+		transport.ForceAttemptHTTP2 = true
+	} else {
+		// a non-nil empty map disables HTTP/2
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+}
+
+// ConnectionStatistics counts the TCP connections of the clients while the
+// test runs.
+type ConnectionStatistics struct {
+	Dials          uint64 `json:"dials" yaml:"dials"`                       // New connections
+	Reused         uint64 `json:"reused" yaml:"reused"`                     // Requests sent on an existing connection
+	IdleEvicted    uint64 `json:"idle_evicted" yaml:"idle_evicted"`         // Idle connections closed by the client, e.g. after --idle-connection-timeout or above --max-idle-connections
+	ClosedByServer uint64 `json:"closed_by_server" yaml:"closed_by_server"` // Connections closed by the server
+	ClosedInUse    uint64 `json:"closed_in_use" yaml:"closed_in_use"`       // Connections closed by the client during a request, e.g. after an error, or after each request if keep-alives are disabled
+	Open           int64  `json:"open" yaml:"open"`                         // Connections open at the end of the test
+}
+
+func (cs *ConnectionStatistics) Print(w io.Writer) {
+	fmt.Fprintf(w, "Dials: %d, Reused: %d, IdleEvicted: %d, ClosedByServer: %d, ClosedInUse: %d, Open: %d\n",
+		cs.Dials, cs.Reused, cs.IdleEvicted, cs.ClosedByServer, cs.ClosedInUse, cs.Open)
+}
+
+type connectionCounters struct {
+	dials, reused, idleEvicted, closedByServer, closedInUse uint64
+	open                                                    int64
+}
+
+var connCounters connectionCounters
+
+// reset starts counting, the number of open connections is kept.
+func (c *connectionCounters) reset() {
+	atomic.StoreUint64(&c.dials, 0)
+	atomic.StoreUint64(&c.reused, 0)
+	atomic.StoreUint64(&c.idleEvicted, 0)
+	atomic.StoreUint64(&c.closedByServer, 0)
+	atomic.StoreUint64(&c.closedInUse, 0)
+}
+
+func (c *connectionCounters) statistics() *ConnectionStatistics {
+	return &ConnectionStatistics{
+		Dials:          atomic.LoadUint64(&c.dials),
+		Reused:         atomic.LoadUint64(&c.reused),
+		IdleEvicted:    atomic.LoadUint64(&c.idleEvicted),
+		ClosedByServer: atomic.LoadUint64(&c.closedByServer),
+		ClosedInUse:    atomic.LoadUint64(&c.closedInUse),
+		Open:           atomic.LoadInt64(&c.open),
+	}
+}
+
+// trackingDialer wraps the connections of dial to count them, keepAlive is
+// false if the transport closes connections after each request.
+func trackingDialer(dial func(ctx context.Context, network, addr string) (net.Conn, error), keepAlive bool) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddUint64(&connCounters.dials, 1)
+		atomic.AddInt64(&connCounters.open, 1)
+		return &trackedConn{Conn: conn, keepAlive: keepAlive}, nil
+	}
+}
+
+// trackedConn knows whether requests are in flight when it is closed, to tell
+// idle evictions apart from other closes.
+type trackedConn struct {
+	net.Conn
+	keepAlive bool
+	mutex     sync.Mutex
+	inFlight  int
+	sawEOF    bool
+	closed    bool
+}
+
+// trackedConnOf returns the tracked connection under the connection of an
+// httptrace.GotConnInfo, or nil.
+func trackedConnOf(conn net.Conn) *trackedConn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	tc, _ := conn.(*trackedConn)
+	return tc
+}
+
+func (c *trackedConn) acquire(reused bool) {
+	if reused {
+		atomic.AddUint64(&connCounters.reused, 1)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.inFlight++
+}
+
+func (c *trackedConn) release() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.inFlight--
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if err == io.EOF {
+		c.mutex.Lock()
+		c.sawEOF = true
+		c.mutex.Unlock()
+	}
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.mutex.Lock()
+	if !c.closed {
+		c.closed = true
+		atomic.AddInt64(&connCounters.open, -1)
+		switch {
+		case c.sawEOF:
+			atomic.AddUint64(&connCounters.closedByServer, 1)
+		case c.inFlight > 0 || !c.keepAlive:
+			atomic.AddUint64(&connCounters.closedInUse, 1)
+		default:
+			atomic.AddUint64(&connCounters.idleEvicted, 1)
+		}
+	}
+	c.mutex.Unlock()
+	return c.Conn.Close()
+}
+
+// newClientPool returns count HTTP clients that each use at most one
+// connection, to be shared by the workers.
+func newClientPool(count uint) []*http.Client {
+	pool := make([]*http.Client, count)
+	for i := range pool {
+		client := sdkmsClient()
+		transport := client.HTTPClient.Transport.(*http.Transport)
+		transport.MaxConnsPerHost = 1
+		pool[i] = client.HTTPClient
+	}
+	return pool
+}
@@ -0,0 +1,73 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPVersionFlag(t *testing.T) {
+	var v httpVersionOpt
+	require.NoError(t, v.Set("h2"))
+	assert.Equal(t, httpVersion2, v)
+	require.NoError(t, v.Set("1.1"))
+	assert.Equal(t, httpVersion11, v)
+	assert.Error(t, v.Set("3"))
+
+	transport := &http.Transport{}
+	configureHTTPVersion(transport)
+	assert.NotNil(t, transport.TLSNextProto)
+	assert.False(t, transport.ForceAttemptHTTP2)
+}
+
+func TestTrackedConnCloses(t *testing.T) {
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		server.Close()
+		return client, nil
+	}
+	open := func(keepAlive bool) *trackedConn {
+		conn, err := trackingDialer(dial, keepAlive)(context.Background(), "tcp", "")
+		require.NoError(t, err)
+		return conn.(*trackedConn)
+	}
+	connCounters.reset()
+	before := connCounters.statistics().Open
+
+	idle := open(true)
+	idle.acquire(false)
+	idle.release()
+	idle.Close()
+	idle.Close()
+
+	inUse := open(true)
+	inUse.acquire(true)
+	inUse.Close()
+
+	byServer := open(true)
+	_, err := byServer.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	byServer.Close()
+
+	noKeepAlive := open(false)
+	noKeepAlive.Close()
+
+	stats := connCounters.statistics()
+	assert.Equal(t, uint64(4), stats.Dials)
+	assert.Equal(t, uint64(1), stats.Reused)
+	assert.Equal(t, uint64(1), stats.IdleEvicted)
+	assert.Equal(t, uint64(2), stats.ClosedInUse)
+	assert.Equal(t, uint64(1), stats.ClosedByServer)
+	assert.Equal(t, before, stats.Open)
+}
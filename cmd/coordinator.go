@@ -0,0 +1,219 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/rpc"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+const COORDINATOR_POLL_INTERVAL = 500 * time.Millisecond
+
+// clockOffsetPings is the number of pings to estimate the clock offset of an
+// agent, the one with the shortest round trip is used.
+const clockOffsetPings = 5
+
+// TODO: get rid of global variables, tracking issue: #16
+var coordinatorAgents []string
+var coordinatorToken string
+var coordinatorStartDelay time.Duration
+
+var coordinatorCmd = &cobra.Command{
+	Use:   "coordinator --agents host:port,... -- [flags] load-test ...",
+	Short: "Run a load test on several agents and merge their results",
+	Long: `Push a load test scenario, the command line after --, to the agents started
+with the agent command, start them at the same time, stream their progress and
+merge their results into a single summary written in --output-format.
+
+While the test runs, the log lines of the agents are printed, and once every
+running agent reported a new interval statistic, the latest ones are merged
+into the QPS and latency of all agents.
+
+Every agent runs the whole scenario, e.g. --qps 100 on 3 agents sends 300
+requests per second. The start time is corrected by the clock offset of each
+agent estimated from the round trip of a ping. The merged QPS is the sum of
+the agents' QPS and the latency percentiles are computed from the merged
+latency histograms.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		runCoordinator(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(coordinatorCmd)
+
+	coordinatorCmd.Flags().StringSliceVar(&coordinatorAgents, "agents", nil, "Comma separated addresses of the agents, e.g. 127.0.0.1:7700,127.0.0.1:7701")
+	coordinatorCmd.Flags().StringVar(&coordinatorToken, "token", "", "Token of the agents")
+	coordinatorCmd.Flags().DurationVar(&coordinatorStartDelay, "start-delay", 2*time.Second, "Time to start the agents in, so they all receive the scenario before")
+}
+
+// coordinatedAgent is the connection to an agent and the state of its run.
+type coordinatedAgent struct {
+	address     string
+	client      *rpc.Client
+	clockOffset time.Duration
+	lines       int
+	intervals   int
+	interval    *Statistic // Latest interval statistic not merged yet
+	done        bool
+	output      []byte
+	err         string
+}
+
+func runCoordinator(scenario []string) {
+	if len(coordinatorAgents) == 0 {
+		log.Fatalf("No agents given, use --agents\n")
+	}
+	var agents []*coordinatedAgent
+	for _, address := range coordinatorAgents {
+		client, err := rpc.Dial("tcp", address)
+		if err != nil {
+			log.Fatalf("Failed to connect to agent %v: %v\n", address, err)
+		}
+		agent := &coordinatedAgent{address: address, client: client}
+		if agent.clockOffset, err = estimateClockOffset(agent.client); err != nil {
+			log.Fatalf("Agent %v: %v\n", address, err)
+		}
+		log.Printf("Agent %v: clock offset %v\n", address, agent.clockOffset)
+		agents = append(agents, agent)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		for _, agent := range agents {
+			agent.client.Call("Agent.Stop", StopArgs{Token: coordinatorToken}, &StopReply{})
+		}
+		log.Fatalf("Interrupted, stopped the agents\n")
+	}()
+
+	start := time.Now().Add(coordinatorStartDelay)
+	log.Printf("Starting %d agents at %v: %v\n", len(agents), start.Format(time.RFC3339Nano), scenario)
+	for _, agent := range agents {
+		args := StartArgs{Token: coordinatorToken, Args: scenario, At: start.Add(agent.clockOffset)}
+		if err := agent.client.Call("Agent.Start", args, &StartReply{}); err != nil {
+			log.Fatalf("Failed to start agent %v: %v\n", agent.address, err)
+		}
+	}
+
+	for running := len(agents); running > 0; {
+		time.Sleep(COORDINATOR_POLL_INTERVAL)
+		for _, agent := range agents {
+			if agent.done {
+				continue
+			}
+			var reply ProgressReply
+			args := ProgressArgs{Token: coordinatorToken, From: agent.lines, FromIntervals: agent.intervals}
+			if err := agent.client.Call("Agent.Progress", args, &reply); err != nil {
+				log.Fatalf("Agent %v: %v\n", agent.address, err)
+			}
+			for _, line := range reply.Lines {
+				fmt.Fprintf(os.Stderr, "[%s] %s\n", agent.address, line)
+			}
+			agent.lines += len(reply.Lines)
+			agent.observeIntervals(reply.Intervals)
+			if reply.Done {
+				agent.done, agent.output, agent.err = true, reply.Output, reply.Err
+				running--
+			}
+		}
+		if st := mergeAgentIntervals(agents); st != nil {
+			log.Printf("All agents, last interval: %s\n", st.String())
+		}
+	}
+
+	merged, err := mergeAgentResults(agents)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	if len(merged) == 1 {
+		writeTestSummary(merged[0])
+	} else {
+		writeTestSummary(newRepeatedTestSummary(merged))
+	}
+}
+
+// observeIntervals keeps the latest of the new interval statistics.
+func (agent *coordinatedAgent) observeIntervals(intervals []IntervalRecord) {
+	agent.intervals += len(intervals)
+	if len(intervals) != 0 {
+		agent.interval = intervals[len(intervals)-1].Statistic
+	}
+}
+
+// mergeAgentIntervals merges the latest interval statistics once every running
+// agent reported a new one, or returns nil. The QPS are added, the percentiles
+// are the count weighted means of those of the agents.
+func mergeAgentIntervals(agents []*coordinatedAgent) *Statistic {
+	var statistics []*Statistic
+	for _, agent := range agents {
+		if agent.done {
+			continue
+		}
+		if agent.interval == nil {
+			return nil
+		}
+		statistics = append(statistics, agent.interval)
+	}
+	for _, agent := range agents {
+		agent.interval = nil
+	}
+	return mergeStatistics(statistics, nil)
+}
+
+// estimateClockOffset returns the clock of the agent minus the local clock,
+// assuming the ping with the shortest round trip took as long each way.
+func estimateClockOffset(client *rpc.Client) (time.Duration, error) {
+	var offset time.Duration
+	shortest := time.Duration(-1)
+	for i := 0; i < clockOffsetPings; i++ {
+		var reply PingReply
+		t0 := time.Now()
+		if err := client.Call("Agent.Ping", PingArgs{Token: coordinatorToken}, &reply); err != nil {
+			return 0, err
+		}
+		rtt := time.Since(t0)
+		if shortest < 0 || rtt < shortest {
+			shortest = rtt
+			offset = reply.Time.Sub(t0.Add(rtt / 2))
+		}
+	}
+	return offset, nil
+}
+
+// mergeAgentResults merges the results of the agents, trial by trial if the
+// scenario repeats the test.
+func mergeAgentResults(agents []*coordinatedAgent) ([]*TestSummary, error) {
+	var sources []MergeSource
+	var results [][]*TestSummary
+	for _, agent := range agents {
+		trials, err := parseTestSummaries(agent.output, "result of agent "+agent.address)
+		if err != nil {
+			if agent.err != "" {
+				return nil, fmt.Errorf("agent %v: load test failed: %v", agent.address, agent.err)
+			}
+			return nil, err
+		}
+		if agent.err != "" {
+			// e.g. a failed --threshold
+			log.Printf("Agent %v: load test exited with: %v\n", agent.address, agent.err)
+		}
+		sources = append(sources, MergeSource{Name: agent.address, ClockOffset: agent.clockOffset, ClockOffsetSource: clockOffsetEstimated})
+		results = append(results, trials)
+	}
+	// every agent runs the same scenario
+	return mergeTrials(sources, results, false)
+}
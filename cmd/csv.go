@@ -0,0 +1,148 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+type TestSummaryCsvWriter interface {
+	WriteCsv(w io.Writer) error
+}
+
+// csvStatisticColumns are the columns of each flattened statistic, latencies
+// are in nanoseconds.
+var csvStatisticColumns = []string{"count", "qps", "avg_ns", "min_ns", "max_ns", "p50_ns", "p75_ns", "p90_ns", "p95_ns", "p99_ns", "sd_ns"}
+
+// csvHeader returns the header of the CSV output. It does not depend on the
+// results, so the rows of many runs can be appended to one file. Additional
+// profiling stages are left out for this reason.
+func csvHeader() []string {
+	header := []string{
+		"test_time", "trial", "test_name", "mode", "server_name", "server_port", "verify_tls", "connections",
+		"auth_method", "create_session", "apps", "app_strategy", "warmup_duration_ns", "test_duration_ns",
+		"target_qps", "key_type", "key_count", "key_selection",
+		"actual_test_duration_ns", "send_duration_ns", "errors", "failed_checks",
+	}
+	prefixes := []string{"warmup", "test"}
+	for _, stage := range fixedProfilingStages {
+		prefixes = append(prefixes, "profiling_"+stage)
+	}
+	// columns added later go last, so that rows stay aligned with the header
+	// of files written by earlier versions
+	prefixes = append(prefixes, "overhead")
+	for _, prefix := range prefixes {
+		for _, column := range csvStatisticColumns {
+			header = append(header, prefix+"_"+column)
+		}
+	}
+	return append(header, "tls_version", "tls_cipher_suite", "tls_key_exchange")
+}
+
+// csvStatistic flattens a statistic, all cells are empty if it is nil.
+func csvStatistic(st *Statistic) []string {
+	cells := make([]string, len(csvStatisticColumns))
+	if st == nil {
+		return cells
+	}
+	cells[0] = strconv.FormatUint(uint64(st.QueryNumber), 10)
+	if st.QPS != nil {
+		cells[1] = strconv.FormatFloat(*st.QPS, 'f', -1, 64)
+	}
+	for i, metric := range []string{"avg", "min", "max", "p50", "p75", "p90", "p95", "p99", "sd"} {
+		cells[i+2] = strconv.FormatFloat(latencyMetric(st, metric), 'f', -1, 64)
+	}
+	return cells
+}
+
+// csvRow returns the row of a test summary, trial is empty for a single run.
+func (ts *TestSummary) csvRow(trial string) []string {
+	tc, tr := ts.Config, ts.Result
+	var errorCount uint
+	for _, n := range tr.Errors {
+		errorCount += n
+	}
+	failed := 0
+	for _, c := range tr.Checks {
+		if !c.Passed {
+			failed++
+		}
+	}
+	var negotiated TLSParameters
+	if tc.TLS != nil && tc.TLS.Negotiated != nil {
+		negotiated = *tc.TLS.Negotiated
+	}
+	row := []string{
+		ts.TestTime, trial, tc.TestName, tc.Mode, tc.ServerName, fmt.Sprint(tc.ServerPort), fmt.Sprint(tc.VerifyTls), fmt.Sprint(tc.Connections),
+		tc.AuthMethod, fmt.Sprint(tc.CreateSession), fmt.Sprint(tc.Apps), tc.AppStrategy, fmt.Sprint(tc.WarmupDuration.Nanoseconds()), fmt.Sprint(tc.TestDuration.Nanoseconds()),
+		strconv.FormatFloat(tc.TargetQPS, 'f', -1, 64), describeKeyType(tc.Sobject), fmt.Sprint(tc.KeyCount), tc.KeySelection,
+		fmt.Sprint(tr.ActualTestDuration.Nanoseconds()), fmt.Sprint(tr.SendDuration.Nanoseconds()), fmt.Sprint(errorCount), fmt.Sprint(failed),
+	}
+	row = append(row, csvStatistic(tr.Warmup)...)
+	row = append(row, csvStatistic(tr.Test)...)
+	var stages map[string]*Statistic
+	if tr.ProfilingResults != nil {
+		stages = profilingStages(tr.ProfilingResults)
+	}
+	for _, stage := range fixedProfilingStages {
+		row = append(row, csvStatistic(stages[stage])...)
+	}
+	row = append(row, csvStatistic(tr.Overhead)...)
+	return append(row, negotiated.Version, negotiated.CipherSuite, negotiated.KeyExchange)
+}
+
+func writeCsv(w io.Writer, rows [][]string) error {
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(rows); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// csvHeaderRows returns the header row, unless w is a file that already has
+// content, e.g. the output appended to earlier results with >>.
+func csvHeaderRows(w io.Writer) [][]string {
+	if file, ok := w.(*os.File); ok {
+		if info, err := file.Stat(); err == nil && info.Mode().IsRegular() && info.Size() > 0 {
+			return nil
+		}
+	}
+	return [][]string{csvHeader()}
+}
+
+func (ts *TestSummary) WriteCsv(w io.Writer) error {
+	return writeCsv(w, append(csvHeaderRows(w), ts.csvRow("")))
+}
+
+func (rs *RepeatedTestSummary) WriteCsv(w io.Writer) error {
+	rows := csvHeaderRows(w)
+	for i, trial := range rs.Trials {
+		rows = append(rows, trial.csvRow(strconv.Itoa(i+1)))
+	}
+	return writeCsv(w, rows)
+}
+
+// WriteCsv writes one row per compared metric.
+func (c *Comparison) WriteCsv(w io.Writer) error {
+	rows := [][]string{{"metric", "unit", "baseline", "candidate", "delta", "delta_percent", "tolerance", "verdict", "significance"}}
+	format := func(v *float64) string {
+		if v == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*v, 'f', -1, 64)
+	}
+	for i := range c.Deltas {
+		d := &c.Deltas[i]
+		rows = append(rows, []string{d.Metric, d.Unit, format(&d.Baseline), format(&d.Candidate), format(&d.Delta),
+			format(d.DeltaPercent), format(d.Tolerance), d.Verdict, d.Significance})
+	}
+	return writeCsv(w, rows)
+}
@@ -0,0 +1,78 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteCsv(t *testing.T) {
+	withProfiling := newTestSummary()
+	withProfiling.Result.ProfilingResults.Additional = map[string]Statistic{"/sign": *newRandomStatistic()}
+	withoutProfiling := newTestSummary()
+	withoutProfiling.Result.ProfilingResults = nil
+
+	var buf bytes.Buffer
+	require.NoError(t, newRepeatedTestSummary([]*TestSummary{withProfiling, withoutProfiling}).WriteCsv(&buf))
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	header := rows[0]
+	assert.Equal(t, csvHeader(), header)
+	for _, row := range rows[1:] {
+		assert.Len(t, row, len(header))
+	}
+	column := func(name string) int {
+		for i, h := range header {
+			if h == name {
+				return i
+			}
+		}
+		t.Fatalf("missing column %v", name)
+		return -1
+	}
+	assert.Equal(t, "2", rows[2][column("trial")])
+	assert.Equal(t, "Test1", rows[1][column("test_name")])
+	assert.NotEmpty(t, rows[1][column("profiling_total_p99_ns")])
+	assert.Empty(t, rows[2][column("profiling_total_p99_ns")])
+
+	// the columns of earlier versions keep their position
+	assert.Equal(t, column("profiling_total_sd_ns")+1, column("overhead_count"))
+	assert.Equal(t, []string{"overhead_sd_ns", "tls_version", "tls_cipher_suite", "tls_key_exchange"}, header[len(header)-4:])
+
+	buf.Reset()
+	require.NoError(t, withoutProfiling.WriteCsv(&buf))
+	rows, err = csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, header, rows[0])
+	assert.Empty(t, rows[1][column("trial")])
+}
+
+func TestWriteCsvAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.csv")
+	for i := 0; i < 3; i++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		require.NoError(t, err)
+		require.NoError(t, newTestSummary().WriteCsv(file))
+		require.NoError(t, file.Close())
+	}
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 4, "the header is only written to the new file")
+	assert.Equal(t, csvHeader(), rows[0])
+	assert.Equal(t, "Test1", rows[3][2])
+}
@@ -0,0 +1,46 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var foldedStacksCmd = &cobra.Command{
+	Use:   "folded-stacks result.json...",
+	Short: "Print the profiling statistics of load test results as folded stacks",
+	Long: `Print the profiling statistics of load test results written with
+--output-format json in the folded stack format, e.g. to render a flamegraph
+of the server side time with flamegraph.pl or speedscope. Each result is a
+separate root frame named after the test.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, path := range args {
+			summaries, err := readTestSummaries(path)
+			if err != nil {
+				log.Fatalf("Fatal error: %v\n", err)
+			}
+			for _, summary := range summaries {
+				ps := summary.Result.ProfilingResults
+				if ps == nil {
+					log.Printf("Warning: %v has no profiling data\n", path)
+					continue
+				}
+				if err := ps.WriteFoldedStacks(os.Stdout, testDisplayName(summary.Config)); err != nil {
+					log.Fatalf("Fatal error: %v\n", err)
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(foldedStacksCmd)
+}
@@ -0,0 +1,138 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"math"
+	"math/bits"
+	"sort"
+	"time"
+
+	"github.com/montanaflynn/stats"
+)
+
+// histogramSubBucketBits is the log2 of the number of buckets per power of
+// two, 7 bits bound the relative error of a bucket to 1/128 (< 0.8%).
+const histogramSubBucketBits = 7
+
+const histogramSubBuckets = 1 << histogramSubBucketBits
+
+// LatencyHistogram is a log-linear histogram of latencies in nanoseconds.
+// Bucket boundaries are fixed, so histograms of different runs can be merged
+// by adding the counts of equal buckets.
+type LatencyHistogram struct {
+	Buckets []HistogramBucket `json:"buckets" yaml:"buckets"` // Non-empty buckets sorted by their bounds
+}
+
+// HistogramBucket counts the latencies in [Lower, Upper) nanoseconds.
+type HistogramBucket struct {
+	Lower uint64 `json:"lower" yaml:"lower"`
+	Upper uint64 `json:"upper" yaml:"upper"`
+	Count uint64 `json:"count" yaml:"count"`
+}
+
+func HistogramFromDurations(times []time.Duration) *LatencyHistogram {
+	if len(times) == 0 {
+		return nil
+	}
+	return HistogramFromFloat64Data(stats.LoadRawData(times))
+}
+
+func HistogramFromFloat64Data(data stats.Float64Data) *LatencyHistogram {
+	counts := make(map[int]uint64)
+	for _, v := range data {
+		if v < 0 {
+			v = 0
+		}
+		counts[histogramBucketIndex(uint64(v))]++
+	}
+	return histogramFromCounts(counts)
+}
+
+func histogramFromCounts(counts map[int]uint64) *LatencyHistogram {
+	indexes := make([]int, 0, len(counts))
+	for index := range counts {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	h := &LatencyHistogram{Buckets: make([]HistogramBucket, 0, len(indexes))}
+	for _, index := range indexes {
+		lower, upper := histogramBucketBounds(index)
+		h.Buckets = append(h.Buckets, HistogramBucket{Lower: lower, Upper: upper, Count: counts[index]})
+	}
+	return h
+}
+
+// histogramBucketIndex returns the bucket of v. Values below twice the number
+// of sub-buckets have a bucket each, larger values share a bucket with the
+// values having the same leading histogramSubBucketBits+1 bits.
+func histogramBucketIndex(v uint64) int {
+	if v < 2*histogramSubBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - histogramSubBucketBits - 1
+	return (exp+1)*histogramSubBuckets + int(v>>exp) - histogramSubBuckets
+}
+
+func histogramBucketBounds(index int) (uint64, uint64) {
+	if index < 2*histogramSubBuckets {
+		return uint64(index), uint64(index) + 1
+	}
+	exp := index/histogramSubBuckets - 1
+	mantissa := uint64(index%histogramSubBuckets + histogramSubBuckets)
+	return mantissa << exp, (mantissa + 1) << exp
+}
+
+// Merge adds the counts of other to the histogram.
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) {
+	if other == nil {
+		return
+	}
+	counts := make(map[int]uint64)
+	for _, b := range h.Buckets {
+		counts[histogramBucketIndex(b.Lower)] += b.Count
+	}
+	for _, b := range other.Buckets {
+		counts[histogramBucketIndex(b.Lower)] += b.Count
+	}
+	h.Buckets = histogramFromCounts(counts).Buckets
+}
+
+// Count returns the number of latencies in the histogram.
+func (h *LatencyHistogram) Count() uint64 {
+	var n uint64
+	for _, b := range h.Buckets {
+		n += b.Count
+	}
+	return n
+}
+
+// midpoint is the value representing the latencies of the bucket.
+func (b *HistogramBucket) midpoint() float64 {
+	return float64(b.Lower) + float64(b.Upper-b.Lower-1)/2
+}
+
+// Percentile returns the midpoint of the bucket of the p-th percentile, the
+// smallest latency that at least p percent of the latencies do not exceed.
+func (h *LatencyHistogram) Percentile(p float64) float64 {
+	n := h.Count()
+	if n == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p / 100 * float64(n)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for i := range h.Buckets {
+		seen += h.Buckets[i].Count
+		if seen >= rank {
+			return h.Buckets[i].midpoint()
+		}
+	}
+	return h.Buckets[len(h.Buckets)-1].midpoint()
+}
@@ -0,0 +1,48 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/montanaflynn/stats"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramBuckets(t *testing.T) {
+	for _, v := range []uint64{0, 1, 255, 256, 257, 1000, 123456789, 60e9} {
+		lower, upper := histogramBucketBounds(histogramBucketIndex(v))
+		assert.True(t, lower <= v && v < upper, "%v not in [%v, %v)", v, lower, upper)
+		assert.True(t, float64(upper-lower) <= float64(lower)/histogramSubBuckets+1)
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	var a, b stats.Float64Data
+	for i := 0; i < 1000; i++ {
+		a = append(a, rand.ExpFloat64()*1e6)
+		b = append(b, rand.ExpFloat64()*2e6)
+	}
+	merged := HistogramFromFloat64Data(a)
+	merged.Merge(HistogramFromFloat64Data(b))
+	assert.Equal(t, HistogramFromFloat64Data(append(a, b...)), merged)
+	assert.Equal(t, uint64(2000), merged.Count())
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	var data stats.Float64Data
+	for i := 0; i < 10000; i++ {
+		data = append(data, rand.ExpFloat64()*1e6)
+	}
+	h := HistogramFromFloat64Data(data)
+	for _, p := range []float64{50, 90, 99} {
+		exact, _ := data.Percentile(p)
+		assert.InEpsilon(t, exact, h.Percentile(p), 0.01, "p%v", p)
+	}
+	assert.Equal(t, 0.0, (&LatencyHistogram{}).Percentile(50))
+}
@@ -0,0 +1,433 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var htmlReportPath string
+
+// Size of the plot area of the report charts in pixels, the margins hold the
+// axes and legend.
+const (
+	chartWidth        = 720
+	chartHeight       = 260
+	chartMarginLeft   = 64
+	chartMarginRight  = 150
+	chartMarginTop    = 16
+	chartMarginBottom = 40
+)
+
+var chartColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+// reportedProfilingStages are the stages of the stacked profiling chart in
+// the order they are processed by the server.
+var reportedProfilingStages = []string{"in_queue", "parse_request", "session_lookup", "validate_input", "check_access", "operate", "db_flush"}
+
+type chartPoint struct {
+	X float64
+	Y float64
+}
+
+type chartSeries struct {
+	Name   string
+	Points []chartPoint
+	Dashed bool
+}
+
+// chartAxis maps data values to pixels, ticks are placed at the given values
+// and labeled with format.
+type chartAxis struct {
+	Label  string
+	Min    float64
+	Max    float64
+	Ticks  []float64
+	Format func(float64) string
+}
+
+type htmlReportTest struct {
+	Title       string
+	Summary     string
+	Latency     template.HTML
+	Percentiles template.HTML
+	QPS         template.HTML
+	Errors      template.HTML
+}
+
+type htmlReport struct {
+	Generated string
+	Tests     []htmlReportTest
+	Profiling template.HTML
+}
+
+// readTestSummaries reads the load test results of a JSON file written by a
+// single load test or by repeated trials.
+func readTestSummaries(path string) ([]*TestSummary, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseTestSummaries(data, path)
+}
+
+// parseTestSummaries parses the output of a load test with --output-format
+// json, the trials of a repeated test or a single summary.
+func parseTestSummaries(data []byte, name string) ([]*TestSummary, error) {
+	var repeated RepeatedTestSummary
+	if err := json.Unmarshal(data, &repeated); err == nil && len(repeated.Trials) != 0 {
+		return repeated.Trials, nil
+	}
+	summary, err := parseTestSummary(data, name)
+	if err != nil {
+		return nil, err
+	}
+	return []*TestSummary{summary}, nil
+}
+
+func writeHTMLReportFile(path string, summaries []*TestSummary) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeHTMLReport(file, summaries); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeHTMLReport writes a self-contained HTML page with charts of the given
+// results, the charts are inline SVG so the page works offline.
+func writeHTMLReport(w io.Writer, summaries []*TestSummary) error {
+	report := htmlReport{Generated: time.Now().Format(time.RFC3339)}
+	var labels []string
+	for i, summary := range summaries {
+		label := fmt.Sprintf("#%d %s", i+1, testDisplayName(summary.Config))
+		labels = append(labels, label)
+		var plain bytes.Buffer
+		if err := summary.WritePlain(&plain); err != nil {
+			return err
+		}
+		report.Tests = append(report.Tests, htmlReportTest{
+			Title:       fmt.Sprintf("%s (%s)", label, summary.TestTime),
+			Summary:     plain.String(),
+			Latency:     latencyTimelineChart(summary.Result),
+			Percentiles: percentileChart(summary.Result),
+			QPS:         qpsChart(summary),
+			Errors:      errorChart(summary.Result),
+		})
+	}
+	report.Profiling = profilingChart(labels, summaries)
+	return htmlReportTemplate.Execute(w, report)
+}
+
+func latencyTimelineChart(result *TestResult) template.HTML {
+	if len(result.Timeline) == 0 {
+		return ""
+	}
+	series := []chartSeries{{Name: "p50"}, {Name: "p90"}, {Name: "p99"}}
+	overhead := chartSeries{Name: "overhead p50"}
+	var maxX, maxY float64
+	for _, interval := range result.Timeline {
+		x := interval.Offset.Seconds()
+		maxX = math.Max(maxX, x)
+		if interval.Overhead != nil {
+			overhead.Points = append(overhead.Points, chartPoint{x, interval.Overhead.P50 / 1e6})
+			maxY = math.Max(maxY, interval.Overhead.P50/1e6)
+		}
+		if interval.Statistic == nil {
+			continue
+		}
+		for i, v := range []float64{interval.Statistic.P50, interval.Statistic.P90, interval.Statistic.P99} {
+			series[i].Points = append(series[i].Points, chartPoint{x, v / 1e6})
+			maxY = math.Max(maxY, v/1e6)
+		}
+	}
+	if len(overhead.Points) != 0 {
+		series = append(series, overhead)
+	}
+	return lineChart(series, linearAxis("time (s)", maxX, formatNumber), linearAxis("latency (ms)", maxY, formatNumber))
+}
+
+// percentileChart plots the latency at each percentile. The x axis is
+// logarithmic in 1/(1-q), so the tail percentiles are spread out.
+func percentileChart(result *TestResult) template.HTML {
+	st := result.Test
+	if st == nil {
+		return ""
+	}
+	const maxNines = 5
+	nines := func(q float64) float64 {
+		return math.Min(-math.Log10(1-q), maxNines)
+	}
+	series := chartSeries{Name: "latency"}
+	if h := result.TestHistogram; h != nil && h.Count() != 0 {
+		total := float64(h.Count())
+		var cumulative uint64
+		for _, b := range h.Buckets {
+			series.Points = append(series.Points, chartPoint{nines(float64(cumulative) / total), float64(b.Lower) / 1e6})
+			cumulative += b.Count
+			if cumulative == h.Count() {
+				series.Points = append(series.Points, chartPoint{maxNines, float64(b.Upper) / 1e6})
+			} else {
+				series.Points = append(series.Points, chartPoint{nines(float64(cumulative) / total), float64(b.Upper) / 1e6})
+			}
+		}
+	} else {
+		for _, p := range []struct{ q, v float64 }{{0, st.Min}, {0.5, st.P50}, {0.75, st.P75}, {0.9, st.P90}, {0.95, st.P95}, {0.99, st.P99}, {1, st.Max}} {
+			series.Points = append(series.Points, chartPoint{nines(p.q), p.v / 1e6})
+		}
+	}
+	x := chartAxis{
+		Label: "percentile",
+		Max:   maxNines,
+		Ticks: []float64{0, nines(0.5), 1, 2, 3, 4, 5},
+		Format: func(v float64) string {
+			return formatNumber(100*(1-math.Pow(10, -v))) + "%"
+		},
+	}
+	return lineChart([]chartSeries{series}, x, linearAxis("latency (ms)", st.Max/1e6, formatNumber))
+}
+
+func qpsChart(summary *TestSummary) template.HTML {
+	result := summary.Result
+	if len(result.Timeline) == 0 {
+		return ""
+	}
+	interval := time.Second
+	if len(result.Timeline) > 1 {
+		interval = result.Timeline[1].Offset - result.Timeline[0].Offset
+	}
+	achieved := chartSeries{Name: "achieved"}
+	failed := chartSeries{Name: "errors"}
+	var maxX float64
+	maxY := summary.Config.TargetQPS
+	for _, st := range result.Timeline {
+		x := st.Offset.Seconds()
+		maxX = math.Max(maxX, x)
+		var qps float64
+		if st.Statistic != nil && st.Statistic.QPS != nil {
+			qps = *st.Statistic.QPS
+		}
+		errorRate := float64(st.Errors) / interval.Seconds()
+		achieved.Points = append(achieved.Points, chartPoint{x, qps})
+		failed.Points = append(failed.Points, chartPoint{x, errorRate})
+		maxY = math.Max(maxY, math.Max(qps, errorRate))
+	}
+	target := chartSeries{Name: "target", Dashed: true, Points: []chartPoint{{0, summary.Config.TargetQPS}, {maxX, summary.Config.TargetQPS}}}
+	return lineChart([]chartSeries{achieved, target, failed}, linearAxis("time (s)", maxX, formatNumber), linearAxis("queries per second", maxY, formatNumber))
+}
+
+func errorChart(result *TestResult) template.HTML {
+	if len(result.Errors) == 0 {
+		return ""
+	}
+	categories := sortedKeys(result.Errors)
+	values := make([][]float64, len(categories))
+	for i, category := range categories {
+		values[i] = []float64{float64(result.Errors[category])}
+	}
+	return stackedBarChart(categories, []string{"requests"}, values, "failed requests")
+}
+
+// profilingChart stacks the average of each profiling stage for every test
+// with profiling data.
+func profilingChart(labels []string, summaries []*TestSummary) template.HTML {
+	var bars []string
+	var values [][]float64
+	for i, summary := range summaries {
+		ps := summary.Result.ProfilingResults
+		if ps == nil {
+			continue
+		}
+		stages := profilingStages(ps)
+		var row []float64
+		for _, stage := range reportedProfilingStages {
+			row = append(row, stages[stage].Avg/1e6)
+		}
+		bars = append(bars, labels[i])
+		values = append(values, row)
+	}
+	if len(bars) == 0 {
+		return ""
+	}
+	return stackedBarChart(bars, reportedProfilingStages, values, "average time (ms)")
+}
+
+func linearAxis(label string, max float64, format func(float64) string) chartAxis {
+	step := tickStep(max)
+	axis := chartAxis{Label: label, Format: format}
+	for v := 0.0; v < max+step/2; v += step {
+		axis.Ticks = append(axis.Ticks, v)
+		axis.Max = v
+	}
+	if axis.Max < max {
+		axis.Max += step
+		axis.Ticks = append(axis.Ticks, axis.Max)
+	}
+	return axis
+}
+
+// tickStep returns a round step of 1, 2 or 5 times a power of ten dividing
+// [0, max] into about five ticks.
+func tickStep(max float64) float64 {
+	if max <= 0 || math.IsNaN(max) || math.IsInf(max, 0) {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(max/5)))
+	for _, m := range []float64{1, 2, 5} {
+		if max/(m*magnitude) <= 6 {
+			return m * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+func formatNumber(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", v), "0"), ".")
+}
+
+func (a *chartAxis) scale(v float64, pixels float64) float64 {
+	if a.Max == a.Min {
+		return 0
+	}
+	return (v - a.Min) / (a.Max - a.Min) * pixels
+}
+
+func writeSVGStart(b *strings.Builder) {
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="12">`,
+		chartWidth+chartMarginLeft+chartMarginRight, chartHeight+chartMarginTop+chartMarginBottom)
+	fmt.Fprintf(b, `<g transform="translate(%d,%d)">`, chartMarginLeft, chartMarginTop)
+}
+
+func writeSVGEnd(b *strings.Builder) {
+	b.WriteString(`</g></svg>`)
+}
+
+// writeYAxis draws the horizontal grid lines and labels of the y axis.
+func writeYAxis(b *strings.Builder, y chartAxis) {
+	for _, tick := range y.Ticks {
+		py := chartHeight - y.scale(tick, chartHeight)
+		fmt.Fprintf(b, `<line x1="0" x2="%d" y1="%.1f" y2="%.1f" stroke="#ddd"/>`, chartWidth, py, py)
+		fmt.Fprintf(b, `<text x="-6" y="%.1f" text-anchor="end" dominant-baseline="middle">%s</text>`, py, template.HTMLEscapeString(y.Format(tick)))
+	}
+	fmt.Fprintf(b, `<text transform="translate(-50,%d) rotate(-90)" text-anchor="middle">%s</text>`, chartHeight/2, template.HTMLEscapeString(y.Label))
+}
+
+func writeLegend(b *strings.Builder, names []string) {
+	for i, name := range names {
+		y := i * 18
+		fmt.Fprintf(b, `<rect x="%d" y="%d" width="12" height="12" fill="%s"/>`, chartWidth+12, y, chartColors[i%len(chartColors)])
+		fmt.Fprintf(b, `<text x="%d" y="%d" dominant-baseline="hanging">%s</text>`, chartWidth+30, y, template.HTMLEscapeString(name))
+	}
+}
+
+func lineChart(series []chartSeries, x chartAxis, y chartAxis) template.HTML {
+	var b strings.Builder
+	writeSVGStart(&b)
+	writeYAxis(&b, y)
+	for _, tick := range x.Ticks {
+		px := x.scale(tick, chartWidth)
+		fmt.Fprintf(&b, `<line x1="%.1f" x2="%.1f" y1="%d" y2="%d" stroke="#999"/>`, px, px, chartHeight, chartHeight+4)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, px, chartHeight+16, template.HTMLEscapeString(x.Format(tick)))
+	}
+	fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle">%s</text>`, chartWidth/2, chartHeight+34, template.HTMLEscapeString(x.Label))
+	var names []string
+	for i, s := range series {
+		names = append(names, s.Name)
+		var points []string
+		for _, p := range s.Points {
+			points = append(points, fmt.Sprintf("%.1f,%.1f", x.scale(p.X, chartWidth), chartHeight-y.scale(p.Y, chartHeight)))
+		}
+		dash := ""
+		if s.Dashed {
+			dash = ` stroke-dasharray="6,4"`
+		}
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="%s" stroke-width="1.5"%s points="%s"/>`, chartColors[i%len(chartColors)], dash, strings.Join(points, " "))
+	}
+	writeLegend(&b, names)
+	writeSVGEnd(&b)
+	return template.HTML(b.String())
+}
+
+// stackedBarChart draws one bar per label, values[i][j] is the height of
+// segment j of bar i.
+func stackedBarChart(labels []string, segments []string, values [][]float64, yLabel string) template.HTML {
+	var max float64
+	for _, row := range values {
+		var sum float64
+		for _, v := range row {
+			sum += v
+		}
+		max = math.Max(max, sum)
+	}
+	y := linearAxis(yLabel, max, formatNumber)
+	var b strings.Builder
+	writeSVGStart(&b)
+	writeYAxis(&b, y)
+	slot := float64(chartWidth) / float64(len(labels))
+	width := math.Min(slot*0.6, 80)
+	for i, row := range values {
+		px := slot*float64(i) + (slot-width)/2
+		var base float64
+		for j, v := range row {
+			top := chartHeight - y.scale(base+v, chartHeight)
+			height := y.scale(base+v, chartHeight) - y.scale(base, chartHeight)
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s</title></rect>`,
+				px, top, width, height, chartColors[j%len(chartColors)], template.HTMLEscapeString(segments[j]), formatNumber(v))
+			base += v
+		}
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" text-anchor="middle">%s</text>`, px+width/2, chartHeight+16, template.HTMLEscapeString(labels[i]))
+	}
+	if len(segments) > 1 {
+		writeLegend(&b, segments)
+	}
+	writeSVGEnd(&b)
+	return template.HTML(b.String())
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>DSM load test report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h2 { border-bottom: 1px solid #ccc; padding-bottom: 4px; }
+pre { background: #f6f6f6; padding: 1em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>DSM load test report</h1>
+<p>Generated {{.Generated}}</p>
+{{with .Profiling}}<h2>Profiling stages</h2>
+{{.}}
+{{end}}{{range .Tests}}<h2>{{.Title}}</h2>
+{{with .Latency}}<h3>Latency over time</h3>
+{{.}}
+{{end}}{{with .Percentiles}}<h3>Latency percentiles</h3>
+{{.}}
+{{end}}{{with .QPS}}<h3>QPS achieved vs target</h3>
+{{.}}
+{{end}}{{with .Errors}}<h3>Errors</h3>
+{{.}}
+{{end}}<details><summary>Summary</summary>
+<pre>{{.Summary}}</pre>
+</details>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,60 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimelineFromDurations(t *testing.T) {
+	start := time.Now()
+	times := []time.Time{start, start.Add(500 * time.Millisecond), start.Add(2500 * time.Millisecond), start.Add(3100 * time.Millisecond)}
+	durations := []time.Duration{time.Millisecond, 3 * time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond}
+	errorTimes := []time.Time{start.Add(1200 * time.Millisecond)}
+	timeline := timelineFromDurations(start, 3*time.Second, time.Second, times, durations, errorTimes)
+	require.Len(t, timeline, 3)
+	assert.Equal(t, uint(2), timeline[0].Statistic.QueryNumber)
+	assert.Equal(t, 2.0, *timeline[0].Statistic.QPS)
+	assert.Nil(t, timeline[1].Statistic)
+	assert.Equal(t, uint(1), timeline[1].Errors)
+	assert.Equal(t, time.Second, timeline[1].Offset)
+	// the request started after the end of the test falls into the last interval
+	assert.Equal(t, uint(2), timeline[2].Statistic.QueryNumber)
+}
+
+func TestWriteHTMLReport(t *testing.T) {
+	summary := newTestSummary()
+	summary.Config.TestName = "Test<1>"
+	summary.Result.TestHistogram = HistogramFromFloat64Data([]float64{1e6, 2e6, 3e6, 10e6})
+	summary.Result.Errors = map[string]uint{"HTTP 503": 3, "timeout": 1}
+	qps := 950.0
+	summary.Result.Timeline = []IntervalStatistic{
+		{Offset: 0, Statistic: &Statistic{QueryNumber: 950, QPS: &qps, P50: 1e6, P90: 2e6, P99: 3e6}},
+		{Offset: time.Second, Errors: 4},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writeHTMLReport(&buf, []*TestSummary{summary}))
+	report := buf.String()
+	assert.Equal(t, 5, strings.Count(report, "<svg"))
+	assert.Contains(t, report, "Test&lt;1&gt;")
+	assert.NotContains(t, report, "Test<1>")
+	assert.Contains(t, report, "HTTP 503")
+	assert.Contains(t, report, "session_lookup")
+}
+
+func TestTickStep(t *testing.T) {
+	assert.Equal(t, 1.0, tickStep(0))
+	assert.Equal(t, 1.0, tickStep(5))
+	assert.Equal(t, 2.0, tickStep(9))
+	assert.Equal(t, 200.0, tickStep(1000))
+}
@@ -0,0 +1,237 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/montanaflynn/stats"
+)
+
+// httpPhases are the client side phases of the HTTP requests of one test
+// call. Phases of several requests are added up.
+type httpPhases struct {
+	DNS      time.Duration
+	Connect  time.Duration
+	TLS      time.Duration
+	TTFB     time.Duration // From the request being written to the first response byte
+	BodyRead time.Duration // From the first response byte to the end of the body
+	Dialed   bool          // A new connection was dialed
+	Traced   bool          // At least one request was sent
+	// Handshake is the last successful TLS handshake, nil if the requests
+	// reused connections.
+	Handshake *tlsHandshake
+}
+
+// HTTPPhaseStatistics summarizes the client side phases of the test
+// requests. DNS, connect and TLS only cover requests that opened a new
+// connection.
+type HTTPPhaseStatistics struct {
+	DNS               *Statistic `json:"dns,omitempty" yaml:"dns,omitempty"`
+	Connect           *Statistic `json:"connect,omitempty" yaml:"connect,omitempty"`
+	TLS               *Statistic `json:"tls,omitempty" yaml:"tls,omitempty"`
+	TTFB              *Statistic `json:"ttfb" yaml:"ttfb"` // Server processing plus one network round trip, compare with the profiling Total
+	BodyRead          *Statistic `json:"body_read" yaml:"body_read"`
+	ReusedConnections uint       `json:"reused_connections" yaml:"reused_connections"` // Requests sent on an existing connection
+}
+
+// httpTracer records the phases of the requests sent by one worker, which
+// sends one request at a time. Trace hooks may run on other goroutines, e.g.
+// while dialing, so the phases are guarded by a mutex.
+type httpTracer struct {
+	base   http.RoundTripper
+	mutex  sync.Mutex
+	phases httpPhases
+}
+
+// traceClient makes the client record the phases of its requests.
+func traceClient(client *sdkms.Client) *httpTracer {
+	base := client.HTTPClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	tracer := &httpTracer{base: base}
+	httpClient := *client.HTTPClient
+	httpClient.Transport = tracer
+	client.HTTPClient = &httpClient
+	return tracer
+}
+
+// reset discards the phases recorded so far.
+func (t *httpTracer) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.phases = httpPhases{}
+}
+
+// recorded returns the phases recorded since the last reset.
+func (t *httpTracer) recorded() httpPhases {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.phases
+}
+
+func (t *httpTracer) add(phase *time.Duration, d time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	*phase += d
+}
+
+func (t *httpTracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	var mutex sync.Mutex
+	var dnsStart, connectStart, tlsStart, wroteRequest, firstByte time.Time
+	var conn *trackedConn
+	mark := func(at *time.Time) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		*at = time.Now()
+	}
+	since := func(at *time.Time) time.Duration {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if at.IsZero() {
+			return 0
+		}
+		return time.Since(*at)
+	}
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { mark(&dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { t.add(&t.phases.DNS, since(&dnsStart)) },
+		ConnectStart: func(network, addr string) {
+			mark(&connectStart)
+			t.mutex.Lock()
+			t.phases.Dialed = true
+			t.mutex.Unlock()
+		},
+		ConnectDone:       func(network, addr string, err error) { t.add(&t.phases.Connect, since(&connectStart)) },
+		TLSHandshakeStart: func() { mark(&tlsStart) },
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			d := since(&tlsStart)
+			t.add(&t.phases.TLS, d)
+			if err == nil {
+				t.mutex.Lock()
+				t.phases.Handshake = &tlsHandshake{Duration: d, Version: state.Version, CipherSuite: state.CipherSuite, Resumed: state.DidResume}
+				t.mutex.Unlock()
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if tc := trackedConnOf(info.Conn); tc != nil {
+				tc.acquire(info.Reused)
+				mutex.Lock()
+				conn = tc
+				mutex.Unlock()
+			}
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) { mark(&wroteRequest) },
+		GotFirstResponseByte: func() {
+			t.add(&t.phases.TTFB, since(&wroteRequest))
+			mark(&firstByte)
+		},
+	}
+	t.mutex.Lock()
+	t.phases.Traced = true
+	t.mutex.Unlock()
+	release := func() {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if conn != nil {
+			conn.release()
+		}
+	}
+	resp, err := t.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+	if err != nil {
+		release()
+		return resp, err
+	}
+	resp.Body = &tracedBody{ReadCloser: resp.Body, done: func() {
+		t.add(&t.phases.BodyRead, since(&firstByte))
+		release()
+	}}
+	return resp, nil
+}
+
+// tracedBody calls done once the body is read to the end or closed.
+type tracedBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(b.done)
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	b.once.Do(b.done)
+	return b.ReadCloser.Close()
+}
+
+func httpPhaseStatisticsFromPhases(phases []httpPhases) *HTTPPhaseStatistics {
+	if len(phases) == 0 {
+		return nil
+	}
+	var dns, connect, tlsHandshake, ttfb, bodyRead stats.Float64Data
+	hps := &HTTPPhaseStatistics{}
+	for _, p := range phases {
+		if p.Dialed {
+			if p.DNS != 0 {
+				dns = append(dns, float64(p.DNS))
+			}
+			connect = append(connect, float64(p.Connect))
+			if p.TLS != 0 {
+				tlsHandshake = append(tlsHandshake, float64(p.TLS))
+			}
+		} else {
+			hps.ReusedConnections++
+		}
+		ttfb = append(ttfb, float64(p.TTFB))
+		bodyRead = append(bodyRead, float64(p.BodyRead))
+	}
+	hps.DNS = phaseStatistic(dns)
+	hps.Connect = phaseStatistic(connect)
+	hps.TLS = phaseStatistic(tlsHandshake)
+	hps.TTFB = phaseStatistic(ttfb)
+	hps.BodyRead = phaseStatistic(bodyRead)
+	return hps
+}
+
+func phaseStatistic(data stats.Float64Data) *Statistic {
+	if len(data) == 0 {
+		return nil
+	}
+	return StatisticFromFloat64Data(data, nil)
+}
+
+func (hps *HTTPPhaseStatistics) Print(w io.Writer) {
+	phases := []struct {
+		name string
+		stat *Statistic
+	}{
+		{"DNS", hps.DNS},
+		{"Connect", hps.Connect},
+		{"TLS", hps.TLS},
+		{"TTFB", hps.TTFB},
+		{"BodyRead", hps.BodyRead},
+	}
+	for _, phase := range phases {
+		if phase.stat != nil {
+			fmt.Fprintf(w, "%s: %s\n", StrPad(phase.name, len("SessionLookup"), " ", "LEFT"), phase.stat.String())
+		}
+	}
+	fmt.Fprintf(w, "%s: %d\n", StrPad("Reused", len("SessionLookup"), " ", "LEFT"), hps.ReusedConnections)
+}
@@ -0,0 +1,63 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPTracer(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	client := sdkms.Client{HTTPClient: server.Client()}
+	tracer := traceClient(&client)
+
+	get := func() httpPhases {
+		tracer.reset()
+		resp, err := client.HTTPClient.Get(server.URL)
+		require.NoError(t, err)
+		_, err = io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+		return tracer.recorded()
+	}
+	first := get()
+	assert.True(t, first.Traced)
+	assert.True(t, first.Dialed)
+	assert.Greater(t, first.Connect, time.Duration(0))
+	assert.Greater(t, first.TLS, time.Duration(0))
+	assert.GreaterOrEqual(t, first.TTFB, 5*time.Millisecond)
+	require.NotNil(t, first.Handshake)
+	assert.Equal(t, first.TLS, first.Handshake.Duration)
+	assert.False(t, first.Handshake.Resumed)
+
+	second := get()
+	assert.True(t, second.Traced)
+	assert.False(t, second.Dialed)
+	assert.Zero(t, second.TLS)
+	assert.Nil(t, second.Handshake)
+	assert.GreaterOrEqual(t, second.TTFB, 5*time.Millisecond)
+
+	hps := httpPhaseStatisticsFromPhases([]httpPhases{first, second})
+	require.NotNil(t, hps)
+	assert.Nil(t, hps.DNS)
+	assert.Equal(t, uint(1), hps.Connect.QueryNumber)
+	assert.Equal(t, uint(2), hps.TTFB.QueryNumber)
+	assert.Equal(t, uint(1), hps.ReusedConnections)
+	assert.Nil(t, httpPhaseStatisticsFromPhases(nil))
+}
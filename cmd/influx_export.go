@@ -0,0 +1,306 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var exportTargets []string
+var resultExporters []resultExporter
+
+// resultExporter sends load test statistics to an external store while and
+// after the test runs.
+type resultExporter interface {
+	// exportInterval exports the statistic of the requests completed in the
+	// interval ending at t.
+	exportInterval(config *TestConfig, t time.Time, st *Statistic) error
+	// exportSummary exports the final result of a load test.
+	exportSummary(summary *TestSummary) error
+	close() error
+}
+
+// openExporters opens the exporters given with --export.
+func openExporters() {
+	for _, target := range exportTargets {
+		kind, dest, found := strings.Cut(target, "=")
+		if !found || dest == "" {
+			log.Fatalf("Invalid export target, expected <kind>=<destination>: %v\n", target)
+		}
+		var exporter resultExporter
+		switch kind {
+		case "influx":
+			exporter = newInfluxExporter(&influxHTTPWriter{url: dest, token: os.Getenv("INFLUX_TOKEN")}, true)
+		case "influx-file":
+			file, err := os.Create(dest)
+			if err != nil {
+				log.Fatalf("Failed to open export file: %v\n", err)
+			}
+			exporter = newInfluxExporter(file, false)
+		case "interval-jsonl":
+			// write only, so that e.g. /dev/fd/3 of a pipe can be opened
+			file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+			if err != nil {
+				log.Fatalf("Failed to open export file: %v\n", err)
+			}
+			exporter = newIntervalExporter(file)
+		default:
+			log.Fatalf("Unknown export kind %v, supported: influx, influx-file, interval-jsonl\n", kind)
+		}
+		resultExporters = append(resultExporters, exporter)
+	}
+}
+
+func exportInterval(config *TestConfig, t time.Time, st *Statistic) {
+	for _, exporter := range resultExporters {
+		if err := exporter.exportInterval(config, t, st); err != nil {
+			log.Printf("Error: failed to export interval statistic: %v\n", err)
+		}
+	}
+}
+
+func exportSummary(summary *TestSummary) {
+	for _, exporter := range resultExporters {
+		if err := exporter.exportSummary(summary); err != nil {
+			log.Printf("Error: failed to export test result: %v\n", err)
+		}
+	}
+}
+
+func closeExporters() {
+	for _, exporter := range resultExporters {
+		if err := exporter.close(); err != nil {
+			log.Printf("Error: failed to close exporter: %v\n", err)
+		}
+	}
+	resultExporters = nil
+}
+
+// influxExporter writes InfluxDB line protocol. Writes happen in the
+// background, so a slow database does not hold up the load test: with
+// dropWhenBehind, interval batches are dropped while the queue of pending
+// writes is full. The final result is always written.
+type influxExporter struct {
+	w              io.WriteCloser
+	dropWhenBehind bool
+	batches        chan []byte
+	done           sync.WaitGroup
+	mutex          sync.Mutex
+	err            error
+	dropped        uint
+}
+
+func newInfluxExporter(w io.WriteCloser, dropWhenBehind bool) *influxExporter {
+	e := &influxExporter{w: w, dropWhenBehind: dropWhenBehind, batches: make(chan []byte, 100)}
+	e.done.Add(1)
+	go func() {
+		defer e.done.Done()
+		for batch := range e.batches {
+			if _, err := e.w.Write(batch); err != nil {
+				e.mutex.Lock()
+				e.err = err
+				e.mutex.Unlock()
+			}
+		}
+	}()
+	return e
+}
+
+// send queues a batch for writing. A droppable batch is dropped if the queue
+// is full and the exporter drops when behind, other batches wait for room.
+func (e *influxExporter) send(batch []byte, droppable bool) {
+	if !droppable || !e.dropWhenBehind {
+		e.batches <- batch
+		return
+	}
+	select {
+	case e.batches <- batch:
+	default:
+		e.mutex.Lock()
+		e.dropped++
+		e.mutex.Unlock()
+	}
+}
+
+// lastError returns and clears the error of the latest failed write.
+func (e *influxExporter) lastError() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	err := e.err
+	e.err = nil
+	return err
+}
+
+func (e *influxExporter) exportInterval(config *TestConfig, t time.Time, st *Statistic) error {
+	var buf bytes.Buffer
+	writeInfluxLine(&buf, "dsm_perf_interval", influxTags(config), statisticFields(st), t)
+	e.send(buf.Bytes(), true)
+	return e.lastError()
+}
+
+func (e *influxExporter) exportSummary(summary *TestSummary) error {
+	t, err := time.Parse(time.RFC3339, summary.TestTime)
+	if err != nil {
+		t = time.Now()
+	}
+	tags := influxTags(summary.Config)
+	var buf bytes.Buffer
+	if summary.Result.Test != nil {
+		fields := statisticFields(summary.Result.Test)
+		fields["actual_test_duration_ns"] = summary.Result.ActualTestDuration.Nanoseconds()
+		writeInfluxLine(&buf, "dsm_perf_result", tags, fields, t)
+	}
+	if ps := summary.Result.ProfilingResults; ps != nil {
+		stages := profilingStages(ps)
+		for _, stage := range sortedKeys(stages) {
+			st := stages[stage]
+			stageTags := map[string]string{"stage": stage}
+			for k, v := range tags {
+				stageTags[k] = v
+			}
+			writeInfluxLine(&buf, "dsm_perf_profiling", stageTags, statisticFields(st), t)
+		}
+	}
+	e.send(buf.Bytes(), false)
+	return e.lastError()
+}
+
+func (e *influxExporter) close() error {
+	close(e.batches)
+	e.done.Wait()
+	if err := e.w.Close(); err != nil {
+		return err
+	}
+	if e.dropped > 0 {
+		return fmt.Errorf("dropped %d batches as the writes did not keep up", e.dropped)
+	}
+	return e.lastError()
+}
+
+// influxTags returns the tags identifying a load test, every test has the
+// same tags so that their series can be queried alike.
+func influxTags(config *TestConfig) map[string]string {
+	mode := config.Mode
+	if mode == "" {
+		mode = "none"
+	}
+	return map[string]string{
+		"test_name":      config.TestName,
+		"server":         fmt.Sprintf("%v:%v", config.ServerName, config.ServerPort),
+		"connections":    strconv.FormatUint(uint64(config.Connections), 10),
+		"target_qps":     strconv.FormatFloat(config.TargetQPS, 'f', -1, 64),
+		"create_session": strconv.FormatBool(config.CreateSession),
+		"key_type":       describeKeyType(config.Sobject),
+		"mode":           mode,
+	}
+}
+
+func statisticFields(st *Statistic) map[string]interface{} {
+	fields := map[string]interface{}{
+		"count":  int64(st.QueryNumber),
+		"avg_ns": st.Avg,
+		"min_ns": st.Min,
+		"max_ns": st.Max,
+		"p50_ns": st.P50,
+		"p75_ns": st.P75,
+		"p90_ns": st.P90,
+		"p95_ns": st.P95,
+		"p99_ns": st.P99,
+		"sd_ns":  st.Sd,
+	}
+	if st.QPS != nil {
+		fields["qps"] = *st.QPS
+	}
+	return fields
+}
+
+// writeInfluxLine writes one point in line protocol, tags and fields are
+// sorted by key.
+func writeInfluxLine(buf *bytes.Buffer, measurement string, tags map[string]string, fields map[string]interface{}, t time.Time) {
+	buf.WriteString(influxMeasurementEscaper.Replace(measurement))
+	for _, key := range sortedKeys(tags) {
+		if tags[key] == "" {
+			continue
+		}
+		fmt.Fprintf(buf, ",%s=%s", influxKeyEscaper.Replace(key), influxKeyEscaper.Replace(tags[key]))
+	}
+	for i, key := range sortedKeys(fields) {
+		sep := ","
+		if i == 0 {
+			sep = " "
+		}
+		buf.WriteString(sep + influxKeyEscaper.Replace(key) + "=")
+		switch v := fields[key].(type) {
+		case int64:
+			buf.WriteString(strconv.FormatInt(v, 10) + "i")
+		case float64:
+			buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+		case string:
+			buf.WriteString(`"` + influxStringEscaper.Replace(v) + `"`)
+		case bool:
+			buf.WriteString(strconv.FormatBool(v))
+		}
+	}
+	fmt.Fprintf(buf, " %d\n", t.UnixNano())
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+var influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+var influxKeyEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+var influxStringEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// influxHTTPWriter posts each write to the InfluxDB write endpoint given as
+// the full URL, e.g. http://localhost:8086/api/v2/write?org=o&bucket=b or
+// http://localhost:8086/write?db=perf for InfluxDB 1.x.
+type influxHTTPWriter struct {
+	url   string
+	token string
+}
+
+func (w *influxHTTPWriter) Write(p []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(p))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, fmt.Errorf("InfluxDB write failed with %v: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return len(p), nil
+}
+
+func (w *influxHTTPWriter) Close() error {
+	return nil
+}
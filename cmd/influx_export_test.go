@@ -0,0 +1,154 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+func TestInfluxExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := newInfluxExporter(nopWriteCloser{&buf}, true)
+	summary := newTestSummary()
+	summary.Config.TestName = "AES 256 GCM, encryption"
+	summary.Config.Mode = "GCM"
+	summary.TestTime = "2024-01-02T03:04:05Z"
+	assert.NoError(t, exporter.exportInterval(summary.Config, time.Unix(0, 42), &Statistic{QueryNumber: 10, P99: 1.5e6}))
+	assert.NoError(t, exporter.exportSummary(summary))
+	assert.NoError(t, exporter.close())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 10)
+	assert.Equal(t, `dsm_perf_interval,connections=100,create_session=false,key_type=none,mode=GCM,server=localhost:8080,target_qps=1000,test_name=AES\ 256\ GCM\,\ encryption`+
+		` avg_ns=0,count=10i,max_ns=0,min_ns=0,p50_ns=0,p75_ns=0,p90_ns=0,p95_ns=0,p99_ns=1.5e+06,sd_ns=0 42`, lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], "dsm_perf_result,"))
+	assert.Contains(t, lines[1], ",qps=")
+	assert.True(t, strings.HasSuffix(lines[1], " 1704164645000000000"))
+	assert.Contains(t, buf.String(), "dsm_perf_profiling,connections=100,create_session=false,key_type=none,mode=GCM,server=localhost:8080,stage=total,")
+}
+
+func TestIntervalExporter(t *testing.T) {
+	var buf bytes.Buffer
+	exporter := newIntervalExporter(nopWriteCloser{&buf})
+	summary := newTestSummary()
+	summary.Config.TestName = "AES 256 GCM, encryption"
+	assert.NoError(t, exporter.exportInterval(summary.Config, time.Unix(5, 0).UTC(), &Statistic{QueryNumber: 10, P99: 1.5e6}))
+	assert.NoError(t, exporter.exportSummary(summary))
+	assert.NoError(t, exporter.close())
+
+	var record IntervalRecord
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &record), "a single line")
+	assert.Equal(t, IntervalRecord{Time: time.Unix(5, 0).UTC(), TestName: "AES 256 GCM, encryption", Statistic: &Statistic{QueryNumber: 10, P99: 1.5e6}}, record)
+}
+
+func TestInfluxHTTPWriter(t *testing.T) {
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Token secret", r.Header.Get("Authorization"))
+		body, _ := io.ReadAll(r.Body)
+		received = string(body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	w := &influxHTTPWriter{url: server.URL + "/api/v2/write?org=o&bucket=b", token: "secret"}
+	_, err := w.Write([]byte("m f=1 1\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, "m f=1 1\n", received)
+
+	w.url = server.URL + "/missing"
+	server.Config.Handler = http.NotFoundHandler()
+	_, err = w.Write([]byte("m f=1 1\n"))
+	assert.Error(t, err)
+}
+
+// blockingWriter blocks every write until release is closed.
+type blockingWriter struct {
+	release chan struct{}
+	writes  int
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	w.writes++
+	return len(p), nil
+}
+
+func (w *blockingWriter) Close() error {
+	return nil
+}
+
+func TestInfluxExporterDropsWhenBehind(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	exporter := newInfluxExporter(w, true)
+	summary := newTestSummary()
+	config := summary.Config
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 150; i++ {
+			exporter.exportInterval(config, time.Unix(0, int64(i)), &Statistic{QueryNumber: 1})
+		}
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("exportInterval blocked on a slow writer")
+	}
+	// fill up the queue again once the writer took the first batch
+	time.Sleep(20 * time.Millisecond)
+	exporter.exportInterval(config, time.Unix(0, 150), &Statistic{QueryNumber: 1})
+	summaryDone := make(chan struct{})
+	go func() {
+		exporter.exportSummary(summary)
+		close(summaryDone)
+	}()
+	select {
+	case <-summaryDone:
+		t.Fatal("the summary was not queued behind the intervals")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(w.release)
+	<-summaryDone
+	err := exporter.close()
+	// 151 intervals and the summary
+	assert.Equal(t, 152, w.writes+int(exporter.dropped), "the summary is written")
+	assert.GreaterOrEqual(t, exporter.dropped, uint(49))
+	assert.ErrorContains(t, err, "batches as the writes did not keep up")
+
+	// a file sink drops nothing
+	w = &blockingWriter{release: make(chan struct{})}
+	close(w.release)
+	exporter = newInfluxExporter(w, false)
+	for i := 0; i < 150; i++ {
+		exporter.exportInterval(config, time.Unix(0, int64(i)), &Statistic{QueryNumber: 1})
+	}
+	assert.NoError(t, exporter.close())
+	assert.Equal(t, 150, w.writes)
+
+	var buf bytes.Buffer
+	config.Mode = ""
+	writeInfluxLine(&buf, "m", influxTags(config), map[string]interface{}{"f": 1.0}, time.Unix(0, 1))
+	assert.Contains(t, buf.String(), ",mode=none,", "tests without a mode have the same tags")
+}
@@ -0,0 +1,45 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// IntervalRecord is the statistic of the requests completed in one interval
+// of a load test, as written by --export interval-jsonl.
+type IntervalRecord struct {
+	Time      time.Time  `json:"time"` // End of the interval
+	TestName  string     `json:"test_name"`
+	Statistic *Statistic `json:"statistic"`
+}
+
+// intervalExporter writes one JSON IntervalRecord per line, e.g. for an agent
+// to stream the progress of its load test to the coordinator. Summaries are
+// not written, they are in the result of the test.
+type intervalExporter struct {
+	w       io.WriteCloser
+	encoder *json.Encoder
+}
+
+func newIntervalExporter(w io.WriteCloser) *intervalExporter {
+	return &intervalExporter{w: w, encoder: json.NewEncoder(w)}
+}
+
+func (e *intervalExporter) exportInterval(config *TestConfig, t time.Time, st *Statistic) error {
+	return e.encoder.Encode(IntervalRecord{Time: t, TestName: config.TestName, Statistic: st})
+}
+
+func (e *intervalExporter) exportSummary(summary *TestSummary) error {
+	return nil
+}
+
+func (e *intervalExporter) close() error {
+	return e.w.Close()
+}
@@ -0,0 +1,184 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+type TestSummaryJUnitWriter interface {
+	WriteJUnit(w io.Writer) error
+}
+
+// JUnit XML as understood by common CI systems.
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Time       string          `xml:"time,attr,omitempty"`
+	Timestamp  string          `xml:"timestamp,attr,omitempty"`
+	Properties []junitProperty `xml:"properties>property,omitempty"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr,omitempty"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut *junitOutput  `xml:"system-out,omitempty"`
+}
+
+type junitOutput struct {
+	Text string `xml:",cdata"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (s *junitTestSuites) add(suite junitTestSuite) {
+	for _, c := range suite.Cases {
+		suite.Tests++
+		if c.Failure != nil {
+			suite.Failures++
+		}
+	}
+	s.Tests += suite.Tests
+	s.Failures += suite.Failures
+	s.Suites = append(s.Suites, suite)
+}
+
+func (s *junitTestSuites) write(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(s); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// junitSuite returns the test suite of a load test, with one test case for
+// the load test itself and one for each threshold check.
+func (ts *TestSummary) junitSuite(name string) (junitTestSuite, error) {
+	var plain bytes.Buffer
+	if err := ts.WritePlain(&plain); err != nil {
+		return junitTestSuite{}, err
+	}
+	suite := junitTestSuite{
+		Name:      name,
+		Time:      fmt.Sprintf("%.3f", ts.Result.ActualTestDuration.Seconds()),
+		Timestamp: ts.TestTime,
+		Properties: []junitProperty{
+			{"server", fmt.Sprintf("%v:%v", ts.Config.ServerName, ts.Config.ServerPort)},
+			{"target_qps", fmt.Sprint(ts.Config.TargetQPS)},
+			{"connections", fmt.Sprint(ts.Config.Connections)},
+		},
+	}
+	loadTest := junitTestCase{
+		Name:      "load test",
+		Classname: name,
+		Time:      suite.Time,
+		SystemOut: &junitOutput{plain.String()},
+	}
+	if ts.Result.Test == nil {
+		loadTest.Failure = &junitFailure{Message: "no request succeeded", Type: "LoadTestFailure"}
+	}
+	suite.Cases = append(suite.Cases, loadTest)
+	for i := range ts.Result.Checks {
+		c := &ts.Result.Checks[i]
+		tc := junitTestCase{Name: c.Threshold.String(), Classname: name}
+		if !c.Passed {
+			tc.Failure = &junitFailure{Message: c.Message(), Type: "ThresholdFailure", Text: c.Message()}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	return suite, nil
+}
+
+// testDisplayName returns the test name followed by the mode, if any.
+func testDisplayName(config *TestConfig) string {
+	name := config.TestName
+	if config.Mode != "" {
+		name += " " + config.Mode
+	}
+	return name
+}
+
+func (ts *TestSummary) WriteJUnit(w io.Writer) error {
+	suites := junitTestSuites{Name: "dsm-perf-tool"}
+	suite, err := ts.junitSuite(testDisplayName(ts.Config))
+	if err != nil {
+		return err
+	}
+	suites.add(suite)
+	return suites.write(w)
+}
+
+func (rs *RepeatedTestSummary) WriteJUnit(w io.Writer) error {
+	suites := junitTestSuites{Name: "dsm-perf-tool"}
+	for i, trial := range rs.Trials {
+		suite, err := trial.junitSuite(fmt.Sprintf("%s trial %d", testDisplayName(trial.Config), i+1))
+		if err != nil {
+			return err
+		}
+		suites.add(suite)
+	}
+	return suites.write(w)
+}
+
+// WriteJUnit writes every checked metric of the comparison as a test case,
+// regressions are failures.
+func (c *Comparison) WriteJUnit(w io.Writer) error {
+	suite := junitTestSuite{
+		Name: "compare",
+		Properties: []junitProperty{
+			{"baseline", c.Baseline},
+			{"candidate", c.Candidate},
+		},
+	}
+	for _, d := range c.Deltas {
+		if d.Verdict == "" {
+			continue
+		}
+		tc := junitTestCase{Name: d.Metric, Classname: "compare"}
+		if d.Verdict == verdictRegression {
+			message := fmt.Sprintf("%s = %s, expected within %.2f%% of baseline %s", d.Metric,
+				formatMetricValue(d.Candidate, d.Unit, false), *d.Tolerance, formatMetricValue(d.Baseline, d.Unit, false))
+			if d.DeltaPercent != nil {
+				message += fmt.Sprintf(" (%+.2f%%)", *d.DeltaPercent)
+			}
+			tc.Failure = &junitFailure{Message: message, Type: "Regression", Text: message}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suites := junitTestSuites{Name: "dsm-perf-tool"}
+	suites.add(suite)
+	return suites.write(w)
+}
@@ -0,0 +1,77 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseThreshold(t *testing.T) {
+	th, err := parseThreshold("p99<=20ms")
+	require.NoError(t, err)
+	assert.Equal(t, Threshold{Metric: "p99", Operator: "<=", Value: float64(20 * time.Millisecond)}, th)
+	th, err = parseThreshold("qps > 950")
+	require.NoError(t, err)
+	assert.Equal(t, Threshold{Metric: "qps", Operator: ">", Value: 950}, th)
+	_, err = parseThreshold("p42<1ms")
+	assert.Error(t, err)
+	_, err = parseThreshold("p99=1ms")
+	assert.Error(t, err)
+	_, err = parseThreshold("p99<1")
+	assert.Error(t, err)
+}
+
+func TestCheckThresholds(t *testing.T) {
+	qps := 900.0
+	result := &TestResult{
+		Test:   &Statistic{QueryNumber: 99, QPS: &qps, P99: 25e6},
+		Errors: map[string]uint{"timeout": 1},
+	}
+	thresholds, err := parseThresholds([]string{"p99<=20ms", "qps>=850", "error_rate<0.05", "errors<1"})
+	require.NoError(t, err)
+	checks := checkThresholds(thresholds, result)
+	require.Len(t, checks, 4)
+	assert.False(t, checks[0].Passed)
+	assert.Equal(t, "p99 = 25.000ms, expected <= 20.000ms", checks[0].Message())
+	assert.True(t, checks[1].Passed)
+	assert.True(t, checks[2].Passed)
+	assert.InDelta(t, 0.01, *checks[2].Measured, 1e-9)
+	assert.False(t, checks[3].Passed)
+
+	checks = checkThresholds(thresholds[:1], &TestResult{})
+	assert.False(t, checks[0].Passed)
+	assert.Nil(t, checks[0].Measured)
+}
+
+func TestWriteJUnit(t *testing.T) {
+	summary := newTestSummary()
+	summary.Result.Checks = []ThresholdCheck{
+		Threshold{Metric: "p99", Operator: "<=", Value: 1}.check(summary.Result),
+		Threshold{Metric: "qps", Operator: ">=", Value: 0}.check(summary.Result),
+	}
+	var buf bytes.Buffer
+	require.NoError(t, newRepeatedTestSummary([]*TestSummary{summary, summary}).WriteJUnit(&buf))
+	var suites junitTestSuites
+	require.NoError(t, xml.Unmarshal(buf.Bytes(), &suites))
+	assert.Equal(t, 6, suites.Tests)
+	assert.Equal(t, 2, suites.Failures)
+	require.Len(t, suites.Suites, 2)
+	assert.Equal(t, "Test1 trial 2", suites.Suites[1].Name)
+	cases := suites.Suites[0].Cases
+	require.Len(t, cases, 3)
+	assert.Nil(t, cases[0].Failure)
+	assert.Contains(t, cases[0].SystemOut.Text, "----- Test Results -----")
+	require.NotNil(t, cases[1].Failure)
+	assert.Contains(t, cases[1].Failure.Message, "expected <= 0.000ms")
+	assert.Nil(t, cases[2].Failure)
+}
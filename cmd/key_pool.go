@@ -0,0 +1,258 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/spf13/cobra"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var keyIDFile string
+var keyGroupID string
+var keySelectionOpt = keySelectionUniform
+var zipfExponent float64
+
+type keySelection string
+
+const (
+	keySelectionUniform    keySelection = "uniform"
+	keySelectionZipf       keySelection = "zipf"
+	keySelectionRoundRobin keySelection = "round-robin"
+)
+
+// impl pflag.Value interface for keySelection
+
+func (k *keySelection) String() string {
+	return string(*k)
+}
+
+func (k *keySelection) Set(v string) error {
+	switch v {
+	case "uniform":
+		*k = keySelectionUniform
+	case "zipf", "zipfian":
+		*k = keySelectionZipf
+	case "round-robin", "rr":
+		*k = keySelectionRoundRobin
+	default:
+		return fmt.Errorf("invalid key selection: %v", v)
+	}
+	return nil
+}
+
+func (k *keySelection) Type() string {
+	return "KeySelection"
+}
+
+// addKeyPoolFlags registers the flags selecting a pool of keys on a load test
+// command that otherwise uses a single --kid.
+func addKeyPoolFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&keyIDFile, "kid-file", "", "File with one key ID per line to spread requests over instead of --kid")
+	cmd.PersistentFlags().StringVar(&keyGroupID, "kid-group", "", "Group ID whose keys to spread requests over instead of --kid")
+	cmd.PersistentFlags().Var(&keySelectionOpt, "key-selection", "How a key is selected from the pool for each request, support: uniform, zipf, round-robin")
+	cmd.PersistentFlags().Float64Var(&zipfExponent, "zipf-s", 1.1, "Exponent of the Zipfian key selection, must be > 1")
+}
+
+// keyPool hands out key IDs for each request according to the selection.
+type keyPool struct {
+	kids      []string
+	selection keySelection
+	next      uint64
+
+	mutex sync.Mutex
+	zipf  *rand.Zipf
+}
+
+func newKeyPool(kids []string, selection keySelection, s float64) (*keyPool, error) {
+	if len(kids) == 0 {
+		return nil, fmt.Errorf("key pool is empty")
+	}
+	pool := &keyPool{kids: kids, selection: selection}
+	if selection == keySelectionZipf {
+		r := rand.New(rand.NewSource(time.Now().UnixNano()))
+		pool.zipf = rand.NewZipf(r, s, 1, uint64(len(kids)-1))
+		if pool.zipf == nil {
+			return nil, fmt.Errorf("invalid Zipfian exponent: %v", s)
+		}
+	}
+	return pool, nil
+}
+
+// pick returns the key ID to use for the next request. It is safe to call
+// from concurrent workers.
+func (p *keyPool) pick() string {
+	if len(p.kids) == 1 {
+		return p.kids[0]
+	}
+	switch p.selection {
+	case keySelectionRoundRobin:
+		return p.kids[(atomic.AddUint64(&p.next, 1)-1)%uint64(len(p.kids))]
+	case keySelectionZipf:
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+		return p.kids[p.zipf.Uint64()]
+	default:
+		return p.kids[rand.Intn(len(p.kids))]
+	}
+}
+
+func (p *keyPool) size() int {
+	return len(p.kids)
+}
+
+// keyData is a value per key prepared before the test and shared by the
+// workers, e.g. a ciphertext to decrypt, so that the test stage only sends
+// the measured requests.
+type keyData struct {
+	once   sync.Once
+	err    error
+	values map[string]interface{}
+}
+
+// prepare calls create for every key once, in the setup of the first worker,
+// the other workers wait for it.
+func (d *keyData) prepare(kids []string, create func(kid string) (interface{}, error)) error {
+	d.once.Do(func() {
+		values := make(map[string]interface{}, len(kids))
+		for _, kid := range kids {
+			values[kid] = nil
+		}
+		if len(values) != 0 {
+			log.Printf("Preparing %d keys for the test\n", len(values))
+		}
+		for kid := range values {
+			v, err := create(kid)
+			if err != nil {
+				d.err = fmt.Errorf("failed to prepare key %v: %v", kid, err)
+				return
+			}
+			values[kid] = v
+		}
+		d.values = values
+	})
+	return d.err
+}
+
+// get returns the value prepared for the key.
+func (d *keyData) get(kid string) interface{} {
+	return d.values[kid]
+}
+
+// keyPoolNameSuffix describes the key pool for the test name, it is empty for
+// a single key.
+func keyPoolNameSuffix(p *keyPool) string {
+	if p.size() == 1 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d keys, %s)", p.size(), p.selection)
+}
+
+// loadKeyPool returns the pool of keys selected by --kid-file or --kid-group,
+// or a pool of only kid if neither is given. Keys of a group are filtered to
+// the given object types.
+func loadKeyPool(kid string, objTypes ...sdkms.ObjectType) *keyPool {
+	var kids []string
+	var err error
+	switch {
+	case keyIDFile != "":
+		kids, err = readKeyIDFile(keyIDFile)
+	case keyGroupID != "":
+		kids, err = listGroupKeyIDs(keyGroupID, objTypes)
+	default:
+		kids = []string{kid}
+	}
+	if err != nil {
+		log.Fatalf("Failed to load key pool: %v\n", err)
+	}
+	pool, err := newKeyPool(kids, keySelectionOpt, zipfExponent)
+	if err != nil {
+		log.Fatalf("Failed to load key pool: %v\n", err)
+	}
+	return pool
+}
+
+func readKeyIDFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseKeyIDs(file)
+}
+
+// parseKeyIDs reads one key ID per line, empty lines and lines starting
+// with '#' are ignored.
+func parseKeyIDs(r io.Reader) ([]string, error) {
+	var kids []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kids = append(kids, line)
+	}
+	return kids, scanner.Err()
+}
+
+func listGroupKeyIDs(groupID string, objTypes []sdkms.ObjectType) ([]string, error) {
+	client := sdkmsClient()
+	if err := authenticateApp(&client, firstAppCredential(), false); err != nil {
+		return nil, err
+	}
+	if appSessionRequired() {
+		defer client.TerminateSession(context.Background())
+	}
+	const pageSize = uint(1000)
+	var kids []string
+	for offset := uint(0); ; offset += pageSize {
+		limit, off := pageSize, offset
+		// Sort has to be set, the SDK does not handle a nil value
+		resp, err := client.ListSobjects(context.Background(), &sdkms.ListSobjectsParams{
+			GroupID: &groupID,
+			Limit:   &limit,
+			Offset:  &off,
+			Sort:    &sdkms.SobjectSort{},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, sobject := range resp.Items {
+			if sobject.Kid != nil && matchesObjectType(sobject.ObjType, objTypes) {
+				kids = append(kids, *sobject.Kid)
+			}
+		}
+		if uint(len(resp.Items)) < pageSize {
+			return kids, nil
+		}
+	}
+}
+
+func matchesObjectType(t sdkms.ObjectType, objTypes []sdkms.ObjectType) bool {
+	if len(objTypes) == 0 {
+		return true
+	}
+	for _, o := range objTypes {
+		if o == t {
+			return true
+		}
+	}
+	return false
+}
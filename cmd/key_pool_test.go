@@ -0,0 +1,109 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyPoolRoundRobin(t *testing.T) {
+	pool, err := newKeyPool([]string{"a", "b", "c"}, keySelectionRoundRobin, 0)
+	assert.NoError(t, err)
+	var picked []string
+	for i := 0; i < 6; i++ {
+		picked = append(picked, pool.pick())
+	}
+	assert.Equal(t, []string{"a", "b", "c", "a", "b", "c"}, picked)
+}
+
+func TestKeyPoolZipf(t *testing.T) {
+	kids := []string{"k0", "k1", "k2", "k3", "k4", "k5", "k6", "k7"}
+	pool, err := newKeyPool(kids, keySelectionZipf, 1.5)
+	assert.NoError(t, err)
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[pool.pick()]++
+	}
+	// the first keys are the hot ones
+	assert.Greater(t, counts["k0"], counts["k1"])
+	assert.Greater(t, counts["k1"], counts["k7"])
+
+	_, err = newKeyPool(kids, keySelectionZipf, 0.5)
+	assert.Error(t, err)
+}
+
+func TestKeyData(t *testing.T) {
+	var data keyData
+	var created int32
+	create := func(kid string) (interface{}, error) {
+		atomic.AddInt32(&created, 1)
+		return "cipher of " + kid, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, data.prepare([]string{"a", "b", "a"}, create))
+			assert.Equal(t, "cipher of b", data.get("b"), "prepared before any worker goes on")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), created, "once per key for all workers")
+	assert.Equal(t, "cipher of a", data.get("a"))
+
+	var failing keyData
+	err := failing.prepare([]string{"a"}, func(kid string) (interface{}, error) { return nil, fmt.Errorf("no such key") })
+	assert.EqualError(t, err, "failed to prepare key a: no such key")
+	assert.Equal(t, err, failing.prepare([]string{"a"}, create), "every worker fails")
+}
+
+func TestParseKeyIDs(t *testing.T) {
+	kids, err := parseKeyIDs(strings.NewReader("# AES keys\nkid-1\n\n kid-2 \n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kid-1", "kid-2"}, kids)
+}
+
+func TestListGroupKeyIDs(t *testing.T) {
+	resetRootCmdStatus()
+	defer func(method appAuthMethod, key string) { authMethod, apiKey = method, key }(authMethod, apiKey)
+	authMethod, apiKey = appAuthMethodAPIKey, "YXBwOnNlY3JldA=="
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/crypto/v1/keys", r.URL.Path)
+		assert.Equal(t, "group-1", r.URL.Query().Get("group_id"))
+		assert.Equal(t, "Basic YXBwOnNlY3JldA==", r.Header.Get("Authorization"))
+		w.Write([]byte(`[{"kid":"kid-1","obj_type":"AES"},{"kid":"kid-2","obj_type":"RSA"},{"kid":"kid-3","obj_type":"AES"}]`))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	port, err := strconv.ParseUint(serverURL.Port(), 10, 16)
+	require.NoError(t, err)
+	serverName, serverPort, insecureTLS = serverURL.Hostname(), uint16(port), true
+	defer resetRootCmdStatus()
+
+	kids, err := listGroupKeyIDs("group-1", []sdkms.ObjectType{sdkms.ObjectTypeAes})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kid-1", "kid-3"}, kids)
+
+	kids, err = listGroupKeyIDs("group-1", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"kid-1", "kid-2", "kid-3"}, kids)
+}
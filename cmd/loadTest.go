@@ -7,12 +7,18 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"math/rand"
+	"net"
+	"net/http"
 	"os"
 	"sync"
 	"time"
 
 	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/montanaflynn/stats"
 	"github.com/spf13/cobra"
 )
 
@@ -24,6 +30,15 @@ var testDuration time.Duration
 var apiKey string
 var createSession bool
 var storeProfilingData bool
+var storeLatencies bool
+var repeatCount uint
+var coolDown time.Duration
+var timelineInterval time.Duration
+var authMethod = appAuthMethodAPIKey
+var appID string
+var jwtToken string
+var apiKeyFile string
+var apiKeyStrategy = credentialStrategyRoundRobin
 
 var loadTestCmd = &cobra.Command{
 	Use:     "load-test",
@@ -39,11 +54,37 @@ func init() {
 
 	loadTestCmd.PersistentFlags().Float64Var(&queriesPerSecond, "qps", 10, "Queries per second (QPS)")
 	loadTestCmd.PersistentFlags().UintVarP(&connections, "connections", "c", 10, "Number of concurrent connections")
+	loadTestCmd.PersistentFlags().Var(&arrival, "arrival", "Arrival process of the requests, support: uniform (evenly spaced at --qps), poisson (exponential inter-arrival times at --qps), burst (--burst-size requests every --burst-interval), trace (inter-arrival times of --arrival-trace)")
+	loadTestCmd.PersistentFlags().Int64Var(&arrivalSeed, "seed", 0, "Seed of the poisson arrival process, 0 means a time based seed. The seed is recorded in the test config")
+	loadTestCmd.PersistentFlags().UintVar(&burstSize, "burst-size", 10, "Number of requests of each burst with --arrival burst")
+	loadTestCmd.PersistentFlags().DurationVar(&burstInterval, "burst-interval", time.Second, "Time between bursts with --arrival burst")
+	loadTestCmd.PersistentFlags().Float64Var(&traceSpeed, "speed", 1, "Speed of --arrival trace and load-test replay, e.g. 2 sends the requests twice as fast as recorded")
+	loadTestCmd.PersistentFlags().StringVar(&arrivalTraceFile, "arrival-trace", "", "File with one inter-arrival time per line (e.g. 1.5ms, or seconds) to replay with --arrival trace, repeated if the test runs longer")
+	loadTestCmd.PersistentFlags().BoolVar(&openLoop, "open-loop", false, "Dispatch every token on schedule to an idle worker and drop it if all are busy, instead of queueing tokens until a worker is free")
+	loadTestCmd.PersistentFlags().UintVar(&maxInFlight, "max-in-flight", 0, "Maximum number of requests in flight in open loop mode, requires --open-loop. Each one is a worker that runs the setup and authenticates (one session each with --create-session), with --http-version 2 the workers share --connections TCP connections, with 1.1 each has its own. 0 means --connections")
+	loadTestCmd.PersistentFlags().DurationVar(&lateTokenThreshold, "late-threshold", 10*time.Millisecond, "Tokens dispatched to a worker later than this after their schedule are reported as late")
+	loadTestCmd.PersistentFlags().UintVar(&tcpConnections, "tcp-connections", 0, "Number of TCP connections shared by the --connections workers, 0 means one per worker. With --http-version 2 the workers send concurrent streams, with 1.1 they wait for a free connection")
 	loadTestCmd.PersistentFlags().DurationVarP(&testDuration, "duration", "d", 30*time.Second, "Test duration")
 	loadTestCmd.PersistentFlags().DurationVarP(&warmupDuration, "warmup", "w", 10*time.Second, "Warmup duration")
 	loadTestCmd.PersistentFlags().StringVarP(&apiKey, "api-key", "k", "", "API key to use in some load tests")
 	loadTestCmd.PersistentFlags().BoolVar(&createSession, "create-session", false, "Create a session for load tests (default is to use API Key as Basic auth header)")
-	loadTestCmd.PersistentFlags().BoolVar(&storeProfilingData, "store-profiling-data", false, "Store profiling data in a csv file")
+	loadTestCmd.PersistentFlags().BoolVar(&storeProfilingData, "store-profiling-data", false, "Store the profiling data of every request in a file in the working directory, see --profiling-data-file")
+	loadTestCmd.PersistentFlags().StringVar(&profilingDataFile, "profiling-data-file", "", "Store the profiling data of every request in this file, trials of --repeat add .trial-N to the name")
+	loadTestCmd.PersistentFlags().StringVar(&foldedStacksFile, "folded-stacks-file", "", "Write the profiling statistics in folded stack format to this file, to render a flamegraph")
+	loadTestCmd.PersistentFlags().Var(&profilingDataFormat, "profiling-data-format", "Format of the stored profiling data, support: csv, jsonl (JSON Lines), columnar (one JSON array per column)")
+	loadTestCmd.PersistentFlags().BoolVar(&storeLatencies, "store-latencies", false, "Include the raw test latencies in the JSON result, for exact confidence intervals when comparing results")
+	loadTestCmd.PersistentFlags().UintVar(&repeatCount, "repeat", 1, "Number of times to run the load test, results of repeated trials are aggregated")
+	loadTestCmd.PersistentFlags().DurationVar(&coolDown, "cool-down", 0, "Pause between repeated trials")
+	loadTestCmd.PersistentFlags().StringVar(&metricsListen, "metrics-listen", "", "Address to serve live Prometheus metrics on while the test runs, e.g. :9100")
+	loadTestCmd.PersistentFlags().StringArrayVar(&exportTargets, "export", nil, "Export interval and final statistics as InfluxDB line protocol, influx=<write URL> or influx-file=<path>, or only the interval statistics as JSON lines, interval-jsonl=<path>, may be repeated")
+	loadTestCmd.PersistentFlags().DurationVar(&timelineInterval, "timeline-interval", time.Second, "Interval of the test statistic timeline included in the result")
+	loadTestCmd.PersistentFlags().StringArrayVar(&thresholdSpecs, "threshold", nil, "Condition the test result must meet, e.g. p99<=20ms, qps>=950 or error_rate<0.01, may be repeated. The command exits with status 1 if one fails")
+	loadTestCmd.PersistentFlags().StringVar(&htmlReportPath, "html-report", "", "Also write an HTML report with charts of the results to this file")
+	loadTestCmd.PersistentFlags().Var(&authMethod, "auth-method", "App authentication method, support: api-key, cert, jwt (cert always creates a session)")
+	loadTestCmd.PersistentFlags().StringVar(&appID, "app-id", "", "App ID to use with certificate authentication")
+	loadTestCmd.PersistentFlags().StringVar(&jwtToken, "jwt", "", "Signed JWT to use with JWT authentication")
+	loadTestCmd.PersistentFlags().StringVar(&apiKeyFile, "api-key-file", "", "File with one API key per line to distribute over connections instead of --api-key")
+	loadTestCmd.PersistentFlags().Var(&apiKeyStrategy, "api-key-strategy", "How API keys from --api-key-file are used, support: round-robin (per connection), random (per request)")
 }
 
 type loadTestStage int
@@ -53,17 +94,105 @@ const (
 	testStage
 )
 
-type setupFunc func(client *sdkms.Client, testConfig *TestConfig) (interface{}, error)
+type setupFunc func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error)
 type testFunc func(client *sdkms.Client, stage loadTestStage, arg interface{}) (interface{}, time.Duration, profilingMetricStr, error)
 type cleanupFunc func(client *sdkms.Client)
 
 func loadTest(name string, setup setupFunc, test testFunc, cleanup cleanupFunc) {
+	creds, err := loadAppCredentials()
+	if err != nil {
+		log.Fatalf("Failed to load app credentials: %v\n", err)
+	}
+	thresholds, err := parseThresholds(thresholdSpecs)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	if _, _, err := newArrivalProcess(); err != nil {
+		log.Fatalf("Invalid arrival process: %v\n", err)
+	}
+	if err := validateDispatchOptions(); err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	startMetricsServer()
+	openExporters()
+
+	if repeatCount <= 1 {
+		summary := runLoadTest(name, setup, test, cleanup, creds)
+		summary.Result.Checks = checkThresholds(thresholds, summary.Result)
+		saveProfilingData(summary, 0)
+		saveFoldedStacks(summary, 0)
+		exportSummary(summary)
+		closeExporters()
+		writeTestSummary(summary)
+		writeHTMLReportIfRequested([]*TestSummary{summary})
+		exitOnFailedChecks([]*TestSummary{summary})
+		return
+	}
+	var trials []*TestSummary
+	for trial := uint(1); trial <= repeatCount; trial++ {
+		if trial > 1 && coolDown > 0 {
+			log.Printf("Cooling down for %v\n", coolDown)
+			time.Sleep(coolDown)
+		}
+		log.Printf("Trial %d/%d\n", trial, repeatCount)
+		summary := runLoadTest(name, setup, test, cleanup, creds)
+		summary.Result.Checks = checkThresholds(thresholds, summary.Result)
+		saveProfilingData(summary, trial)
+		saveFoldedStacks(summary, trial)
+		exportSummary(summary)
+		trials = append(trials, summary)
+	}
+	closeExporters()
+	writeTestSummary(newRepeatedTestSummary(trials))
+	writeHTMLReportIfRequested(trials)
+	exitOnFailedChecks(trials)
+}
+
+func exitOnFailedChecks(summaries []*TestSummary) {
+	if failed := failedChecks(summaries); failed > 0 {
+		log.Printf("%d threshold check(s) failed\n", failed)
+		os.Exit(1)
+	}
+}
+
+func writeHTMLReportIfRequested(summaries []*TestSummary) {
+	if htmlReportPath == "" {
+		return
+	}
+	if err := writeHTMLReportFile(htmlReportPath, summaries); err != nil {
+		log.Fatalf("Failed to write HTML report: %v\n", err)
+	}
+}
+
+// runLoadTest runs one load test, including its warmup, and returns its summary.
+func runLoadTest(name string, setup setupFunc, test testFunc, cleanup cleanupFunc, creds []appCredential) *TestSummary {
 	testTime := time.Now()
+	arrivals, arrivalConfig, err := newArrivalProcess()
+	if err != nil {
+		log.Fatalf("Invalid arrival process: %v\n", err)
+	}
+	targetQPS := arrivals.rate()
 
 	log.Printf("Load test:       %v\n", name)
 	log.Printf("Server:          %v:%v\n", serverName, serverPort)
-	log.Printf("Target QPS:      %v\n", queriesPerSecond)
+	log.Printf("Target QPS:      %v\n", targetQPS)
+	log.Printf("Arrival:         %v\n", arrivalConfig)
+	workers, sharedConnections := loadTestWorkers()
 	log.Printf("Connections:     %v\n", connections)
+	if openLoop {
+		log.Printf("Max In Flight:   %v (open loop)\n", workers)
+	}
+	if sharedConnections > 0 {
+		log.Printf("TCP Connections: %v\n", sharedConnections)
+	}
+	log.Printf("HTTP Version:    %v\n", httpVersion)
+	log.Printf("Auth Method:     %v\n", authMethod)
+	if len(creds) > 1 {
+		log.Printf("Apps:            %v (%v)\n", len(creds), apiKeyStrategy)
+	}
+	if apps := usedApps(len(creds), workers); apps < uint(len(creds)) {
+		log.Printf("Warning: only %v of the %v apps are used, round-robin assigns one app to each of the %v workers\n", apps, len(creds), workers)
+	}
 	log.Printf("Test Duration:   %v\n", testDuration)
 	log.Printf("Warmup Duration: %v\n", warmupDuration)
 
@@ -73,10 +202,19 @@ func loadTest(name string, setup setupFunc, test testFunc, cleanup cleanupFunc)
 		ServerPort:     serverPort,
 		VerifyTls:      !insecureTLS,
 		Connections:    connections,
-		CreateSession:  createSession,
+		AuthMethod:     string(authMethod),
+		CreateSession:  useSession(),
+		Apps:           usedApps(len(creds), workers),
+		AppStrategy:    string(apiKeyStrategy),
 		WarmupDuration: warmupDuration,
 		TestDuration:   testDuration,
-		TargetQPS:      queriesPerSecond,
+		TargetQPS:      targetQPS,
+		Arrival:        arrivalConfig,
+		TLS:            tlsSettings(),
+		HTTPVersion:    string(httpVersion),
+		TCPConnections: sharedConnections,
+		OpenLoop:       openLoop,
+		MaxInFlight:    workers,
 	}
 
 	type testMetric struct {
@@ -84,35 +222,105 @@ func loadTest(name string, setup setupFunc, test testFunc, cleanup cleanupFunc)
 		d time.Duration
 		p profilingMetricStr
 		s loadTestStage
+		a string
+		e string // error category of a failed request
+		w uint   // worker
+		h httpPhases
 	}
-	warmupTicker := time.NewTicker(time.Duration(warmupDuration.Nanoseconds() / int64(connections)))
+	warmupTicker := time.NewTicker(time.Duration(warmupDuration.Nanoseconds() / int64(workers)))
 	tokens := make(chan time.Time, 100)
+	// the token producer may outlive the test, it must not read the flag
+	dropWhenBusy := openLoop
+	if dropWhenBusy {
+		// a token is only taken by a worker waiting for it
+		tokens = make(chan time.Time)
+	}
+	start := make(chan struct{})
+	end := make(chan struct{})
+	var dispatch dispatchCounters
 	tokenProducer := func() {
-		interval := time.Duration(float64(time.Second.Nanoseconds()) / queriesPerSecond)
-		nextTick := time.Now().Add(interval)
+		nextTick := time.Now().Add(arrivals.next())
 		for {
 			time.Sleep(time.Until(nextTick))
-			tokens <- nextTick
-			nextTick = nextTick.Add(interval)
+			if dropWhenBusy {
+				select {
+				case <-end:
+					return
+				default:
+				}
+				select {
+				case tokens <- nextTick:
+				default:
+					dispatch.drop()
+				}
+			} else {
+				select {
+				case tokens <- nextTick:
+				case <-end:
+					return
+				}
+			}
+			nextTick = nextTick.Add(arrivals.next())
 		}
 	}
-	start := make(chan struct{})
-	end := make(chan struct{})
 	result := make(chan testMetric, 1000) // buffered channel just in case
+	queueDepth := func() int { return len(tokens) }
+	if dropWhenBusy {
+		queueDepth = nil
+	}
+	liveMetrics.startTest(targetQPS, queueDepth, &dispatch)
 	var ready, finished sync.WaitGroup
 	var wg1 sync.WaitGroup
 
-	launchWorker := func() {
-		callTestFunc := func(t time.Time, client *sdkms.Client, stage loadTestStage, arg interface{}) interface{} {
-			arg, d, p, err := test(client, stage, arg)
+	var reauthMutex sync.Mutex
+	var reauths []time.Duration
+	// reauthenticate ends the rejected session with cleanup and re-runs setup
+	// to obtain fresh credentials and the state that goes with them, the time
+	// spent is tracked apart from the test.
+	reauthenticate := func(client *sdkms.Client, cred *appCredential) (interface{}, bool) {
+		t0 := time.Now()
+		cleanup(client)
+		arg, err := setup(client, cred, &testConfig)
+		d := time.Since(t0)
+		if err != nil {
+			log.Printf("Error: failed to re-authenticate: %v\n", err)
+			return nil, false
+		}
+		reauthMutex.Lock()
+		reauths = append(reauths, d)
+		reauthMutex.Unlock()
+		return arg, true
+	}
+
+	var clientPool []*http.Client
+	if sharedConnections > 0 {
+		clientPool = newClientPool(sharedConnections)
+	}
+	launchWorker := func(worker uint) {
+		callTestFunc := func(t time.Time, client *sdkms.Client, tracer *httpTracer, cred *appCredential, stage loadTestStage, arg interface{}) interface{} {
+			liveMetrics.requestStarted()
+			tracer.reset()
+			newArg, d, p, err := test(client, stage, arg)
+			// only an expired session is restored by authenticating again, a
+			// rejected API key or token is reported as an error
+			if err != nil && stage == testStage && useSession() && isUnauthorizedError(err) {
+				if reauthArg, ok := reauthenticate(client, cred); ok {
+					arg = reauthArg
+					tracer.reset()
+					newArg, d, p, err = test(client, stage, arg)
+				}
+			}
+			liveMetrics.requestFinished(name, stage, d, err)
+			arg = newArg
 			if err != nil {
 				if stage == warmupStage {
 					log.Fatalf("Fatal error: %v\n", err)
 				} else {
 					log.Printf("Error: %v\n", err)
+					result <- testMetric{t: t, s: stage, a: cred.Name, e: errorCategory(err), w: worker}
 				}
 			} else {
-				result <- testMetric{t, d, p, stage}
+				result <- testMetric{t, d, p, stage, cred.Name, "", worker, tracer.recorded()}
 			}
 			return arg
 		}
@@ -121,35 +329,67 @@ func loadTest(name string, setup setupFunc, test testFunc, cleanup cleanupFunc)
 		wg1.Add(1)
 		go func() {
 			defer wg1.Done()
+			liveMetrics.workerStarted()
+			defer liveMetrics.workerStopped()
 
 			client := sdkmsClient()
-			arg, err := setup(&client, &testConfig)
+			if clientPool != nil {
+				client.HTTPClient = clientPool[worker%uint(len(clientPool))]
+			}
+			tracer := traceClient(&client)
+			// authorization and setup result of each app this worker has used
+			auths := make(map[int]sdkms.Authorization)
+			args := make(map[int]interface{})
+			cred := int(worker) % len(creds)
+			arg, err := setup(&client, &creds[cred], &testConfig)
 			if err != nil {
 				log.Fatalf("Fatal error: %v\n", err)
 			}
 			// ensure TLS is established
-			arg = callTestFunc(time.Now(), &client, warmupStage, arg)
+			arg = callTestFunc(time.Now(), &client, tracer, &creds[cred], warmupStage, arg)
+			auths[cred], args[cred] = client.Auth, arg
 			ready.Done()
 			<-start
 		testLoop:
 			for {
 				select {
-				case <-tokens:
-					arg = callTestFunc(time.Now(), &client, testStage, arg)
+				case tick := <-tokens:
+					dispatch.dispatched(tick)
+					if apiKeyStrategy == credentialStrategyRandom && len(creds) > 1 {
+						cred = rand.Intn(len(creds))
+						if auth, ok := auths[cred]; ok {
+							client.Auth, arg = auth, args[cred]
+						} else if arg, err = setup(&client, &creds[cred], &testConfig); err != nil {
+							// the token is used up, count it like a failed request
+							log.Printf("Error: %v\n", err)
+							result <- testMetric{t: time.Now(), s: testStage, a: creds[cred].Name, e: errorCategory(err), w: worker}
+							continue
+						}
+					}
+					arg = callTestFunc(time.Now(), &client, tracer, &creds[cred], testStage, arg)
+					auths[cred], args[cred] = client.Auth, arg
 				case <-end:
 					break testLoop
 				}
 			}
 			finished.Done()
-			cleanup(&client)
+			for _, auth := range auths {
+				client.Auth = auth
+				cleanup(&client)
+			}
 		}()
 	}
 
 	var wg2 sync.WaitGroup
 	wg2.Add(2)
 	var warmups, tests []time.Duration
+	var testTimes, errorTimes []time.Time
+	errorCounts := make(map[string]uint)
+	perApp := make(map[string][]time.Duration)
 	var lastTick time.Time
-	var profilingMetricStrArr []profilingMetricStr
+	var profilingRecords []profilingRecord
+	var phases []httpPhases
+	var handshakes []tlsHandshake
 
 	go func() {
 		defer wg2.Done()
@@ -160,34 +400,50 @@ func loadTest(name string, setup setupFunc, test testFunc, cleanup cleanupFunc)
 				warmups = append(warmups, r.d)
 				// use last warmup ticket as start point
 				lastPrintQpsTick = r.t
+			} else if r.e != "" {
+				errorCounts[r.e]++
+				errorTimes = append(errorTimes, r.t)
 			} else {
 				tests = append(tests, r.d)
+				testTimes = append(testTimes, r.t)
+				perApp[r.a] = append(perApp[r.a], r.d)
+				if r.h.Traced {
+					phases = append(phases, r.h)
+				}
+				if r.h.Handshake != nil {
+					handshakes = append(handshakes, *r.h.Handshake)
+				}
 				if r.t.After(lastPrintQpsTick.Add(QPS_PRINT_INTERVAL)) {
 					dur := r.t.Sub(lastPrintQpsTick)
 					currentQueryNum := len(tests)
 					log.Printf("Last %v QPS: %.3f\n", dur.Truncate(time.Millisecond*100), float64(currentQueryNum-lastQueryNum)/dur.Seconds())
+					exportInterval(&testConfig, r.t, StatisticFromDurations(tests[lastQueryNum:], dur))
 					lastQueryNum = currentQueryNum
 					lastPrintQpsTick = r.t
 				}
 				if r.p != "" {
-					profilingMetricStrArr = append(profilingMetricStrArr, r.p)
+					profilingRecords = append(profilingRecords, profilingRecord{Time: r.t, Latency: r.d, Worker: r.w, raw: r.p})
+					liveMetrics.observeProfiling(r.p)
 				}
 			}
 			lastTick = r.t
 		}
 	}()
 
-	for i := uint(0); i < connections; i++ {
+	for i := uint(0); i < workers; i++ {
 		<-warmupTicker.C
-		launchWorker()
+		launchWorker(i)
 	}
 	warmupTicker.Stop()
 	ready.Wait()
 	log.Printf("Warmup completed")
+	testConfig.TLS.Negotiated = negotiatedTLSParameters()
+	connCounters.reset()
 	go tokenProducer()
 	t0 := time.Now()
 	close(start)
 	var t1 time.Time
+	var connStats *ConnectionStatistics
 
 	go func() {
 		defer wg2.Done()
@@ -196,6 +452,7 @@ func loadTest(name string, setup setupFunc, test testFunc, cleanup cleanupFunc)
 		close(end)
 		finished.Wait()
 		t1 = time.Now()
+		connStats = connCounters.statistics()
 	}()
 	wg1.Wait()
 	close(result)
@@ -210,33 +467,80 @@ func loadTest(name string, setup setupFunc, test testFunc, cleanup cleanupFunc)
 		ActualTestDuration: testDuration,
 		SendDuration:       sendDuration,
 		ProfilingResults:   nil,
+		Reauthentication:   StatisticFromDurations(reauths, testDuration),
+		TestHistogram:      HistogramFromDurations(tests),
+		HTTPPhases:         httpPhaseStatisticsFromPhases(phases),
+		TLSHandshakes:      tlsHandshakeStatisticsFromHandshakes(handshakes, testDuration),
+		ConnectionStats:    connStats,
+		Dispatch:           dispatch.statistics(workers, targetQPS, testDuration),
+		Timeline:           timelineFromDurations(t0, sendDuration, timelineInterval, testTimes, tests, errorTimes),
+	}
+	if len(errorCounts) != 0 {
+		testResult.Errors = errorCounts
+	}
+	if storeLatencies {
+		testResult.TestLatencies = stats.LoadRawData(tests)
 	}
 
-	if len(profilingMetricStrArr) != 0 {
-		dataArr := parseProfilingMetricStrArr(profilingMetricStrArr)
-		testResult.ProfilingResults = getProfilingMetrics(dataArr)
-		if storeProfilingData {
-			saveProfilingMetricsToCSV(dataArr)
+	if len(creds) > 1 {
+		testResult.PerApp = make(map[string]*Statistic)
+		for app, times := range perApp {
+			testResult.PerApp[app] = StatisticFromDurations(times, testDuration)
 		}
 	}
 
-	testSummary := &TestSummary{
-		TestTime: testTime.Format(time.RFC3339),
-		Config:   &testConfig,
-		Result:   &testResult,
+	if len(profilingRecords) != 0 {
+		dataArr := parseProfilingRecords(profilingRecords)
+		testResult.ProfilingResults = getProfilingMetrics(dataArr)
+		times, overheads := requestOverheads(profilingRecords)
+		testResult.Overhead = StatisticFromDurations(overheads, testDuration)
+		addOverheadTimeline(testResult.Timeline, t0, timelineInterval, times, overheads)
+	}
+
+	return &TestSummary{
+		TestTime:  testTime.Format(time.RFC3339),
+		Config:    &testConfig,
+		Result:    &testResult,
+		profiling: profilingRecords,
 	}
+}
 
+type testSummaryWriter interface {
+	TestSummaryPlainWriter
+	TestSummaryJsonWriter
+	TestSummaryJUnitWriter
+	TestSummaryMarkdownWriter
+	TestSummaryCsvWriter
+}
+
+// writeTestSummary writes the summary to stdout in the selected output format.
+func writeTestSummary(summary testSummaryWriter) {
 	switch outputFormat {
 	case Plain:
-		err := testSummary.WritePlain(os.Stdout)
+		err := summary.WritePlain(os.Stdout)
 		if err != nil {
 			log.Fatalf("failed to write test summary in plain: %v\n", err)
 		}
 	case JSON:
-		err := testSummary.WriteJson(os.Stdout)
+		err := summary.WriteJson(os.Stdout)
 		if err != nil {
 			log.Fatalf("failed to write test summary in json: %v\n", err)
 		}
+	case JUnit:
+		err := summary.WriteJUnit(os.Stdout)
+		if err != nil {
+			log.Fatalf("failed to write test summary in junit: %v\n", err)
+		}
+	case Markdown:
+		err := summary.WriteMarkdown(os.Stdout)
+		if err != nil {
+			log.Fatalf("failed to write test summary in markdown: %v\n", err)
+		}
+	case CSV:
+		err := summary.WriteCsv(os.Stdout)
+		if err != nil {
+			log.Fatalf("failed to write test summary in csv: %v\n", err)
+		}
 	// TODO: See issue: #19
 	case YAML:
 		log.Fatalf("write test summary in yaml is not yet supported\n")
@@ -244,3 +548,36 @@ func loadTest(name string, setup setupFunc, test testFunc, cleanup cleanupFunc)
 		log.Fatalf("unreachable: unacceptable output format option: %v\n", outputFormat)
 	}
 }
+
+// errorCategory classifies the error of a failed request for the error
+// breakdown of the result.
+func errorCategory(err error) string {
+	var backendErr *sdkms.BackendError
+	if errors.As(err, &backendErr) {
+		return fmt.Sprintf("HTTP %d", backendErr.StatusCode)
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "network"
+	}
+	return "other"
+}
+
+// usedApps returns the number of apps the workers use: round-robin assigns
+// one app to each worker, so apps beyond the number of workers are left out.
+func usedApps(apps int, workers uint) uint {
+	if apiKeyStrategy != credentialStrategyRandom && uint(apps) > workers {
+		return workers
+	}
+	return uint(apps)
+}
+
+// isUnauthorizedError reports whether err is the server rejecting the
+// credentials of a request, e.g. because the session has expired.
+func isUnauthorizedError(err error) bool {
+	var backendErr *sdkms.BackendError
+	return errors.As(err, &backendErr) && backendErr.StatusCode == http.StatusUnauthorized
+}
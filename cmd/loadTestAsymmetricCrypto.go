@@ -32,35 +32,43 @@ func init() {
 
 	asymmetricCryptoLoadTestCmd.PersistentFlags().StringVar(&keyID, "kid", "", "Key ID to use for asymmetric crypto")
 	asymmetricCryptoLoadTestCmd.PersistentFlags().BoolVar(&decryptOpt, "decrypt", false, "Perform decryption instead of encryption")
+	addKeyPoolFlags(asymmetricCryptoLoadTestCmd)
 }
 
 func asymmetricCryptoLoadTest() {
+	keys := loadKeyPool(keyID, sdkms.ObjectTypeRsa)
 	// get basic info of the given sobject
-	key := GetSobject(&keyID)
+	key := GetSobject(&keys.kids[0])
+	// one ciphertext per key to decrypt
+	var ciphers keyData
 
-	setup := func(client *sdkms.Client, testConfig *TestConfig) (interface{}, error) {
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
 		if testConfig.Sobject == nil {
 			testConfig.Sobject = key
+			testConfig.KeyCount = uint(keys.size())
+			testConfig.KeySelection = string(keySelectionOpt)
 		}
-		if createSession {
-			_, err := client.AuthenticateWithAPIKey(context.Background(), apiKey)
+		if err := authenticateApp(client, cred, createSession); err != nil || !decryptOpt {
 			return nil, err
 		}
-		client.Auth = sdkms.APIKey(apiKey)
-		return nil, nil
+		return nil, ciphers.prepare(keys.kids, func(kid string) (interface{}, error) {
+			er, _, _, err := asymmetricEncrypt(client, kid)
+			return er, err
+		})
 	}
 	cleanup := func(client *sdkms.Client) {
-		if createSession {
+		if useSession() {
 			client.TerminateSession(context.Background())
 		}
 	}
 	test := func(client *sdkms.Client, stage loadTestStage, arg interface{}) (interface{}, time.Duration, profilingMetricStr, error) {
-		if er, ok := arg.(*sdkms.EncryptResponse); decryptOpt && ok {
-			_, d, p, err := asymmetricDecrypt(client, *er)
-			// return the encrypt response so we can decrypt in the next iteration
-			return er, d, p, err
+		kid := keys.pick()
+		if decryptOpt {
+			_, d, p, err := asymmetricDecrypt(client, kid, *ciphers.get(kid).(*sdkms.EncryptResponse))
+			return arg, d, p, err
 		}
-		return asymmetricEncrypt(client)
+		_, d, p, err := asymmetricEncrypt(client, kid)
+		return arg, d, p, err
 	}
 
 	// construct test name
@@ -68,18 +76,19 @@ func asymmetricCryptoLoadTest() {
 	if decryptOpt {
 		name = "asymmetric decryption"
 	}
-	if createSession {
+	if useSession() {
 		name += " with session"
 	}
 	name = fmt.Sprintf("%s %d %s", key.ObjType, *key.KeySize, name)
+	name += keyPoolNameSuffix(keys)
 
 	// start the load test
 	loadTest(name, setup, test, cleanup)
 }
 
-func asymmetricEncrypt(client *sdkms.Client) (*sdkms.EncryptResponse, time.Duration, profilingMetricStr, error) {
+func asymmetricEncrypt(client *sdkms.Client, kid string) (*sdkms.EncryptResponse, time.Duration, profilingMetricStr, error) {
 	req := sdkms.EncryptRequest{
-		Key:   sdkms.SobjectByID(keyID),
+		Key:   sdkms.SobjectByID(kid),
 		Alg:   sdkms.AlgorithmRsa,
 		Plain: []byte(ASYM_EXAMPLE_DATA),
 	}
@@ -96,9 +105,9 @@ func asymmetricEncrypt(client *sdkms.Client) (*sdkms.EncryptResponse, time.Durat
 	return res, d, p, err
 }
 
-func asymmetricDecrypt(client *sdkms.Client, c sdkms.EncryptResponse) (*sdkms.DecryptResponse, time.Duration, profilingMetricStr, error) {
+func asymmetricDecrypt(client *sdkms.Client, kid string, c sdkms.EncryptResponse) (*sdkms.DecryptResponse, time.Duration, profilingMetricStr, error) {
 	req := sdkms.DecryptRequest{
-		Key:    sdkms.SobjectByID(keyID),
+		Key:    sdkms.SobjectByID(kid),
 		Alg:    someAlgorithm(sdkms.AlgorithmRsa),
 		Cipher: c.Cipher,
 		Iv:     c.Iv,
@@ -46,9 +46,9 @@ func loadTestGenerateKey() {
 			keySize = 2048
 		}
 	}
-	setup := func(client *sdkms.Client, testConfig *TestConfig) (interface{}, error) {
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
 		// Key generation always needs to create session
-		_, err := client.AuthenticateWithAPIKey(context.Background(), apiKey)
+		err := authenticateApp(client, cred, true)
 		if err != nil {
 			return nil, err
 		}
@@ -41,11 +41,16 @@ func init() {
 func invokePluginLoadTest() {
 	// Get the given plugin from the server
 	client := sdkmsClient()
-	client.Auth = sdkms.APIKey(apiKey)
+	if err := authenticateApp(&client, firstAppCredential(), false); err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
 	plugin, err := client.GetPlugin(context.Background(), pluginID)
 	if err != nil {
 		log.Fatalf("Fatal error: %v\n", err)
 	}
+	if appSessionRequired() {
+		client.TerminateSession(context.Background())
+	}
 
 	input := json.RawMessage(pluginInput)
 	_, err = json.Marshal(&input)
@@ -53,32 +58,27 @@ func invokePluginLoadTest() {
 		log.Fatalf("Plugin input must be valid JSON: %v\n", err)
 	}
 
-	setup := func(client *sdkms.Client, testConfig *TestConfig) (interface{}, error) {
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
 		if testConfig.Plugin != nil {
 			testConfig.Plugin = plugin
 		}
 		if testConfig.PluginInput != nil {
 			testConfig.PluginInput = &input
 		}
-		if createSession {
-			_, err := client.AuthenticateWithAPIKey(context.Background(), apiKey)
-			return nil, err
-		}
-		client.Auth = sdkms.APIKey(apiKey)
-		return nil, nil
+		return nil, authenticateApp(client, cred, createSession)
 	}
 	cleanup := func(client *sdkms.Client) {
-		if createSession {
+		if useSession() {
 			client.TerminateSession(context.Background())
 		}
 	}
 	test := func(client *sdkms.Client, stage loadTestStage, arg interface{}) (interface{}, time.Duration, profilingMetricStr, error) {
-		return invokePlugin(client)
+		return invokePlugin(client, pluginID)
 	}
 
 	// construct test name
 	name := fmt.Sprintf("Invoke plugin '%s'", plugin.Name)
-	if createSession {
+	if useSession() {
 		name += " with session"
 	}
 
@@ -86,13 +86,13 @@ func invokePluginLoadTest() {
 	loadTest(name, setup, test, cleanup)
 }
 
-func invokePlugin(client *sdkms.Client) (*sdkms.PluginOutput, time.Duration, profilingMetricStr, error) {
+func invokePlugin(client *sdkms.Client, id string) (*sdkms.PluginOutput, time.Duration, profilingMetricStr, error) {
 	input := json.RawMessage(pluginInput)
 
 	ctx := sdkms.IncludeRawResponse(context.Background())
 
 	t0 := time.Now()
-	res, err := client.InvokePlugin(ctx, pluginID, &input)
+	res, err := client.InvokePlugin(ctx, id, &input)
 	d := time.Since(t0)
 
 	header := sdkms.GetRawResponse(ctx).Header
@@ -0,0 +1,321 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var replayTraceFile string
+var replayKeyMap map[string]string
+var replayAsymKeyID string
+
+var replayLoadTestCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Replay recorded traffic.",
+	Long: `Replay a trace written by the record command: each recorded request is sent
+with the same operation and at the same time since the start, or --speed times
+faster. The recorded keys are mapped to test keys with --key-map, unmapped keys
+use the key of their operation (--kid, --rsa-kid or --sign-kid), or the
+recorded key if none is given. Keys given or recorded by name are looked up
+by name on the tested cluster before the test. The test runs for the
+duration of the trace unless --duration is given, a longer test replays the
+trace again after the mean interval of the trace.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !cmd.Flags().Changed("duration") {
+			testDuration = 0
+		}
+		replayLoadTest()
+	},
+}
+
+func init() {
+	loadTestCmd.AddCommand(replayLoadTestCmd)
+
+	replayLoadTestCmd.PersistentFlags().StringVar(&replayTraceFile, "trace", "", "Trace file written by the record command")
+	replayLoadTestCmd.PersistentFlags().StringToStringVar(&replayKeyMap, "key-map", nil, "Recorded key ID or name, or plugin ID, to use a test key or plugin instead, e.g. prod-key=<test key ID>, may be repeated")
+	replayLoadTestCmd.PersistentFlags().StringVar(&keyID, "kid", "", "Key ID for the unmapped keys of symmetric encryption and decryption")
+	replayLoadTestCmd.PersistentFlags().StringVar(&replayAsymKeyID, "rsa-kid", "", "Key ID for the unmapped keys of asymmetric encryption and decryption")
+	replayLoadTestCmd.PersistentFlags().StringVar(&signKeyID, "sign-kid", "", "Key ID for the unmapped keys of sign and verify")
+	replayLoadTestCmd.PersistentFlags().StringVar(&pluginID, "plugin-id", "", "Plugin ID for the unmapped plugins")
+	replayLoadTestCmd.PersistentFlags().StringVar(&pluginInput, "plugin-input", "null", "Input to pass to the plugins")
+	replayLoadTestCmd.PersistentFlags().StringVar(&cipherModeStr, "mode", "CBC", "Cipher mode of symmetric encryption/decryption, support: CBC, GCM, FPE")
+}
+
+// replayRequest is a trace record with the test key to use.
+type replayRequest struct {
+	operation string
+	kid       string
+}
+
+// replayKeys are the ciphertexts and signatures to decrypt and verify, one
+// per key of the trace, prepared before the test and shared by the workers.
+type replayKeys struct {
+	ciphers     keyData
+	asymCiphers keyData
+	signatures  keyData
+}
+
+// prepare creates the ciphertexts and signatures of the keys of the decrypt
+// and verify requests with client.
+func (k *replayKeys) prepare(client *sdkms.Client, requests []replayRequest) error {
+	kids := make(map[string][]string)
+	for _, req := range requests {
+		kids[req.operation] = append(kids[req.operation], req.kid)
+	}
+	if err := k.ciphers.prepare(kids[traceOpDecrypt], func(kid string) (interface{}, error) {
+		er, _, _, err := encrypt(client, kid)
+		return er, err
+	}); err != nil {
+		return err
+	}
+	if err := k.asymCiphers.prepare(kids[traceOpAsymDecrypt], func(kid string) (interface{}, error) {
+		er, _, _, err := asymmetricEncrypt(client, kid)
+		return er, err
+	}); err != nil {
+		return err
+	}
+	return k.signatures.prepare(kids[traceOpVerify], func(kid string) (interface{}, error) {
+		sr, _, _, err := sign(client, kid)
+		return sr, err
+	})
+}
+
+func replayLoadTest() {
+	file, err := os.Open(replayTraceFile)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	records, err := readTrace(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("Invalid trace %v: %v\n", replayTraceFile, err)
+	}
+	if traceSpeed <= 0 {
+		log.Fatalf("Invalid speed: %v\n", traceSpeed)
+	}
+	if len(records) < 2 || traceDuration(records, traceSpeed) <= 0 {
+		log.Fatalf("Invalid trace %v: the requests must span some time\n", replayTraceFile)
+	}
+	requests, err := replayRequests(records)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	if err := resolveReplayKeyNames(requests); err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	operations := make(map[string]uint)
+	for _, req := range requests {
+		operations[req.operation]++
+	}
+	cipherMode = validateCipherMode(cipherModeStr)
+	if keySize == 0 {
+		keySize = 256
+	}
+
+	arrival = arrivalTrace
+	arrivalTraceFile = replayTraceFile
+	arrivalTraceIntervals = traceIntervals(records)
+	if testDuration == 0 {
+		testDuration = traceDuration(records, traceSpeed)
+	}
+
+	// the index of the next request, the tokens follow the trace from its start
+	var next uint64
+	var keys replayKeys
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
+		if testConfig.Operations == nil {
+			testConfig.Operations = operations
+			testConfig.Mode = cipherModeStr
+			atomic.StoreUint64(&next, 0)
+		}
+		if err := authenticateApp(client, cred, createSession); err != nil {
+			return nil, err
+		}
+		return nil, keys.prepare(client, requests)
+	}
+	cleanup := func(client *sdkms.Client) {
+		if useSession() {
+			client.TerminateSession(context.Background())
+		}
+	}
+	test := func(client *sdkms.Client, stage loadTestStage, arg interface{}) (interface{}, time.Duration, profilingMetricStr, error) {
+		if stage == warmupStage {
+			// only establish the connection, the trace starts with the test
+			_, err := client.Version(context.Background(), nil)
+			return arg, 0, "", err
+		}
+		i := atomic.AddUint64(&next, 1) - 1
+		d, p, err := replay(client, &keys, requests[i%uint64(len(requests))])
+		return arg, d, p, err
+	}
+
+	name := fmt.Sprintf("Replay %s (%s)", replayTraceFile, formatOperationCounts(operations))
+	if traceSpeed != 1 {
+		name += fmt.Sprintf(" at %gx", traceSpeed)
+	}
+	if useSession() {
+		name += " with session"
+	}
+
+	loadTest(name, setup, test, cleanup)
+}
+
+// replayRequests maps the keys of the trace to test keys, an error lists the
+// operations without a key.
+func replayRequests(records []TraceRecord) ([]replayRequest, error) {
+	defaults := map[string]string{
+		traceOpEncrypt:     keyID,
+		traceOpDecrypt:     keyID,
+		traceOpAsymEncrypt: replayAsymKeyID,
+		traceOpAsymDecrypt: replayAsymKeyID,
+		traceOpSign:        signKeyID,
+		traceOpVerify:      signKeyID,
+		traceOpPlugin:      pluginID,
+	}
+	missing := make(map[string]bool)
+	requests := make([]replayRequest, len(records))
+	for i, record := range records {
+		switch record.Operation {
+		case traceOpEncrypt, traceOpDecrypt, traceOpAsymEncrypt, traceOpAsymDecrypt, traceOpSign, traceOpVerify, traceOpPlugin:
+		case traceOpGenerateKey, traceOpVersion:
+			requests[i] = replayRequest{operation: record.Operation}
+			continue
+		default:
+			return nil, fmt.Errorf("unsupported operation in trace: %v", record.Operation)
+		}
+		kid, ok := replayKeyMap[record.Key]
+		if !ok {
+			kid = defaults[record.Operation]
+		}
+		if kid == "" {
+			kid = record.Key
+		}
+		if kid == "" {
+			missing[record.Operation] = true
+		}
+		requests[i] = replayRequest{operation: record.Operation, kid: kid}
+	}
+	if len(missing) != 0 {
+		var ops []string
+		for op := range missing {
+			ops = append(ops, op)
+		}
+		sort.Strings(ops)
+		return nil, fmt.Errorf("no key recorded or given for: %v", strings.Join(ops, ", "))
+	}
+	return requests, nil
+}
+
+// resolveReplayKeyNames replaces the keys that are not a key ID, e.g. a name
+// recorded from a request by name, with the ID of the key of that name on the
+// tested cluster. Plugins are kept as they are always recorded by ID.
+func resolveReplayKeyNames(requests []replayRequest) error {
+	names := make(map[string]string)
+	for _, req := range requests {
+		if req.kid == "" || req.operation == traceOpPlugin {
+			continue
+		}
+		if _, err := uuid.Parse(req.kid); err != nil {
+			names[req.kid] = ""
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	client := sdkmsClient()
+	if err := authenticateApp(&client, firstAppCredential(), false); err != nil {
+		return err
+	}
+	if appSessionRequired() {
+		defer client.TerminateSession(context.Background())
+	}
+	for name := range names {
+		sobject, err := client.GetSobject(context.Background(), nil, *sdkms.SobjectByName(name))
+		if err != nil {
+			return fmt.Errorf("failed to look up key %v by name, map it to a test key with --key-map: %v", name, err)
+		}
+		if sobject.Kid == nil {
+			return fmt.Errorf("key %v has no ID", name)
+		}
+		names[name] = *sobject.Kid
+	}
+	for i := range requests {
+		if kid, ok := names[requests[i].kid]; ok && requests[i].operation != traceOpPlugin {
+			requests[i].kid = kid
+		}
+	}
+	return nil
+}
+
+// replay sends a request with the helper of the load test of its operation.
+func replay(client *sdkms.Client, keys *replayKeys, req replayRequest) (time.Duration, profilingMetricStr, error) {
+	switch req.operation {
+	case traceOpEncrypt:
+		_, d, p, err := encrypt(client, req.kid)
+		return d, p, err
+	case traceOpDecrypt:
+		_, d, p, err := decrypt(client, req.kid, *keys.ciphers.get(req.kid).(*sdkms.EncryptResponse))
+		return d, p, err
+	case traceOpAsymEncrypt:
+		_, d, p, err := asymmetricEncrypt(client, req.kid)
+		return d, p, err
+	case traceOpAsymDecrypt:
+		_, d, p, err := asymmetricDecrypt(client, req.kid, *keys.asymCiphers.get(req.kid).(*sdkms.EncryptResponse))
+		return d, p, err
+	case traceOpSign:
+		_, d, p, err := sign(client, req.kid)
+		return d, p, err
+	case traceOpVerify:
+		_, d, p, err := verify(client, req.kid, *keys.signatures.get(req.kid).(*sdkms.SignResponse))
+		return d, p, err
+	case traceOpPlugin:
+		_, d, p, err := invokePlugin(client, req.kid)
+		return d, p, err
+	case traceOpGenerateKey:
+		_, d, p, err := generateKey(client)
+		return d, p, err
+	default:
+		ctx := sdkms.IncludeRawResponse(context.Background())
+		t0 := time.Now()
+		_, err := client.Version(ctx, nil)
+		d := time.Since(t0)
+		p := profilingMetricStr(sdkms.GetRawResponse(ctx).Header.Get("Profiling-Data"))
+		return d, p, err
+	}
+}
+
+// formatOperationCounts lists the operations by decreasing count.
+func formatOperationCounts(counts map[string]uint) string {
+	ops := make([]string, 0, len(counts))
+	for op := range counts {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		if counts[ops[i]] != counts[ops[j]] {
+			return counts[ops[i]] > counts[ops[j]]
+		}
+		return ops[i] < ops[j]
+	})
+	parts := make([]string, len(ops))
+	for i, op := range ops {
+		parts[i] = fmt.Sprintf("%s: %d", op, counts[op])
+	}
+	return strings.Join(parts, ", ")
+}
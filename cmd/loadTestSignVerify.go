@@ -36,35 +36,43 @@ func init() {
 
 	signVerifyLoadTestCmd.PersistentFlags().StringVar(&signKeyID, "kid", "", "Key ID to use for sign and verify")
 	signVerifyLoadTestCmd.PersistentFlags().BoolVar(&verifyOpt, "verify", false, "Perform verification instead of sign")
+	addKeyPoolFlags(signVerifyLoadTestCmd)
 }
 
 func signVerifyLoadTest() {
+	keys := loadKeyPool(signKeyID, sdkms.ObjectTypeRsa, sdkms.ObjectTypeEc)
 	// get basic info of the given sobject
-	key := GetSobject(&signKeyID)
+	key := GetSobject(&keys.kids[0])
+	// one signature per key to verify
+	var signatures keyData
 
-	setup := func(client *sdkms.Client, testConfig *TestConfig) (interface{}, error) {
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
 		if testConfig.Sobject == nil {
 			testConfig.Sobject = key
+			testConfig.KeyCount = uint(keys.size())
+			testConfig.KeySelection = string(keySelectionOpt)
 		}
-		if createSession {
-			_, err := client.AuthenticateWithAPIKey(context.Background(), apiKey)
+		if err := authenticateApp(client, cred, createSession); err != nil || !verifyOpt {
 			return nil, err
 		}
-		client.Auth = sdkms.APIKey(apiKey)
-		return nil, nil
+		return nil, signatures.prepare(keys.kids, func(kid string) (interface{}, error) {
+			sr, _, _, err := sign(client, kid)
+			return sr, err
+		})
 	}
 	cleanup := func(client *sdkms.Client) {
-		if createSession {
+		if useSession() {
 			client.TerminateSession(context.Background())
 		}
 	}
 	test := func(client *sdkms.Client, stage loadTestStage, arg interface{}) (interface{}, time.Duration, profilingMetricStr, error) {
-		if signResp, ok := arg.(*sdkms.SignResponse); verifyOpt && ok {
-			_, d, p, err := verify(client, *signResp)
-			// return the sign response so we can verify in the next iteration
-			return signResp, d, p, err
+		kid := keys.pick()
+		if verifyOpt {
+			_, d, p, err := verify(client, kid, *signatures.get(kid).(*sdkms.SignResponse))
+			return arg, d, p, err
 		}
-		return sign(client)
+		_, d, p, err := sign(client, kid)
+		return arg, d, p, err
 	}
 
 	// construct test name
@@ -72,19 +80,20 @@ func signVerifyLoadTest() {
 	if verifyOpt {
 		name = "verify"
 	}
-	if createSession {
+	if useSession() {
 		name += " with session"
 	}
 	name = fmt.Sprintf("%s %d %s", key.ObjType, *key.KeySize, name)
+	name += keyPoolNameSuffix(keys)
 
 	loadTest(name, setup, test, cleanup)
 }
 
-func sign(client *sdkms.Client) (*sdkms.SignResponse, time.Duration, profilingMetricStr, error) {
+func sign(client *sdkms.Client, kid string) (*sdkms.SignResponse, time.Duration, profilingMetricStr, error) {
 	req := sdkms.SignRequest{
 		Data:    someBlob([]byte(SIGN_EXAMPLE_DATA)),
 		HashAlg: sdkms.DigestAlgorithmSha256,
-		Key:     sdkms.SobjectByID(signKeyID),
+		Key:     sdkms.SobjectByID(kid),
 	}
 
 	ctx := sdkms.IncludeRawResponse(context.Background())
@@ -99,10 +108,10 @@ func sign(client *sdkms.Client) (*sdkms.SignResponse, time.Duration, profilingMe
 	return res, d, p, err
 }
 
-func verify(client *sdkms.Client, sr sdkms.SignResponse) (*sdkms.VerifyResponse, time.Duration, profilingMetricStr, error) {
+func verify(client *sdkms.Client, kid string, sr sdkms.SignResponse) (*sdkms.VerifyResponse, time.Duration, profilingMetricStr, error) {
 	req := sdkms.VerifyRequest{
 		Signature: sr.Signature,
-		Key:       sdkms.SobjectByID(signKeyID),
+		Key:       sdkms.SobjectByID(kid),
 		HashAlg:   sdkms.DigestAlgorithmSha256,
 		Data:      someBlob([]byte(SIGN_EXAMPLE_DATA)),
 	}
@@ -41,37 +41,46 @@ func init() {
 	symmetricCryptoLoadTestCmd.PersistentFlags().StringVar(&keyID, "kid", "", "Key ID to use for symmetric crypto")
 	symmetricCryptoLoadTestCmd.PersistentFlags().BoolVar(&decryptOpt, "decrypt", false, "Perform decryption instead of encryption")
 	symmetricCryptoLoadTestCmd.PersistentFlags().StringVar(&cipherModeStr, "mode", "CBC", "Cipher mode used for encryption/decryption, support: CBC, GCM, FPE")
+	addKeyPoolFlags(symmetricCryptoLoadTestCmd)
 }
 
 func symmetricCryptoLoadTest() {
 	cipherMode = validateCipherMode(cipherModeStr)
 
+	keys := loadKeyPool(keyID, sdkms.ObjectTypeAes)
 	// get basic info of the given sobject
-	key := GetSobject(&keyID)
+	key := GetSobject(&keys.kids[0])
+	// one ciphertext per key to decrypt
+	var ciphers keyData
 
-	setup := func(client *sdkms.Client, testConfig *TestConfig) (interface{}, error) {
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
 		if testConfig.Sobject == nil {
 			testConfig.Sobject = key
+			testConfig.KeyCount = uint(keys.size())
+			testConfig.KeySelection = string(keySelectionOpt)
+			testConfig.Mode = cipherModeStr
 		}
-		if createSession {
-			_, err := client.AuthenticateWithAPIKey(context.Background(), apiKey)
+		if err := authenticateApp(client, cred, createSession); err != nil || !decryptOpt {
 			return nil, err
 		}
-		client.Auth = sdkms.APIKey(apiKey)
-		return nil, nil
+		return nil, ciphers.prepare(keys.kids, func(kid string) (interface{}, error) {
+			er, _, _, err := encrypt(client, kid)
+			return er, err
+		})
 	}
 	cleanup := func(client *sdkms.Client) {
-		if createSession {
+		if useSession() {
 			client.TerminateSession(context.Background())
 		}
 	}
 	test := func(client *sdkms.Client, stage loadTestStage, arg interface{}) (interface{}, time.Duration, profilingMetricStr, error) {
-		if er, ok := arg.(*sdkms.EncryptResponse); decryptOpt && ok {
-			_, d, p, err := decrypt(client, *er)
-			// return the encrypt response so we can decrypt in the next iteration
-			return er, d, p, err
+		kid := keys.pick()
+		if decryptOpt {
+			_, d, p, err := decrypt(client, kid, *ciphers.get(kid).(*sdkms.EncryptResponse))
+			return arg, d, p, err
 		}
-		return encrypt(client)
+		_, d, p, err := encrypt(client, kid)
+		return arg, d, p, err
 	}
 
 	// construct test name
@@ -80,7 +89,7 @@ func symmetricCryptoLoadTest() {
 		operation = "symmetric decryption"
 	}
 	session := "without session"
-	if createSession {
+	if useSession() {
 		session = "with session"
 	}
 	hiVolume := ""
@@ -88,14 +97,15 @@ func symmetricCryptoLoadTest() {
 		hiVolume = "High Volume "
 	}
 	name := fmt.Sprintf("%s%s %d %s %s %s", hiVolume, key.ObjType, *key.KeySize, cipherModeStr, operation, session)
+	name += keyPoolNameSuffix(keys)
 
 	// start the load test
 	loadTest(name, setup, test, cleanup)
 }
 
-func encrypt(client *sdkms.Client) (*sdkms.EncryptResponse, time.Duration, profilingMetricStr, error) {
+func encrypt(client *sdkms.Client, kid string) (*sdkms.EncryptResponse, time.Duration, profilingMetricStr, error) {
 	req := sdkms.EncryptRequest{
-		Key:    sdkms.SobjectByID(keyID),
+		Key:    sdkms.SobjectByID(kid),
 		Alg:    sdkms.AlgorithmAes,
 		Plain:  []byte(SYM_EXAMPLE_DATA),
 		Mode:   sdkms.CryptModeSymmetric(cipherMode),
@@ -114,9 +124,9 @@ func encrypt(client *sdkms.Client) (*sdkms.EncryptResponse, time.Duration, profi
 	return res, d, p, err
 }
 
-func decrypt(client *sdkms.Client, c sdkms.EncryptResponse) (*sdkms.DecryptResponse, time.Duration, profilingMetricStr, error) {
+func decrypt(client *sdkms.Client, kid string, c sdkms.EncryptResponse) (*sdkms.DecryptResponse, time.Duration, profilingMetricStr, error) {
 	req := sdkms.DecryptRequest{
-		Key:    sdkms.SobjectByID(keyID),
+		Key:    sdkms.SobjectByID(kid),
 		Alg:    someAlgorithm(sdkms.AlgorithmAes),
 		Cipher: c.Cipher,
 		Iv:     c.Iv,
@@ -0,0 +1,65 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/spf13/cobra"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var newConnectionPerTestRequest bool
+var tlsSessionResumption bool
+var sessionResumptionOpt bool
+
+var tlsHandshakeLoadTestCmd = &cobra.Command{
+	Use:     "tls-handshake",
+	Aliases: []string{"handshake", "tls"},
+	Short:   "Load test opening a new TLS connection for each request",
+	Long: "Load test opening a new TLS connection for each request at the target rate. " +
+		"Each request calls the version API on a new connection, the TLS handshakes are reported by " +
+		"TLS version, cipher suite and key exchange and split into full handshakes and resumed sessions.",
+	Run: func(cmd *cobra.Command, args []string) {
+		tlsHandshakeLoadTest()
+	},
+}
+
+func init() {
+	loadTestCmd.AddCommand(tlsHandshakeLoadTestCmd)
+
+	tlsHandshakeLoadTestCmd.Flags().BoolVar(&sessionResumptionOpt, "session-resumption", true, "Keep a TLS session cache per client, i.e. one per worker shared by its connections, so that later handshakes can resume the session (tickets), false makes every handshake a full one")
+}
+
+func tlsHandshakeLoadTest() {
+	newConnectionPerTestRequest = true
+	tlsSessionResumption = sessionResumptionOpt
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
+		if tlsSessionResumption {
+			testConfig.Mode = "session-resumption"
+		} else {
+			testConfig.Mode = "full-handshake"
+		}
+		return nil, nil
+	}
+	cleanup := func(client *sdkms.Client) {}
+	test := func(client *sdkms.Client, stage loadTestStage, arg interface{}) (interface{}, time.Duration, profilingMetricStr, error) {
+		ctx := sdkms.IncludeRawResponse(context.Background())
+
+		t0 := time.Now()
+		_, err := client.Version(ctx, nil)
+		d := time.Since(t0)
+
+		header := sdkms.GetRawResponse(ctx).Header
+		p := profilingMetricStr(header.Get("Profiling-Data"))
+
+		return nil, d, p, err
+	}
+	loadTest("tls-handshake", setup, test, cleanup)
+}
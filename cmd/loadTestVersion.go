@@ -28,7 +28,7 @@ func init() {
 }
 
 func versionLoadTest() {
-	setup := func(client *sdkms.Client, testConfig *TestConfig) (interface{}, error) {
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
 		return nil, nil
 	}
 	cleanup := func(client *sdkms.Client) {}
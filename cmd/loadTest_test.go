@@ -0,0 +1,247 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fortanix/dsm-perf-tool/mockserver"
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withLoadTestFlags sets short load test durations for the duration of the
+// test.
+func withLoadTestFlags(t *testing.T, qps float64, workers uint, duration time.Duration) {
+	savedQPS, savedConnections, savedWarmup, savedDuration := queriesPerSecond, connections, warmupDuration, testDuration
+	savedSession, savedStrategy := createSession, apiKeyStrategy
+	queriesPerSecond, connections, warmupDuration, testDuration = qps, workers, 10*time.Millisecond, duration
+	t.Cleanup(func() {
+		queriesPerSecond, connections, warmupDuration, testDuration = savedQPS, savedConnections, savedWarmup, savedDuration
+		createSession, apiKeyStrategy = savedSession, savedStrategy
+	})
+}
+
+// encryptTestFunc encrypts with kid, the arg of setup is the authorization it
+// was made for, so that reusing it after another setup is detected.
+func encryptTestFunc(kid string, staleArgs *int32) testFunc {
+	return func(client *sdkms.Client, stage loadTestStage, arg interface{}) (interface{}, time.Duration, profilingMetricStr, error) {
+		if arg != client.Auth {
+			atomic.AddInt32(staleArgs, 1)
+		}
+		t0 := time.Now()
+		_, err := client.Encrypt(context.Background(), sdkms.EncryptRequest{Key: sdkms.SobjectByID(kid), Alg: sdkms.AlgorithmAes, Plain: []byte(SYM_EXAMPLE_DATA)})
+		return arg, time.Since(t0), "", err
+	}
+}
+
+func TestLoadTestReauthentication(t *testing.T) {
+	withLoadTestFlags(t, 100, 2, 600*time.Millisecond)
+	createSession = true
+	mock := mockserver.New(mockserver.Config{})
+	kid := mock.CreateKey("aes", sdkms.ObjectTypeAes, 256)
+	var terminated int32
+	startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sys/v1/session/terminate" {
+			atomic.AddInt32(&terminated, 1)
+		}
+		mock.ServeHTTP(w, r)
+	}))
+
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
+		err := authenticateApp(client, cred, true)
+		return client.Auth, err
+	}
+	cleanup := func(client *sdkms.Client) {
+		client.TerminateSession(context.Background())
+	}
+	var staleArgs int32
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		mock.ExpireSessions()
+	}()
+	creds := []appCredential{{Name: "app", APIKey: "YXBwOnNlY3JldA=="}}
+	summary := runLoadTest("reauthentication", setup, encryptTestFunc(kid, &staleArgs), cleanup, creds)
+
+	require.NotNil(t, summary.Result.Reauthentication)
+	assert.Equal(t, uint(2), summary.Result.Reauthentication.QueryNumber, "each worker re-authenticates once")
+	assert.Empty(t, summary.Result.Errors)
+	assert.Greater(t, summary.Result.Test.QueryNumber, uint(40))
+	assert.Zero(t, atomic.LoadInt32(&staleArgs), "the arg of the new setup is used")
+	// the expired sessions when re-authenticating, then the new ones at the end
+	assert.Equal(t, int32(4), atomic.LoadInt32(&terminated))
+}
+
+func TestLoadTestRejectedAPIKey(t *testing.T) {
+	withLoadTestFlags(t, 100, 2, 600*time.Millisecond)
+	createSession = false
+	mock := mockserver.New(mockserver.Config{})
+	kid := mock.CreateKey("aes", sdkms.ObjectTypeAes, 256)
+	var revoked, encrypts, auths int32
+	startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sys/v1/session/auth":
+			atomic.AddInt32(&auths, 1)
+		case "/crypto/v1/encrypt":
+			atomic.AddInt32(&encrypts, 1)
+			if atomic.LoadInt32(&revoked) != 0 {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("invalid API key"))
+				return
+			}
+		}
+		mock.ServeHTTP(w, r)
+	}))
+
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
+		err := authenticateApp(client, cred, false)
+		return client.Auth, err
+	}
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		atomic.StoreInt32(&revoked, 1)
+	}()
+	var staleArgs int32
+	creds := []appCredential{{Name: "app", APIKey: "YXBwOnNlY3JldA=="}}
+	summary := runLoadTest("rejected API key", setup, encryptTestFunc(kid, &staleArgs), func(*sdkms.Client) {}, creds)
+
+	assert.Nil(t, summary.Result.Reauthentication, "no session to restore")
+	assert.Zero(t, atomic.LoadInt32(&auths))
+	failed := summary.Result.Errors["HTTP 401"]
+	assert.Greater(t, failed, uint(10))
+	// the warmup request of each worker, then one request per token
+	assert.Equal(t, int32(2+summary.Result.Test.QueryNumber+failed), atomic.LoadInt32(&encrypts), "no retries")
+}
+
+func TestLoadTestAppStrategies(t *testing.T) {
+	mock := mockserver.New(mockserver.Config{})
+	kid := mock.CreateKey("aes", sdkms.ObjectTypeAes, 256)
+	startTestServer(t, mock)
+	creds, err := parseAPIKeys(strings.NewReader("YXBwLTE6c2VjcmV0\nYXBwLTI6c2VjcmV0\nYXBwLTM6c2VjcmV0\n"))
+	require.NoError(t, err)
+
+	// the arg of setup is the app the session was created for
+	var sessions sync.Map
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
+		err := authenticateApp(client, cred, true)
+		sessions.Store(client.Auth, cred.Name)
+		return cred.Name, err
+	}
+	var wrongApp int32
+	test := func(client *sdkms.Client, stage loadTestStage, arg interface{}) (interface{}, time.Duration, profilingMetricStr, error) {
+		if app, _ := sessions.Load(client.Auth); app != arg {
+			atomic.AddInt32(&wrongApp, 1)
+		}
+		return encryptTestFunc(kid, new(int32))(client, stage, arg)
+	}
+	cleanup := func(client *sdkms.Client) {}
+
+	for _, tc := range []struct {
+		strategy credentialStrategy
+		workers  uint
+		apps     []string
+	}{
+		{credentialStrategyRoundRobin, 2, []string{"app-1", "app-2"}},
+		{credentialStrategyRandom, 2, []string{"app-1", "app-2", "app-3"}},
+	} {
+		t.Run(string(tc.strategy), func(t *testing.T) {
+			withLoadTestFlags(t, 200, tc.workers, 300*time.Millisecond)
+			createSession, apiKeyStrategy = true, tc.strategy
+			atomic.StoreInt32(&wrongApp, 0)
+
+			summary := runLoadTest("apps", setup, test, cleanup, creds)
+			assert.Zero(t, atomic.LoadInt32(&wrongApp), "the setup result of the app is used")
+			assert.Equal(t, uint(len(tc.apps)), summary.Config.Apps, "the apps used")
+			var apps []string
+			var total uint
+			for app, st := range summary.Result.PerApp {
+				apps = append(apps, app)
+				total += st.QueryNumber
+			}
+			sort.Strings(apps)
+			assert.Equal(t, tc.apps, apps)
+			assert.Equal(t, summary.Result.Test.QueryNumber, total)
+		})
+	}
+}
+
+func TestLoadTestFailedAppSetup(t *testing.T) {
+	withLoadTestFlags(t, 200, 2, 300*time.Millisecond)
+	createSession, apiKeyStrategy = true, credentialStrategyRandom
+	mock := mockserver.New(mockserver.Config{})
+	kid := mock.CreateKey("aes", sdkms.ObjectTypeAes, 256)
+	startTestServer(t, mock)
+	creds, err := parseAPIKeys(strings.NewReader("YXBwLTE6c2VjcmV0\nYXBwLTI6c2VjcmV0\nYXBwLTM6c2VjcmV0\n"))
+	require.NoError(t, err)
+
+	// app-3 is only set up on the first token that picks it, and fails
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
+		if cred.Name == "app-3" {
+			return nil, fmt.Errorf("app-3 is disabled")
+		}
+		err := authenticateApp(client, cred, true)
+		return client.Auth, err
+	}
+	var staleArgs int32
+	summary := runLoadTest("apps", setup, encryptTestFunc(kid, &staleArgs), func(*sdkms.Client) {}, creds)
+
+	failed := summary.Result.Errors["other"]
+	assert.Greater(t, failed, uint(0), "the failed setups are errors")
+	assert.NotContains(t, summary.Result.PerApp, "app-3")
+	require.NotNil(t, summary.Result.Test)
+	assert.InDelta(t, 60, summary.Result.Test.QueryNumber+failed, 10, "every token is accounted for")
+}
+
+func TestLoadTestOpenLoopConcurrency(t *testing.T) {
+	withLoadTestFlags(t, 200, 1, 500*time.Millisecond)
+	defer func(o bool, m, tc uint, v httpVersionOpt) {
+		openLoop, maxInFlight, tcpConnections, httpVersion = o, m, tc, v
+	}(openLoop, maxInFlight, tcpConnections, httpVersion)
+	openLoop, maxInFlight, tcpConnections, httpVersion = true, 4, 0, httpVersion11
+	mock := mockserver.New(mockserver.Config{})
+	kid := mock.CreateKey("aes", sdkms.ObjectTypeAes, 256)
+	var mutex sync.Mutex
+	var inFlight, maxSeen int
+	startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/crypto/v1/encrypt" {
+			mutex.Lock()
+			inFlight++
+			if inFlight > maxSeen {
+				maxSeen = inFlight
+			}
+			mutex.Unlock()
+			time.Sleep(50 * time.Millisecond)
+			mutex.Lock()
+			inFlight--
+			mutex.Unlock()
+		}
+		mock.ServeHTTP(w, r)
+	}))
+
+	setup := func(client *sdkms.Client, cred *appCredential, testConfig *TestConfig) (interface{}, error) {
+		err := authenticateApp(client, cred, false)
+		return client.Auth, err
+	}
+	var staleArgs int32
+	creds := []appCredential{{Name: "app", APIKey: "YXBwOnNlY3JldA=="}}
+	summary := runLoadTest("open loop", setup, encryptTestFunc(kid, &staleArgs), func(*sdkms.Client) {}, creds)
+
+	assert.Empty(t, summary.Result.Errors)
+	assert.Equal(t, uint(4), summary.Config.MaxInFlight)
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, 4, maxSeen, "--max-in-flight requests reach the server at once over --connections 1")
+}
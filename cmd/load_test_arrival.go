@@ -0,0 +1,250 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var arrival = arrivalUniform
+var arrivalSeed int64
+var burstSize uint
+var burstInterval time.Duration
+var arrivalTraceFile string
+var arrivalTraceIntervals []time.Duration // Set by load-test replay instead of reading --arrival-trace
+var traceSpeed = 1.0
+
+type arrivalDistribution string
+
+const (
+	arrivalUniform arrivalDistribution = "uniform"
+	arrivalPoisson arrivalDistribution = "poisson"
+	arrivalBurst   arrivalDistribution = "burst"
+	arrivalTrace   arrivalDistribution = "trace"
+)
+
+// impl pflag.Value interface for arrivalDistribution
+
+func (a *arrivalDistribution) String() string {
+	return string(*a)
+}
+
+func (a *arrivalDistribution) Set(v string) error {
+	switch v {
+	case "uniform", "constant":
+		*a = arrivalUniform
+	case "poisson", "exponential":
+		*a = arrivalPoisson
+	case "burst":
+		*a = arrivalBurst
+	case "trace":
+		*a = arrivalTrace
+	default:
+		return fmt.Errorf("invalid arrival distribution: %v", v)
+	}
+	return nil
+}
+
+func (a *arrivalDistribution) Type() string {
+	return "ArrivalDistribution"
+}
+
+// ArrivalConfig is the arrival process of the tokens, to reproduce a test.
+type ArrivalConfig struct {
+	Distribution  string        `json:"distribution" yaml:"distribution"`
+	Seed          int64         `json:"seed,omitempty" yaml:"seed,omitempty"` // Seed of the poisson distribution
+	BurstSize     uint          `json:"burst_size,omitempty" yaml:"burst_size,omitempty"`
+	BurstInterval time.Duration `json:"burst_interval,omitempty" yaml:"burst_interval,omitempty"`
+	Trace         string        `json:"trace,omitempty" yaml:"trace,omitempty"` // File with the inter-arrival times
+	Speed         float64       `json:"speed,omitempty" yaml:"speed,omitempty"` // Replay speed of the trace
+}
+
+func (ac *ArrivalConfig) String() string {
+	switch ac.Distribution {
+	case string(arrivalPoisson):
+		return fmt.Sprintf("%s (seed %d)", ac.Distribution, ac.Seed)
+	case string(arrivalBurst):
+		return fmt.Sprintf("%s (%d every %v)", ac.Distribution, ac.BurstSize, ac.BurstInterval)
+	case string(arrivalTrace):
+		if ac.Speed != 0 && ac.Speed != 1 {
+			return fmt.Sprintf("%s (%s at %gx)", ac.Distribution, ac.Trace, ac.Speed)
+		}
+		return fmt.Sprintf("%s (%s)", ac.Distribution, ac.Trace)
+	default:
+		return ac.Distribution
+	}
+}
+
+// arrivalProcess generates the times between tokens.
+type arrivalProcess interface {
+	next() time.Duration
+	rate() float64 // Mean number of tokens per second
+}
+
+type uniformArrivals struct {
+	interval time.Duration
+}
+
+func (u *uniformArrivals) next() time.Duration {
+	return u.interval
+}
+
+func (u *uniformArrivals) rate() float64 {
+	return float64(time.Second) / float64(u.interval)
+}
+
+// poissonArrivals has exponentially distributed inter-arrival times.
+type poissonArrivals struct {
+	qps float64
+	rng *rand.Rand
+}
+
+func (p *poissonArrivals) next() time.Duration {
+	return time.Duration(p.rng.ExpFloat64() / p.qps * float64(time.Second))
+}
+
+func (p *poissonArrivals) rate() float64 {
+	return p.qps
+}
+
+// burstArrivals sends size tokens at once every interval.
+type burstArrivals struct {
+	size     uint
+	interval time.Duration
+	sent     uint
+}
+
+func (b *burstArrivals) next() time.Duration {
+	b.sent++
+	if (b.sent-1)%b.size == 0 {
+		return b.interval
+	}
+	return 0
+}
+
+func (b *burstArrivals) rate() float64 {
+	return float64(b.size) / b.interval.Seconds()
+}
+
+// traceArrivals replays recorded inter-arrival times, from the start again
+// once all were used.
+type traceArrivals struct {
+	intervals []time.Duration
+	i         int
+}
+
+func (t *traceArrivals) next() time.Duration {
+	d := t.intervals[t.i]
+	t.i = (t.i + 1) % len(t.intervals)
+	return d
+}
+
+func (t *traceArrivals) rate() float64 {
+	var total time.Duration
+	for _, d := range t.intervals {
+		total += d
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(len(t.intervals)) / total.Seconds()
+}
+
+// newArrivalProcess returns the arrival process selected by the flags. A
+// poisson process without --seed gets a time based seed, which is recorded
+// in the returned config.
+func newArrivalProcess() (arrivalProcess, *ArrivalConfig, error) {
+	config := &ArrivalConfig{Distribution: string(arrival)}
+	switch arrival {
+	case arrivalPoisson:
+		if queriesPerSecond <= 0 {
+			return nil, nil, fmt.Errorf("--qps must be positive")
+		}
+		config.Seed = arrivalSeed
+		if config.Seed == 0 {
+			config.Seed = time.Now().UnixNano()
+		}
+		return &poissonArrivals{qps: queriesPerSecond, rng: rand.New(rand.NewSource(config.Seed))}, config, nil
+	case arrivalBurst:
+		if burstSize == 0 || burstInterval <= 0 {
+			return nil, nil, fmt.Errorf("--burst-size and --burst-interval must be positive")
+		}
+		config.BurstSize = burstSize
+		config.BurstInterval = burstInterval
+		return &burstArrivals{size: burstSize, interval: burstInterval}, config, nil
+	case arrivalTrace:
+		if traceSpeed <= 0 {
+			return nil, nil, fmt.Errorf("--speed must be positive")
+		}
+		intervals := arrivalTraceIntervals
+		if intervals == nil {
+			file, err := os.Open(arrivalTraceFile)
+			if err != nil {
+				return nil, nil, err
+			}
+			defer file.Close()
+			if intervals, err = readInterArrivalTimes(file); err != nil {
+				return nil, nil, fmt.Errorf("%v: %v", arrivalTraceFile, err)
+			}
+		}
+		config.Trace = arrivalTraceFile
+		config.Speed = traceSpeed
+		scaled := make([]time.Duration, len(intervals))
+		for i, d := range intervals {
+			scaled[i] = time.Duration(float64(d) / traceSpeed)
+		}
+		return &traceArrivals{intervals: scaled}, config, nil
+	default:
+		if queriesPerSecond <= 0 {
+			return nil, nil, fmt.Errorf("--qps must be positive")
+		}
+		return &uniformArrivals{interval: time.Duration(float64(time.Second.Nanoseconds()) / queriesPerSecond)}, config, nil
+	}
+}
+
+// readInterArrivalTimes reads one inter-arrival time per line, as a duration
+// like 1.5ms or a number of seconds. Empty lines and lines starting with #
+// are skipped.
+func readInterArrivalTimes(r io.Reader) ([]time.Duration, error) {
+	var intervals []time.Duration
+	var total time.Duration
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			seconds, err := strconv.ParseFloat(text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid inter-arrival time: %v", line, text)
+			}
+			d = time.Duration(seconds * float64(time.Second))
+		}
+		if d < 0 {
+			return nil, fmt.Errorf("line %d: negative inter-arrival time: %v", line, text)
+		}
+		intervals = append(intervals, d)
+		total += d
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no inter-arrival times")
+	}
+	return intervals, nil
+}
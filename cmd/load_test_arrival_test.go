@@ -0,0 +1,84 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArrivalDistributionSet(t *testing.T) {
+	var a arrivalDistribution
+	require.NoError(t, a.Set("exponential"))
+	assert.Equal(t, arrivalPoisson, a)
+	require.NoError(t, a.Set("constant"))
+	assert.Equal(t, arrivalUniform, a)
+	assert.Error(t, a.Set("gamma"))
+}
+
+func TestBurstArrivals(t *testing.T) {
+	b := &burstArrivals{size: 3, interval: time.Second}
+	var got []time.Duration
+	for i := 0; i < 6; i++ {
+		got = append(got, b.next())
+	}
+	assert.Equal(t, []time.Duration{time.Second, 0, 0, time.Second, 0, 0}, got)
+	assert.Equal(t, 3.0, b.rate())
+}
+
+func TestPoissonArrivals(t *testing.T) {
+	defer func(a arrivalDistribution, s int64, q float64) {
+		arrival, arrivalSeed, queriesPerSecond = a, s, q
+	}(arrival, arrivalSeed, queriesPerSecond)
+	arrival, arrivalSeed, queriesPerSecond = arrivalPoisson, 42, 100
+
+	first, config, err := newArrivalProcess()
+	require.NoError(t, err)
+	assert.Equal(t, &ArrivalConfig{Distribution: "poisson", Seed: 42}, config)
+	second, _, err := newArrivalProcess()
+	require.NoError(t, err)
+
+	const n = 10000
+	var total time.Duration
+	for i := 0; i < n; i++ {
+		d := first.next()
+		require.Equal(t, d, second.next(), "same seed, same sequence")
+		total += d
+	}
+	mean := total.Seconds() / n
+	assert.InDelta(t, 0.01, mean, 0.001)
+	assert.Equal(t, 100.0, first.rate())
+
+	arrivalSeed = 0
+	_, config, err = newArrivalProcess()
+	require.NoError(t, err)
+	assert.NotZero(t, config.Seed, "a time based seed is recorded")
+}
+
+func TestReadInterArrivalTimes(t *testing.T) {
+	intervals, err := readInterArrivalTimes(strings.NewReader("# recorded\n10ms\n\n0.03\n0\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{10 * time.Millisecond, 30 * time.Millisecond, 0}, intervals)
+
+	ta := &traceArrivals{intervals: intervals}
+	assert.True(t, math.Abs(ta.rate()-75) < 1e-9)
+	for _, want := range append(intervals, intervals[0]) {
+		assert.Equal(t, want, ta.next())
+	}
+
+	_, err = readInterArrivalTimes(strings.NewReader("10ms\nsoon\n"))
+	assert.EqualError(t, err, "line 2: invalid inter-arrival time: soon")
+	_, err = readInterArrivalTimes(strings.NewReader("-1ms\n"))
+	assert.Error(t, err)
+	_, err = readInterArrivalTimes(strings.NewReader("# empty\n"))
+	assert.Error(t, err)
+}
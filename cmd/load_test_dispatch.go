@@ -0,0 +1,130 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var openLoop bool
+var maxInFlight uint
+var lateTokenThreshold time.Duration
+
+// validateDispatchOptions rejects --max-in-flight without --open-loop, in
+// closed loop mode --connections is the number of workers. In open loop mode
+// an HTTP/1.1 connection carries one request at a time, so fewer
+// --tcp-connections than --max-in-flight would queue the requests unseen.
+func validateDispatchOptions() error {
+	if maxInFlight > 0 && !openLoop {
+		return fmt.Errorf("--max-in-flight requires --open-loop, use --connections to set the number of workers in closed loop mode")
+	}
+	if openLoop && maxInFlight > 0 && tcpConnections > 0 && tcpConnections < maxInFlight && httpVersion != httpVersion2 {
+		return fmt.Errorf("--tcp-connections %v would limit the %v requests in flight with HTTP/1.1, use --http-version 2 to share connections", tcpConnections, maxInFlight)
+	}
+	return nil
+}
+
+// loadTestWorkers returns the number of workers, each sends one request at a
+// time. In open loop mode --max-in-flight sets it. With HTTP/2 the workers
+// share the --connections TCP connections unless --tcp-connections is given,
+// with HTTP/1.1 a connection carries one request at a time, so each worker
+// has its own connection and --max-in-flight requests are really in flight.
+// Every worker still runs the setup of the test, so each one authenticates
+// and holds its own session with --create-session.
+func loadTestWorkers() (workers uint, tcp uint) {
+	if openLoop && maxInFlight > 0 {
+		if tcpConnections > 0 {
+			return maxInFlight, tcpConnections
+		}
+		if httpVersion == httpVersion2 {
+			return maxInFlight, connections
+		}
+		return maxInFlight, 0
+	}
+	return connections, tcpConnections
+}
+
+// DispatchStatistics compares the tokens of the test with the target rate.
+type DispatchStatistics struct {
+	OpenLoop    bool       `json:"open_loop" yaml:"open_loop"`
+	MaxInFlight uint       `json:"max_in_flight" yaml:"max_in_flight"` // Number of workers
+	TargetQPS   float64    `json:"target_qps" yaml:"target_qps"`
+	SendRate    float64    `json:"send_rate" yaml:"send_rate"` // Tokens dispatched to a worker per second
+	Sent        uint       `json:"sent" yaml:"sent"`
+	Dropped     uint       `json:"dropped" yaml:"dropped"` // Tokens dropped in open loop mode as all workers were busy
+	Late        uint       `json:"late" yaml:"late"`       // Tokens dispatched more than --late-threshold after their schedule
+	Lateness    *Statistic `json:"lateness,omitempty" yaml:"lateness,omitempty"`
+}
+
+func (ds *DispatchStatistics) Print(w io.Writer) {
+	mode := "closed loop"
+	if ds.OpenLoop {
+		mode = "open loop"
+	}
+	fmt.Fprintf(w, "%s, max in flight: %d, target: %v QPS, sent: %.3f/s (%d), dropped: %d, late: %d\n",
+		mode, ds.MaxInFlight, ds.TargetQPS, ds.SendRate, ds.Sent, ds.Dropped, ds.Late)
+	if ds.Lateness != nil {
+		fmt.Fprintf(w, "Lateness:           %s\n", ds.Lateness.String())
+	}
+}
+
+// dispatchCounters records what happened to the tokens of a test.
+type dispatchCounters struct {
+	mutex    sync.Mutex
+	dropped  uint
+	late     uint
+	lateness []time.Duration
+}
+
+func (dc *dispatchCounters) drop() {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	dc.dropped++
+}
+
+// dispatched records that a worker took the token scheduled at tick.
+func (dc *dispatchCounters) dispatched(tick time.Time) {
+	late := time.Since(tick)
+	if late < 0 {
+		late = 0
+	}
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	dc.lateness = append(dc.lateness, late)
+	if late > lateTokenThreshold {
+		dc.late++
+	}
+}
+
+// counts returns the number of dropped and late tokens so far.
+func (dc *dispatchCounters) counts() (dropped uint, late uint) {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	return dc.dropped, dc.late
+}
+
+func (dc *dispatchCounters) statistics(workers uint, targetQPS float64, duration time.Duration) *DispatchStatistics {
+	dc.mutex.Lock()
+	defer dc.mutex.Unlock()
+	ds := &DispatchStatistics{
+		OpenLoop:    openLoop,
+		MaxInFlight: workers,
+		TargetQPS:   targetQPS,
+		Sent:        uint(len(dc.lateness)),
+		Dropped:     dc.dropped,
+		Late:        dc.late,
+		Lateness:    StatisticFromDurations(dc.lateness, duration),
+	}
+	if duration > 0 {
+		ds.SendRate = float64(ds.Sent) / duration.Seconds()
+	}
+	return ds
+}
@@ -0,0 +1,74 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTestWorkers(t *testing.T) {
+	defer func(o bool, m, c, tc uint, v httpVersionOpt) {
+		openLoop, maxInFlight, connections, tcpConnections, httpVersion = o, m, c, tc, v
+	}(openLoop, maxInFlight, connections, tcpConnections, httpVersion)
+
+	openLoop, maxInFlight, connections, tcpConnections, httpVersion = false, 50, 10, 0, httpVersion11
+	workers, tcp := loadTestWorkers()
+	assert.Equal(t, []uint{10, 0}, []uint{workers, tcp})
+
+	openLoop = true
+	workers, tcp = loadTestWorkers()
+	assert.Equal(t, []uint{50, 0}, []uint{workers, tcp}, "one connection per worker with HTTP/1.1")
+
+	httpVersion = httpVersion2
+	workers, tcp = loadTestWorkers()
+	assert.Equal(t, []uint{50, 10}, []uint{workers, tcp})
+
+	tcpConnections = 2
+	workers, tcp = loadTestWorkers()
+	assert.Equal(t, []uint{50, 2}, []uint{workers, tcp})
+}
+
+func TestDispatchStatistics(t *testing.T) {
+	defer func(l time.Duration) { lateTokenThreshold = l }(lateTokenThreshold)
+	lateTokenThreshold = 10 * time.Millisecond
+
+	var dc dispatchCounters
+	now := time.Now()
+	dc.dispatched(now.Add(time.Second))
+	dc.dispatched(now)
+	dc.dispatched(now.Add(-time.Second))
+	dc.drop()
+
+	ds := dc.statistics(2, 4, 2*time.Second)
+	assert.Equal(t, uint(3), ds.Sent)
+	assert.Equal(t, uint(1), ds.Dropped)
+	assert.Equal(t, uint(1), ds.Late)
+	assert.Equal(t, 1.5, ds.SendRate)
+	assert.Equal(t, float64(4), ds.TargetQPS)
+	assert.Equal(t, uint(2), ds.MaxInFlight)
+	assert.Equal(t, float64(0), ds.Lateness.Min)
+}
+
+func TestValidateDispatchOptions(t *testing.T) {
+	defer func(o bool, m, tc uint, v httpVersionOpt) {
+		openLoop, maxInFlight, tcpConnections, httpVersion = o, m, tc, v
+	}(openLoop, maxInFlight, tcpConnections, httpVersion)
+
+	openLoop, maxInFlight, tcpConnections, httpVersion = false, 0, 0, httpVersion11
+	assert.NoError(t, validateDispatchOptions())
+	maxInFlight = 50
+	assert.EqualError(t, validateDispatchOptions(), "--max-in-flight requires --open-loop, use --connections to set the number of workers in closed loop mode")
+	openLoop = true
+	assert.NoError(t, validateDispatchOptions())
+	tcpConnections = 10
+	assert.EqualError(t, validateDispatchOptions(), "--tcp-connections 10 would limit the 50 requests in flight with HTTP/1.1, use --http-version 2 to share connections")
+	httpVersion = httpVersion2
+	assert.NoError(t, validateDispatchOptions())
+}
@@ -0,0 +1,178 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var thresholdSpecs []string
+
+// thresholdOperators are checked in order, so <= is found before <.
+var thresholdOperators = []string{"<=", ">=", "<", ">"}
+
+// Threshold is a condition the result of a load test must meet, e.g.
+// p99<=20ms or qps>=950.
+type Threshold struct {
+	Metric   string  `json:"metric" yaml:"metric"`     // qps, errors, error_rate or a latency metric
+	Operator string  `json:"operator" yaml:"operator"` // <, <=, > or >=
+	Value    float64 `json:"value" yaml:"value"`       // Latencies are in nanoseconds
+}
+
+// ThresholdCheck is the outcome of evaluating a threshold against a result.
+type ThresholdCheck struct {
+	Threshold Threshold `json:"threshold" yaml:"threshold"`
+	Measured  *float64  `json:"measured" yaml:"measured"` // nil if the result has no value for the metric
+	Passed    bool      `json:"passed" yaml:"passed"`
+}
+
+func parseThresholds(specs []string) ([]Threshold, error) {
+	var thresholds []Threshold
+	for _, spec := range specs {
+		threshold, err := parseThreshold(spec)
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds, nil
+}
+
+func parseThreshold(spec string) (Threshold, error) {
+	for _, op := range thresholdOperators {
+		metric, value, found := strings.Cut(spec, op)
+		if !found {
+			continue
+		}
+		t := Threshold{Metric: strings.TrimSpace(metric), Operator: op}
+		value = strings.TrimSpace(value)
+		var err error
+		switch {
+		case isLatencyMetric(t.Metric):
+			var d time.Duration
+			d, err = time.ParseDuration(value)
+			t.Value = float64(d)
+		case t.Metric == "qps" || t.Metric == "errors" || t.Metric == "error_rate":
+			t.Value, err = strconv.ParseFloat(value, 64)
+		default:
+			return t, fmt.Errorf("unknown threshold metric %q, supported: qps, errors, error_rate, %v", t.Metric, strings.Join(latencyMetrics, ", "))
+		}
+		if err != nil {
+			return t, fmt.Errorf("invalid threshold value in %q: %v", spec, err)
+		}
+		return t, nil
+	}
+	return Threshold{}, fmt.Errorf("invalid threshold %q, expected <metric><operator><value>, e.g. p99<=20ms", spec)
+}
+
+func (t Threshold) String() string {
+	return fmt.Sprintf("%s %s %s", t.Metric, t.Operator, t.format(t.Value))
+}
+
+func (t Threshold) format(v float64) string {
+	switch {
+	case isLatencyMetric(t.Metric):
+		return fmt.Sprintf("%.3fms", v/1e6)
+	case t.Metric == "error_rate":
+		return strconv.FormatFloat(v, 'g', 4, 64)
+	default:
+		return formatNumber(v)
+	}
+}
+
+// measure returns the value of the threshold metric in the result, or false
+// if the result has none.
+func (t Threshold) measure(result *TestResult) (float64, bool) {
+	var errorCount uint
+	for _, n := range result.Errors {
+		errorCount += n
+	}
+	switch {
+	case t.Metric == "errors":
+		return float64(errorCount), true
+	case t.Metric == "error_rate":
+		total := errorCount
+		if result.Test != nil {
+			total += result.Test.QueryNumber
+		}
+		if total == 0 {
+			return 0, false
+		}
+		return float64(errorCount) / float64(total), true
+	case result.Test == nil:
+		return 0, false
+	case t.Metric == "qps":
+		if result.Test.QPS == nil {
+			return 0, false
+		}
+		return *result.Test.QPS, true
+	default:
+		return latencyMetric(result.Test, t.Metric), true
+	}
+}
+
+func (t Threshold) check(result *TestResult) ThresholdCheck {
+	c := ThresholdCheck{Threshold: t}
+	v, ok := t.measure(result)
+	if !ok {
+		return c
+	}
+	c.Measured = &v
+	switch t.Operator {
+	case "<":
+		c.Passed = v < t.Value
+	case "<=":
+		c.Passed = v <= t.Value
+	case ">":
+		c.Passed = v > t.Value
+	case ">=":
+		c.Passed = v >= t.Value
+	}
+	return c
+}
+
+func checkThresholds(thresholds []Threshold, result *TestResult) []ThresholdCheck {
+	var checks []ThresholdCheck
+	for _, t := range thresholds {
+		checks = append(checks, t.check(result))
+	}
+	return checks
+}
+
+// failedChecks returns the number of failed threshold checks of all summaries.
+func failedChecks(summaries []*TestSummary) int {
+	failed := 0
+	for _, summary := range summaries {
+		for _, c := range summary.Result.Checks {
+			if !c.Passed {
+				failed++
+			}
+		}
+	}
+	return failed
+}
+
+// Message describes the measured vs expected value of the check.
+func (c *ThresholdCheck) Message() string {
+	if c.Measured == nil {
+		return fmt.Sprintf("%s not measured, expected %s %s", c.Threshold.Metric, c.Threshold.Operator, c.Threshold.format(c.Threshold.Value))
+	}
+	return fmt.Sprintf("%s = %s, expected %s %s", c.Threshold.Metric, c.Threshold.format(*c.Measured), c.Threshold.Operator, c.Threshold.format(c.Threshold.Value))
+}
+
+func (c *ThresholdCheck) Print(w io.Writer) {
+	outcome := "passed"
+	if !c.Passed {
+		outcome = "FAILED"
+	}
+	fmt.Fprintf(w, "%s: %s (%s)\n", c.Threshold.String(), outcome, c.Message())
+}
@@ -0,0 +1,140 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/montanaflynn/stats"
+)
+
+// Trial-to-trial coefficients of variation up to these are considered stable
+// and moderately stable, above is unstable.
+const stableCV = 0.05
+const moderateCV = 0.10
+
+// aggregatedMetrics are the test metrics aggregated across trials.
+var aggregatedMetrics = []string{"qps", "avg", "p50", "p75", "p90", "p95", "p99"}
+
+// RepeatedTestSummary is the result of running the same load test several
+// times with --repeat.
+type RepeatedTestSummary struct {
+	Trials    []*TestSummary  `json:"trials" yaml:"trials"`
+	Aggregate *TrialAggregate `json:"aggregate" yaml:"aggregate"`
+}
+
+// TrialAggregate summarizes the test statistics of all trials.
+type TrialAggregate struct {
+	Trials    uint                           `json:"trials" yaml:"trials"`
+	Metrics   map[string]*AggregateStatistic `json:"metrics" yaml:"metrics"`     // By metric name: qps, avg, p50, p75, p90, p95, p99
+	MaxCV     float64                        `json:"max_cv" yaml:"max_cv"`       // Largest coefficient of variation of all metrics
+	Stability string                         `json:"stability" yaml:"stability"` // stable, moderate or unstable, based on MaxCV
+}
+
+// AggregateStatistic describes how one metric varies across trials. Latency
+// metrics are in nanoseconds.
+type AggregateStatistic struct {
+	Mean float64 `json:"mean" yaml:"mean"`
+	Min  float64 `json:"min" yaml:"min"`
+	Max  float64 `json:"max" yaml:"max"`
+	Sd   float64 `json:"sd" yaml:"sd"` // Sample standard deviation across trials
+	CV   float64 `json:"cv" yaml:"cv"` // Coefficient of variation, Sd / Mean
+}
+
+func newRepeatedTestSummary(trials []*TestSummary) *RepeatedTestSummary {
+	return &RepeatedTestSummary{Trials: trials, Aggregate: aggregateTrials(trials)}
+}
+
+func aggregateTrials(trials []*TestSummary) *TrialAggregate {
+	agg := &TrialAggregate{
+		Trials:  uint(len(trials)),
+		Metrics: make(map[string]*AggregateStatistic),
+	}
+	for _, metric := range aggregatedMetrics {
+		var data stats.Float64Data
+		for _, trial := range trials {
+			st := trial.Result.Test
+			if st == nil {
+				continue
+			}
+			if metric != "qps" {
+				data = append(data, latencyMetric(st, metric))
+			} else if st.QPS != nil {
+				data = append(data, *st.QPS)
+			}
+		}
+		if len(data) == 0 {
+			continue
+		}
+		as := aggregateFromFloat64Data(data)
+		agg.Metrics[metric] = as
+		if as.CV > agg.MaxCV {
+			agg.MaxCV = as.CV
+		}
+	}
+	switch {
+	case agg.MaxCV <= stableCV:
+		agg.Stability = "stable"
+	case agg.MaxCV <= moderateCV:
+		agg.Stability = "moderate"
+	default:
+		agg.Stability = "unstable"
+	}
+	return agg
+}
+
+func aggregateFromFloat64Data(data stats.Float64Data) *AggregateStatistic {
+	mean, _ := data.Mean()
+	min, _ := data.Min()
+	max, _ := data.Max()
+	var sd float64
+	if data.Len() > 1 {
+		sd, _ = data.StandardDeviationSample()
+	}
+	var cv float64
+	if mean != 0 {
+		cv = sd / mean
+	}
+	return &AggregateStatistic{Mean: mean, Min: min, Max: max, Sd: sd, CV: cv}
+}
+
+func (agg *TrialAggregate) Print(w io.Writer) {
+	fmt.Fprintf(w, "Trials:    %d\n", agg.Trials)
+	for _, metric := range aggregatedMetrics {
+		as, ok := agg.Metrics[metric]
+		if !ok {
+			continue
+		}
+		if metric == "qps" {
+			fmt.Fprintf(w, "%-4s mean: %.3f, min: %.3f, max: %.3f, σ: %.3f, CV: %.2f%%\n", "QPS:", as.Mean, as.Min, as.Max, as.Sd, as.CV*100)
+		} else {
+			fmt.Fprintf(w, "%-4s mean: %.3fms, min: %.3fms, max: %.3fms, σ: %.3fms, CV: %.2f%%\n", metric+":", as.Mean/1e6, as.Min/1e6, as.Max/1e6, as.Sd/1e6, as.CV*100)
+		}
+	}
+	fmt.Fprintf(w, "Stability: %s (max CV %.2f%%)\n", agg.Stability, agg.MaxCV*100)
+}
+
+func (rs *RepeatedTestSummary) WritePlain(w io.Writer) error {
+	for i, trial := range rs.Trials {
+		fmt.Fprintf(w, "===== Trial %d/%d =====\n", i+1, len(rs.Trials))
+		if err := trial.WritePlain(w); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	fmt.Fprintf(w, "----- Aggregate Results -----\n")
+	rs.Aggregate.Print(w)
+	return nil
+}
+
+func (rs *RepeatedTestSummary) WriteJson(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rs)
+}
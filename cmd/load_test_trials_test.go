@@ -0,0 +1,54 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateTrials(t *testing.T) {
+	var trials []*TestSummary
+	for i, qps := range []float64{98, 100, 102} {
+		qps := qps
+		trial := newTestSummary()
+		ms := float64(i + 4) // 4, 5 and 6ms
+		trial.Result.Test = &Statistic{QueryNumber: 1000, QPS: &qps, Avg: 2e6, P50: 2e6, P75: 3e6, P90: 3e6, P95: 4e6, P99: ms * 1e6}
+		trials = append(trials, trial)
+	}
+	agg := newRepeatedTestSummary(trials).Aggregate
+	assert.Equal(t, uint(3), agg.Trials)
+	assert.InDelta(t, 100, agg.Metrics["qps"].Mean, 1e-9)
+	assert.Equal(t, 98.0, agg.Metrics["qps"].Min)
+	assert.Equal(t, 102.0, agg.Metrics["qps"].Max)
+	assert.InDelta(t, 2, agg.Metrics["qps"].Sd, 1e-9)
+	assert.InDelta(t, 0.02, agg.Metrics["qps"].CV, 1e-9)
+	assert.Equal(t, 2e6, agg.Metrics["p50"].Mean)
+	assert.Equal(t, 0.0, agg.Metrics["p50"].Sd)
+	assert.InDelta(t, 5e6, agg.Metrics["p99"].Mean, 1e-3)
+	assert.InDelta(t, 1e6, agg.Metrics["p99"].Sd, 1e-3)
+	assert.InDelta(t, 0.2, agg.Metrics["p99"].CV, 1e-9)
+	assert.InDelta(t, 0.2, agg.MaxCV, 1e-9)
+	assert.Equal(t, "unstable", agg.Stability)
+
+	for i := range trials {
+		trials[i].Result.Test.P99 = 5e6
+	}
+	agg = newRepeatedTestSummary(trials).Aggregate
+	assert.InDelta(t, 0.02, agg.MaxCV, 1e-9)
+	assert.Equal(t, "stable", agg.Stability)
+
+	qps := 93.0
+	trials[0].Result.Test.QPS = &qps
+	trials[2].Result.Test.QPS = nil
+	agg = newRepeatedTestSummary(trials).Aggregate
+	assert.InDelta(t, 96.5, agg.Metrics["qps"].Mean, 1e-9, "trials without QPS are skipped")
+	assert.InDelta(t, 4.9497, agg.Metrics["qps"].Sd, 1e-4)
+	assert.InDelta(t, 0.0513, agg.MaxCV, 1e-4)
+	assert.Equal(t, "moderate", agg.Stability)
+}
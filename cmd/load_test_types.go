@@ -11,6 +11,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"sort"
 	"time"
 
 	"github.com/fortanix/sdkms-client-go/sdkms"
@@ -21,6 +22,8 @@ type TestSummary struct {
 	TestTime string      `json:"test_time" yaml:"test_time"` // ISO 8601 timestamp string
 	Config   *TestConfig `json:"config" yaml:"config"`
 	Result   *TestResult `json:"result" yaml:"result"`
+	// profiling data of each request, saved with --profiling-data-file
+	profiling []profilingRecord
 }
 
 type TestConfig struct {
@@ -29,13 +32,26 @@ type TestConfig struct {
 	ServerPort     uint16           `json:"server_port" yaml:"server_port"`
 	VerifyTls      bool             `json:"verify_tls" yaml:"verify_tls"`
 	Connections    uint             `json:"connections" yaml:"connections"`
+	AuthMethod     string           `json:"auth_method" yaml:"auth_method"`
 	CreateSession  bool             `json:"create_session" yaml:"create_session"`
+	Apps           uint             `json:"apps" yaml:"apps"` // Number of apps used, round-robin uses at most one per worker
+	AppStrategy    string           `json:"app_strategy" yaml:"app_strategy"`
 	WarmupDuration time.Duration    `json:"warmup_duration" yaml:"warmup_duration"`
 	TestDuration   time.Duration    `json:"test_duration" yaml:"test_duration"`
 	TargetQPS      float64          `json:"target_qps" yaml:"target_qps"`
+	Arrival        *ArrivalConfig   `json:"arrival,omitempty" yaml:"arrival,omitempty"`
 	Sobject        *sdkms.Sobject   `json:"sobject" yaml:"sobject"`
+	KeyCount       uint             `json:"key_count,omitempty" yaml:"key_count,omitempty"`
+	KeySelection   string           `json:"key_selection,omitempty" yaml:"key_selection,omitempty"`
+	Mode           string           `json:"mode,omitempty" yaml:"mode,omitempty"`
+	Operations     map[string]uint  `json:"operations,omitempty" yaml:"operations,omitempty"` // Requests of each operation of a replayed trace
 	Plugin         *sdkms.Plugin    `json:"plugin" yaml:"plugin"`
 	PluginInput    *json.RawMessage `json:"plugin_input" yaml:"plugin_input"`
+	TLS            *TLSSettings     `json:"tls,omitempty" yaml:"tls,omitempty"`
+	HTTPVersion    string           `json:"http_version,omitempty" yaml:"http_version,omitempty"`
+	TCPConnections uint             `json:"tcp_connections,omitempty" yaml:"tcp_connections,omitempty"` // TCP connections shared by the workers, 0 if each worker has its own
+	OpenLoop       bool             `json:"open_loop,omitempty" yaml:"open_loop,omitempty"`
+	MaxInFlight    uint             `json:"max_in_flight,omitempty" yaml:"max_in_flight,omitempty"` // Number of workers, each sends one request at a time
 }
 
 func (tc *TestConfig) Print(w io.Writer) {
@@ -43,22 +59,63 @@ func (tc *TestConfig) Print(w io.Writer) {
 	fmt.Fprintf(w, "ServerName:     %s\n", tc.ServerName)
 	fmt.Fprintf(w, "ServerPort:     %d\n", tc.ServerPort)
 	fmt.Fprintf(w, "VerifyTls:      %t\n", tc.VerifyTls)
+	if tc.TLS != nil {
+		tc.TLS.Print(w)
+	}
 	fmt.Fprintf(w, "Connections:    %d\n", tc.Connections)
+	if tc.TCPConnections > 0 {
+		fmt.Fprintf(w, "TCPConnections: %d\n", tc.TCPConnections)
+	}
+	if tc.OpenLoop {
+		fmt.Fprintf(w, "OpenLoop:       max %d in flight\n", tc.MaxInFlight)
+	}
+	if tc.HTTPVersion != "" {
+		fmt.Fprintf(w, "HTTPVersion:    %s\n", tc.HTTPVersion)
+	}
+	fmt.Fprintf(w, "AuthMethod:     %s\n", tc.AuthMethod)
 	fmt.Fprintf(w, "CreateSession:  %t\n", tc.CreateSession)
+	fmt.Fprintf(w, "Apps:           %d\n", tc.Apps)
+	fmt.Fprintf(w, "AppStrategy:    %s\n", tc.AppStrategy)
 	fmt.Fprintf(w, "WarmupDuration: %s\n", tc.WarmupDuration)
 	fmt.Fprintf(w, "TestDuration:   %s\n", tc.TestDuration)
 	fmt.Fprintf(w, "TargetQPS:      %v\n", tc.TargetQPS)
+	if tc.Arrival != nil && tc.Arrival.Distribution != string(arrivalUniform) {
+		fmt.Fprintf(w, "Arrival:        %s\n", tc.Arrival)
+	}
 	fmt.Fprintf(w, "Sobject:        %s\n", toJsonStr(tc.Sobject))
+	if tc.KeyCount > 1 {
+		fmt.Fprintf(w, "KeyCount:       %d\n", tc.KeyCount)
+		fmt.Fprintf(w, "KeySelection:   %s\n", tc.KeySelection)
+	}
+	if tc.Mode != "" {
+		fmt.Fprintf(w, "Mode:           %s\n", tc.Mode)
+	}
+	if len(tc.Operations) != 0 {
+		fmt.Fprintf(w, "Operations:     %s\n", formatOperationCounts(tc.Operations))
+	}
 	fmt.Fprintf(w, "Plugin:         %s\n", toJsonStr(tc.Plugin))
 	fmt.Fprintf(w, "PluginInput:    %s\n", toJsonStr(tc.PluginInput))
 }
 
 type TestResult struct {
-	Warmup             *Statistic           `json:"warmup" yaml:"warmup"`
-	Test               *Statistic           `json:"test" yaml:"test"`
-	ActualTestDuration time.Duration        `json:"actual_test_duration" yaml:"actual_test_duration"`
-	SendDuration       time.Duration        `json:"send_duration" yaml:"send_duration"`
-	ProfilingResults   *ProfilingStatistics `json:"profiling_results" yaml:"profiling_results"`
+	Warmup             *Statistic              `json:"warmup" yaml:"warmup"`
+	Test               *Statistic              `json:"test" yaml:"test"`
+	ActualTestDuration time.Duration           `json:"actual_test_duration" yaml:"actual_test_duration"`
+	SendDuration       time.Duration           `json:"send_duration" yaml:"send_duration"`
+	ProfilingResults   *ProfilingStatistics    `json:"profiling_results" yaml:"profiling_results"`
+	Reauthentication   *Statistic              `json:"reauthentication,omitempty" yaml:"reauthentication,omitempty"` // Re-authentications after session expiry, not included in Test
+	PerApp             map[string]*Statistic   `json:"per_app,omitempty" yaml:"per_app,omitempty"`                   // Test statistic of each app when using multiple API keys
+	TestHistogram      *LatencyHistogram       `json:"test_histogram,omitempty" yaml:"test_histogram,omitempty"`     // Histogram of the test latencies
+	TestLatencies      []float64               `json:"test_latencies,omitempty" yaml:"test_latencies,omitempty"`     // Raw test latencies in nanoseconds, only with --store-latencies
+	Errors             map[string]uint         `json:"errors,omitempty" yaml:"errors,omitempty"`                     // Failed test requests by error category, e.g. HTTP 503 or timeout
+	Timeline           []IntervalStatistic     `json:"timeline,omitempty" yaml:"timeline,omitempty"`                 // Test statistic of each --timeline-interval
+	Checks             []ThresholdCheck        `json:"checks,omitempty" yaml:"checks,omitempty"`                     // Outcome of each --threshold
+	HTTPPhases         *HTTPPhaseStatistics    `json:"http_phases,omitempty" yaml:"http_phases,omitempty"`           // Client side HTTP phases of the successful test requests
+	Overhead           *Statistic              `json:"overhead,omitempty" yaml:"overhead,omitempty"`                 // Client latency minus server Total of each test request with profiling data
+	TLSHandshakes      *TLSHandshakeStatistics `json:"tls_handshakes,omitempty" yaml:"tls_handshakes,omitempty"`     // TLS handshakes of the successful test requests that opened a new connection
+	ConnectionStats    *ConnectionStatistics   `json:"connection_stats,omitempty" yaml:"connection_stats,omitempty"` // Client connections during the test
+	Dispatch           *DispatchStatistics     `json:"dispatch,omitempty" yaml:"dispatch,omitempty"`                 // Tokens sent vs the target rate
+	Sources            []MergeSource           `json:"sources,omitempty" yaml:"sources,omitempty"`                   // Results combined into this one, e.g. by the coordinator
 }
 
 func (tr *TestResult) Print(w io.Writer) {
@@ -66,6 +123,57 @@ func (tr *TestResult) Print(w io.Writer) {
 	fmt.Fprintf(w, "Test:               %s\n", tr.Test.String())
 	fmt.Fprintf(w, "ActualTestDuration: %s\n", tr.ActualTestDuration)
 	fmt.Fprintf(w, "SendDuration:       %s\n", tr.ActualTestDuration)
+	if tr.Reauthentication != nil {
+		fmt.Fprintf(w, "Reauthentication:   %s\n", tr.Reauthentication.String())
+	}
+	if tr.Overhead != nil {
+		fmt.Fprintf(w, "Overhead:           %s\n", tr.Overhead.String())
+	}
+	if tr.Dispatch != nil {
+		fmt.Fprintf(w, "Dispatch:           ")
+		tr.Dispatch.Print(w)
+	}
+	if tr.ConnectionStats != nil {
+		fmt.Fprintf(w, "TCP connections:    ")
+		tr.ConnectionStats.Print(w)
+	}
+	if len(tr.PerApp) != 0 {
+		fmt.Fprintf(w, "Per app:\n")
+		apps := make([]string, 0, len(tr.PerApp))
+		for app := range tr.PerApp {
+			apps = append(apps, app)
+		}
+		sort.Strings(apps)
+		for _, app := range apps {
+			fmt.Fprintf(w, "%s: %s\n", app, tr.PerApp[app].String())
+		}
+	}
+	if len(tr.Errors) != 0 {
+		fmt.Fprintf(w, "Errors:\n")
+		for _, category := range sortedKeys(tr.Errors) {
+			fmt.Fprintf(w, "%s: %d\n", category, tr.Errors[category])
+		}
+	}
+	if len(tr.Checks) != 0 {
+		fmt.Fprintf(w, "Threshold checks:\n")
+		for i := range tr.Checks {
+			tr.Checks[i].Print(w)
+		}
+	}
+	if len(tr.Sources) != 0 {
+		fmt.Fprintf(w, "Per source:\n")
+		for i := range tr.Sources {
+			tr.Sources[i].Print(w)
+		}
+	}
+	if tr.TLSHandshakes != nil {
+		fmt.Fprintf(w, "TLS handshakes:\n")
+		tr.TLSHandshakes.Print(w)
+	}
+	if tr.HTTPPhases != nil {
+		fmt.Fprintf(w, "HTTP phases:\n")
+		tr.HTTPPhases.Print(w)
+	}
 	if tr.ProfilingResults != nil {
 		fmt.Fprintf(w, "Profiling data:\n")
 		tr.ProfilingResults.Print(w)
@@ -126,6 +234,74 @@ func StatisticFromFloat64Data(data stats.Float64Data, totalDuration *time.Durati
 	}
 }
 
+// IntervalStatistic is the statistic of the requests started in one interval
+// of the test.
+type IntervalStatistic struct {
+	Offset    time.Duration `json:"offset" yaml:"offset"`                           // Start of the interval since the start of the test
+	Statistic *Statistic    `json:"statistic,omitempty" yaml:"statistic,omitempty"` // nil if no request of the interval succeeded
+	Errors    uint          `json:"errors" yaml:"errors"`                           // Number of failed requests
+	Overhead  *Statistic    `json:"overhead,omitempty" yaml:"overhead,omitempty"`   // Client minus server latency of the requests with profiling data
+}
+
+// timelineFromDurations splits the test of the given duration into intervals
+// and computes the statistic of the requests started in each, times are the
+// start times of the successful requests taking durations and errorTimes those
+// of the failed requests. Requests started after the end of the test are
+// counted in the last interval.
+func timelineFromDurations(start time.Time, duration time.Duration, interval time.Duration, times []time.Time, durations []time.Duration, errorTimes []time.Time) []IntervalStatistic {
+	if interval <= 0 || len(times)+len(errorTimes) == 0 {
+		return nil
+	}
+	intervals := int((duration + interval - 1) / interval)
+	if intervals < 1 {
+		intervals = 1
+	}
+	timeline := make([]IntervalStatistic, intervals)
+	for i := range timeline {
+		timeline[i].Offset = time.Duration(i) * interval
+	}
+	for b, ds := range durationsPerInterval(start, interval, intervals, times, durations) {
+		timeline[b].Statistic = StatisticFromDurations(ds, interval)
+	}
+	for _, t := range errorTimes {
+		timeline[timelineBucket(start, interval, intervals, t)].Errors++
+	}
+	return timeline
+}
+
+// addOverheadTimeline adds the statistic of the overheads of the requests
+// started at times to the intervals of the timeline.
+func addOverheadTimeline(timeline []IntervalStatistic, start time.Time, interval time.Duration, times []time.Time, overheads []time.Duration) {
+	if len(timeline) == 0 {
+		return
+	}
+	for b, ds := range durationsPerInterval(start, interval, len(timeline), times, overheads) {
+		timeline[b].Overhead = StatisticFromDurations(ds, interval)
+	}
+}
+
+func durationsPerInterval(start time.Time, interval time.Duration, intervals int, times []time.Time, durations []time.Duration) map[int][]time.Duration {
+	perInterval := make(map[int][]time.Duration)
+	for i, t := range times {
+		b := timelineBucket(start, interval, intervals, t)
+		perInterval[b] = append(perInterval[b], durations[i])
+	}
+	return perInterval
+}
+
+// timelineBucket returns the interval a request started at t belongs to.
+func timelineBucket(start time.Time, interval time.Duration, intervals int, t time.Time) int {
+	b := int(t.Sub(start) / interval)
+	switch {
+	case b < 0:
+		return 0
+	case b >= intervals:
+		return intervals - 1
+	default:
+		return b
+	}
+}
+
 func (st *Statistic) Print(w io.Writer) {
 	fmt.Fprintf(w, "ct: %d, ", st.QueryNumber)
 	if st.QPS != nil {
@@ -161,21 +337,28 @@ type ProfilingStatistics struct {
 }
 
 func (ps *ProfilingStatistics) Print(w io.Writer) {
-	maxKeyLen := len("SessionLookup")
-	for key := range ps.Additional {
-		maxKeyLen = Max(maxKeyLen, len(key))
-	}
+	tree := profilingTree(ps.Additional)
+	maxKeyLen := Max(len("SessionLookup"), profilingTreeLabelLen(tree, 0))
 	pad := "LEFT"
-	fmt.Fprintf(w, "%s: %s\n", StrPad("InQueue", maxKeyLen, " ", pad), ps.InQueue.String())
-	fmt.Fprintf(w, "%s: %s\n", StrPad("ParseRequest", maxKeyLen, " ", pad), ps.ParseRequest.String())
-	fmt.Fprintf(w, "%s: %s\n", StrPad("SessionLookup", maxKeyLen, " ", pad), ps.SessionLookup.String())
-	fmt.Fprintf(w, "%s: %s\n", StrPad("ValidateInput", maxKeyLen, " ", pad), ps.ValidateInput.String())
-	fmt.Fprintf(w, "%s: %s\n", StrPad("CheckAccess", maxKeyLen, " ", pad), ps.CheckAccess.String())
-	fmt.Fprintf(w, "%s: %s\n", StrPad("Operate", maxKeyLen, " ", pad), ps.Operate.String())
-	fmt.Fprintf(w, "%s: %s\n", StrPad("DbFlush", maxKeyLen, " ", pad), ps.DbFlush.String())
+	stages := []struct {
+		name string
+		stat *Statistic
+	}{
+		{"InQueue", &ps.InQueue},
+		{"ParseRequest", &ps.ParseRequest},
+		{"SessionLookup", &ps.SessionLookup},
+		{"ValidateInput", &ps.ValidateInput},
+		{"CheckAccess", &ps.CheckAccess},
+		{"Operate", &ps.Operate},
+		{"DbFlush", &ps.DbFlush},
+	}
+	for _, stage := range stages {
+		fmt.Fprintf(w, "%s: %s%s\n", StrPad(stage.name, maxKeyLen, " ", pad), stage.stat.String(), shareOfTotal(statisticSum(stage.stat), &ps.Total))
+	}
 	fmt.Fprintf(w, "%s: %s\n", StrPad("Total", maxKeyLen, " ", pad), ps.Total.String())
-	for key, value := range ps.Additional {
-		fmt.Fprintf(w, "%s: %s\n", StrPad(key, maxKeyLen, " ", pad), value.String())
+	if len(tree) != 0 {
+		fmt.Fprintf(w, "Additional:\n")
+		printProfilingTree(w, tree, 0, maxKeyLen, &ps.Total)
 	}
 }
 
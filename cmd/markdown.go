@@ -0,0 +1,194 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+type TestSummaryMarkdownWriter interface {
+	WriteMarkdown(w io.Writer) error
+}
+
+var markdownEscaper = strings.NewReplacer("|", `\|`, "\n", " ")
+
+// writeMarkdownTable writes a GitHub flavored markdown table, cells are
+// escaped.
+func writeMarkdownTable(w io.Writer, header []string, rows [][]string) {
+	writeRow := func(cells []string) {
+		escaped := make([]string, len(cells))
+		for i, cell := range cells {
+			escaped[i] = markdownEscaper.Replace(cell)
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(escaped, " | "))
+	}
+	writeRow(header)
+	separators := make([]string, len(header))
+	for i := range separators {
+		separators[i] = "---"
+	}
+	fmt.Fprintf(w, "|%s|\n", strings.Join(separators, "|"))
+	for _, row := range rows {
+		writeRow(row)
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+var markdownStatisticHeader = []string{"", "Count", "QPS", "Avg (ms)", "Min (ms)", "Max (ms)", "p50 (ms)", "p75 (ms)", "p90 (ms)", "p95 (ms)", "p99 (ms)", "σ (ms)"}
+
+func markdownStatisticRow(name string, st *Statistic) []string {
+	if st == nil {
+		return []string{name, "0", "", "", "", "", "", "", "", "", "", ""}
+	}
+	qps := ""
+	if st.QPS != nil {
+		qps = fmt.Sprintf("%.3f", *st.QPS)
+	}
+	row := []string{name, fmt.Sprint(st.QueryNumber), qps}
+	for _, metric := range []string{"avg", "min", "max", "p50", "p75", "p90", "p95", "p99", "sd"} {
+		row = append(row, fmt.Sprintf("%.3f", latencyMetric(st, metric)/1e6))
+	}
+	return row
+}
+
+func (ts *TestSummary) writeMarkdown(w io.Writer, title string) {
+	fmt.Fprintf(w, "### %s\n\n", markdownEscaper.Replace(title))
+	tc := ts.Config
+	config := [][]string{
+		{"Test time", ts.TestTime},
+		{"Server", fmt.Sprintf("%v:%v", tc.ServerName, tc.ServerPort)},
+		{"Verify TLS", fmt.Sprint(tc.VerifyTls)},
+		{"Connections", fmt.Sprint(tc.Connections)},
+		{"Target QPS", fmt.Sprint(tc.TargetQPS)},
+		{"Warmup duration", tc.WarmupDuration.String()},
+		{"Test duration", tc.TestDuration.String()},
+		{"Auth method", tc.AuthMethod},
+		{"Create session", fmt.Sprint(tc.CreateSession)},
+		{"Key type", describeKeyType(tc.Sobject)},
+	}
+	if tc.Apps > 1 {
+		config = append(config, []string{"Apps", fmt.Sprintf("%d (%s)", tc.Apps, tc.AppStrategy)})
+	}
+	if tc.KeyCount > 1 {
+		config = append(config, []string{"Keys", fmt.Sprintf("%d (%s)", tc.KeyCount, tc.KeySelection)})
+	}
+	if tc.Mode != "" {
+		config = append(config, []string{"Mode", tc.Mode})
+	}
+	if tc.TLS != nil && tc.TLS.Negotiated != nil {
+		n := tc.TLS.Negotiated
+		config = append(config, []string{"TLS", fmt.Sprintf("%s %s %s", n.Version, n.CipherSuite, n.KeyExchange)})
+	}
+	writeMarkdownTable(w, []string{"Setting", "Value"}, config)
+
+	tr := ts.Result
+	rows := [][]string{markdownStatisticRow("Warmup", tr.Warmup), markdownStatisticRow("Test", tr.Test)}
+	if tr.Reauthentication != nil {
+		rows = append(rows, markdownStatisticRow("Reauthentication", tr.Reauthentication))
+	}
+	if tr.Overhead != nil {
+		rows = append(rows, markdownStatisticRow("Overhead", tr.Overhead))
+	}
+	for _, app := range sortedKeys(tr.PerApp) {
+		rows = append(rows, markdownStatisticRow("App "+app, tr.PerApp[app]))
+	}
+	writeMarkdownTable(w, markdownStatisticHeader, rows)
+	fmt.Fprintf(w, "Actual test duration: %s, send duration: %s\n\n", tr.ActualTestDuration, tr.SendDuration)
+
+	if len(tr.Errors) != 0 {
+		var errorRows [][]string
+		for _, category := range sortedKeys(tr.Errors) {
+			errorRows = append(errorRows, []string{category, fmt.Sprint(tr.Errors[category])})
+		}
+		writeMarkdownTable(w, []string{"Error", "Count"}, errorRows)
+	}
+	if len(tr.Checks) != 0 {
+		var checkRows [][]string
+		for i := range tr.Checks {
+			c := &tr.Checks[i]
+			outcome := "passed"
+			if !c.Passed {
+				outcome = "**failed**"
+			}
+			checkRows = append(checkRows, []string{c.Threshold.String(), outcome, c.Message()})
+		}
+		writeMarkdownTable(w, []string{"Threshold", "Outcome", "Details"}, checkRows)
+	}
+	if ps := tr.ProfilingResults; ps != nil {
+		stages := profilingStages(ps)
+		var additional []string
+		for stage := range ps.Additional {
+			additional = append(additional, stage)
+		}
+		sort.Strings(additional)
+		var profilingRows [][]string
+		for _, stage := range append(append([]string(nil), fixedProfilingStages...), additional...) {
+			profilingRows = append(profilingRows, markdownStatisticRow(stage, stages[stage]))
+		}
+		header := append([]string{"Profiling stage"}, markdownStatisticHeader[1:]...)
+		writeMarkdownTable(w, header, profilingRows)
+	}
+}
+
+func (ts *TestSummary) WriteMarkdown(w io.Writer) error {
+	ts.writeMarkdown(w, testDisplayName(ts.Config))
+	return nil
+}
+
+func (rs *RepeatedTestSummary) WriteMarkdown(w io.Writer) error {
+	for i, trial := range rs.Trials {
+		trial.writeMarkdown(w, fmt.Sprintf("Trial %d/%d: %s", i+1, len(rs.Trials), testDisplayName(trial.Config)))
+	}
+	fmt.Fprintf(w, "### Aggregate of %d trials\n\n", rs.Aggregate.Trials)
+	var rows [][]string
+	for _, metric := range aggregatedMetrics {
+		as, ok := rs.Aggregate.Metrics[metric]
+		if !ok {
+			continue
+		}
+		if metric == "qps" {
+			rows = append(rows, []string{"QPS", fmt.Sprintf("%.3f", as.Mean), fmt.Sprintf("%.3f", as.Min), fmt.Sprintf("%.3f", as.Max), fmt.Sprintf("%.3f", as.Sd), fmt.Sprintf("%.2f%%", as.CV*100)})
+		} else {
+			rows = append(rows, []string{metric + " (ms)", fmt.Sprintf("%.3f", as.Mean/1e6), fmt.Sprintf("%.3f", as.Min/1e6), fmt.Sprintf("%.3f", as.Max/1e6), fmt.Sprintf("%.3f", as.Sd/1e6), fmt.Sprintf("%.2f%%", as.CV*100)})
+		}
+	}
+	writeMarkdownTable(w, []string{"Metric", "Mean", "Min", "Max", "σ", "CV"}, rows)
+	fmt.Fprintf(w, "Stability: **%s** (max CV %.2f%%)\n", rs.Aggregate.Stability, rs.Aggregate.MaxCV*100)
+	return nil
+}
+
+func (c *Comparison) WriteMarkdown(w io.Writer) error {
+	fmt.Fprintf(w, "### Comparison of %s with %s\n\n", markdownEscaper.Replace(c.Candidate), markdownEscaper.Replace(c.Baseline))
+	for _, m := range c.Mismatches {
+		fmt.Fprintf(w, "- Config mismatch: %s\n", markdownEscaper.Replace(m))
+	}
+	for _, warning := range c.Warnings {
+		fmt.Fprintf(w, "- Warning: %s\n", markdownEscaper.Replace(warning))
+	}
+	if len(c.Mismatches)+len(c.Warnings) != 0 {
+		fmt.Fprintf(w, "\n")
+	}
+	var rows [][]string
+	for _, d := range c.Deltas {
+		percent := "n/a"
+		if d.DeltaPercent != nil {
+			percent = fmt.Sprintf("%+.2f%%", *d.DeltaPercent)
+		}
+		verdict := d.Verdict
+		if verdict == verdictRegression {
+			verdict = "**" + verdict + "**"
+		}
+		rows = append(rows, []string{d.Metric, formatMetricValue(d.Baseline, d.Unit, false), formatMetricValue(d.Candidate, d.Unit, false),
+			formatMetricValue(d.Delta, d.Unit, true), percent, verdict, d.Significance})
+	}
+	writeMarkdownTable(w, []string{"Metric", "Baseline", "Candidate", "Delta", "Delta%", "Verdict", "Significance"}, rows)
+	fmt.Fprintf(w, "Regressions: **%d**\n", c.Regressions)
+	return nil
+}
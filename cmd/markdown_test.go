@@ -0,0 +1,39 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteMarkdownTable(t *testing.T) {
+	var buf bytes.Buffer
+	writeMarkdownTable(&buf, []string{"A", "B"}, [][]string{{"x|y", "1"}})
+	assert.Equal(t, "| A | B |\n|---|---|\n| x\\|y | 1 |\n\n", buf.String())
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	summary := newTestSummary()
+	summary.Result.Errors = map[string]uint{"HTTP 503": 2}
+	var buf bytes.Buffer
+	require.NoError(t, newRepeatedTestSummary([]*TestSummary{summary, summary}).WriteMarkdown(&buf))
+	md := buf.String()
+	assert.Contains(t, md, "### Trial 2/2: Test1\n")
+	assert.Contains(t, md, "| HTTP 503 | 2 |")
+	assert.Contains(t, md, "| total | ")
+	assert.Contains(t, md, "### Aggregate of 2 trials")
+	for _, line := range strings.Split(md, "\n") {
+		if strings.HasPrefix(line, "| Test |") {
+			assert.Equal(t, len(markdownStatisticHeader)+1, strings.Count(line, "|"))
+		}
+	}
+}
@@ -0,0 +1,117 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// MERGE_SKEW_WARNING is the start skew of a source above which the sources
+// are reported as not running at the same time, TestTime has a resolution of
+// one second.
+const MERGE_SKEW_WARNING = 2 * time.Second
+
+// TODO: get rid of global variables, tracking issue: #16
+var mergeClockOffsets map[string]string
+var mergeForce bool
+
+var mergeCmd = &cobra.Command{
+	Use:   "merge result.json...",
+	Short: "Merge load test results of several machines",
+	Long: `Merge the results of the same load test run at the same time on several
+machines, written with --output-format json, into a single summary written in
+--output-format.
+
+The QPS of the results are added. The latency percentiles are computed from
+all raw latencies if every result has them (--store-latencies), or else from
+the merged latency histograms, which are precise to 0.8%. The average,
+minimum, maximum and standard deviation are exact. Results of repeated tests
+are merged trial by trial. Results of different test names, target QPS,
+connections or key types are refused unless --force is given.
+
+The statistics of the profiling stages, HTTP phases, TLS handshakes, apps,
+re-authentications and overhead are merged with the count weighted means of
+the percentiles of the results, and the thresholds of the results are checked
+again against the merged result.
+
+The statistic of each result is kept under sources, with the clock offset
+assumed for it: the clocks of the machines are assumed to be synchronized
+unless --clock-offset gives the offset of a result, e.g. measured with NTP.
+The start times of the results corrected by their offsets must be close,
+otherwise the results did not overlap and their QPS should not be added.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		mergeResults(args)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mergeCmd)
+
+	mergeCmd.Flags().StringToStringVar(&mergeClockOffsets, "clock-offset", nil, "Clock of the machine of a result minus the reference clock, e.g. result2.json=-150ms, may be repeated")
+	mergeCmd.Flags().BoolVar(&mergeForce, "force", false, "Merge results even if their test configurations differ")
+}
+
+func mergeResults(paths []string) {
+	offsets := make(map[string]time.Duration)
+	for path, value := range mergeClockOffsets {
+		offset, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Invalid clock offset of %v: %v\n", path, err)
+		}
+		offsets[path] = offset
+	}
+	var sources []MergeSource
+	var results [][]*TestSummary
+	for _, path := range paths {
+		trials, err := readTestSummaries(path)
+		if err != nil {
+			log.Fatalf("Fatal error: %v\n", err)
+		}
+		source := MergeSource{Name: path, ClockOffsetSource: clockOffsetAssumed}
+		if offset, ok := offsets[path]; ok {
+			source.ClockOffset = offset
+			source.ClockOffsetSource = clockOffsetGiven
+			delete(offsets, path)
+		}
+		sources = append(sources, source)
+		results = append(results, trials)
+	}
+	for path := range offsets {
+		log.Fatalf("Clock offset given for %v, which is not merged\n", path)
+	}
+	merged, err := mergeTrials(sources, results, mergeForce)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	for _, summary := range merged {
+		for _, warning := range startSkewWarnings(summary.Result) {
+			log.Printf("Warning: %v\n", warning)
+		}
+	}
+	if len(merged) == 1 {
+		writeTestSummary(merged[0])
+	} else {
+		writeTestSummary(newRepeatedTestSummary(merged))
+	}
+}
+
+// startSkewWarnings lists the sources that started too late after the first
+// one to have run at the same time.
+func startSkewWarnings(result *TestResult) []string {
+	var warnings []string
+	for _, source := range result.Sources {
+		if source.StartSkew > MERGE_SKEW_WARNING {
+			warnings = append(warnings, fmt.Sprintf("%v started %v after the first result, the results may not overlap", source.Name, source.StartSkew))
+		}
+	}
+	return warnings
+}
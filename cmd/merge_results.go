@@ -0,0 +1,394 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How the clock offset of a merged source is known.
+const (
+	clockOffsetEstimated = "estimated" // by the coordinator from the round trip of a ping
+	clockOffsetGiven     = "given"     // with --clock-offset
+	clockOffsetAssumed   = "assumed"   // clocks are assumed to be synchronized
+)
+
+// MergeSource is one of the results combined into a merged result, e.g. an
+// agent of the coordinator.
+type MergeSource struct {
+	Name              string          `json:"name" yaml:"name"`
+	TestTime          string          `json:"test_time" yaml:"test_time"`
+	ClockOffset       time.Duration   `json:"clock_offset" yaml:"clock_offset"`               // Clock of the source minus the reference clock
+	ClockOffsetSource string          `json:"clock_offset_source" yaml:"clock_offset_source"` // estimated, given or assumed
+	StartSkew         time.Duration   `json:"start_skew" yaml:"start_skew"`                   // Start of the source after the first one in the reference clock, to the second of TestTime
+	Test              *Statistic      `json:"test" yaml:"test"`
+	Errors            map[string]uint `json:"errors,omitempty" yaml:"errors,omitempty"`
+}
+
+func (ms *MergeSource) Print(w io.Writer) {
+	fmt.Fprintf(w, "%s (clock offset %v %s, start skew %v): ", ms.Name, ms.ClockOffset, ms.ClockOffsetSource, ms.StartSkew)
+	if ms.Test == nil {
+		fmt.Fprintf(w, "no successful requests\n")
+		return
+	}
+	fmt.Fprintf(w, "%s\n", ms.Test.String())
+}
+
+// mergeTrials merges the results of the sources trial by trial, results has
+// the trials of each source. Results of different test configurations are
+// only merged with force.
+func mergeTrials(sources []MergeSource, results [][]*TestSummary, force bool) ([]*TestSummary, error) {
+	if len(results) == 0 {
+		return nil, fmt.Errorf("no results to merge")
+	}
+	for i := range results {
+		if len(results[i]) != len(results[0]) {
+			return nil, fmt.Errorf("%v has %d trials instead of %d", sources[i].Name, len(results[i]), len(results[0]))
+		}
+	}
+	var merged []*TestSummary
+	for trial := range results[0] {
+		summaries := make([]*TestSummary, len(results))
+		for i := range results {
+			summaries[i] = results[i][trial]
+		}
+		summary, err := mergeTestSummaries(append([]MergeSource(nil), sources...), summaries, force)
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, summary)
+	}
+	return merged, nil
+}
+
+// mergeTestSummaries combines the results of the same test run by several
+// sources at the same time. The QPS of the sources are added, and the
+// latency percentiles are computed from all raw latencies if every source
+// has them, or else from the merged histograms within their precision. The
+// other statistics, e.g. of the profiling stages, have the count weighted
+// means of the percentiles of the sources, and the thresholds of the sources
+// are checked again against the merged result. The timelines are not kept.
+func mergeTestSummaries(sources []MergeSource, summaries []*TestSummary, force bool) (*TestSummary, error) {
+	if len(summaries) == 0 {
+		return nil, fmt.Errorf("no results to merge")
+	}
+	first := summaries[0]
+	config := *first.Config
+	result := &TestResult{}
+	var tests, warmups, reauths, overheads []*Statistic
+	var profiling []*ProfilingStatistics
+	var phases []*HTTPPhaseStatistics
+	var handshakes []*TLSHandshakeStatistics
+	perApp := make(map[string][]*Statistic)
+	var thresholds []Threshold
+	checked := make(map[string]bool)
+	var latencies []float64
+	raw := true
+	testTime := first.TestTime
+	for i, s := range summaries {
+		if mismatches := configMismatches(first.Config, s.Config); len(mismatches) != 0 {
+			if !force {
+				return nil, fmt.Errorf("cannot merge results of different test configurations, %v vs %v: %v", sources[0].Name, sources[i].Name, strings.Join(mismatches, ", "))
+			}
+			log.Printf("Warning: merging results of different test configurations, %v vs %v: %v\n", sources[0].Name, sources[i].Name, strings.Join(mismatches, ", "))
+		}
+		r := s.Result
+		h := r.TestHistogram
+		if h == nil && len(r.TestLatencies) != 0 {
+			h = HistogramFromFloat64Data(r.TestLatencies)
+		}
+		if r.Test != nil && r.Test.QueryNumber != 0 {
+			if h == nil {
+				return nil, fmt.Errorf("result of %v has neither a latency histogram nor raw latencies", sources[i].Name)
+			}
+			raw = raw && len(r.TestLatencies) != 0
+			latencies = append(latencies, r.TestLatencies...)
+		}
+		if s.TestTime < testTime {
+			testTime = s.TestTime
+		}
+		if i > 0 {
+			config.Connections += s.Config.Connections
+			config.TargetQPS += s.Config.TargetQPS
+			config.TCPConnections += s.Config.TCPConnections
+			config.MaxInFlight += s.Config.MaxInFlight
+		}
+		tests = append(tests, r.Test)
+		warmups = append(warmups, r.Warmup)
+		reauths = append(reauths, r.Reauthentication)
+		overheads = append(overheads, r.Overhead)
+		profiling = append(profiling, r.ProfilingResults)
+		phases = append(phases, r.HTTPPhases)
+		handshakes = append(handshakes, r.TLSHandshakes)
+		for app, st := range r.PerApp {
+			perApp[app] = append(perApp[app], st)
+		}
+		for _, c := range r.Checks {
+			if !checked[c.Threshold.String()] {
+				checked[c.Threshold.String()] = true
+				thresholds = append(thresholds, c.Threshold)
+			}
+		}
+		if result.TestHistogram == nil && h != nil {
+			result.TestHistogram = &LatencyHistogram{}
+		}
+		result.TestHistogram.Merge(h)
+		if r.ActualTestDuration > result.ActualTestDuration {
+			result.ActualTestDuration = r.ActualTestDuration
+		}
+		if r.SendDuration > result.SendDuration {
+			result.SendDuration = r.SendDuration
+		}
+		for category, count := range r.Errors {
+			if result.Errors == nil {
+				result.Errors = make(map[string]uint)
+			}
+			result.Errors[category] += count
+		}
+		result.Dispatch = mergeDispatchStatistics(result.Dispatch, r.Dispatch)
+		result.ConnectionStats = mergeConnectionStatistics(result.ConnectionStats, r.ConnectionStats)
+
+		sources[i].TestTime = s.TestTime
+		sources[i].Test = r.Test
+		sources[i].Errors = r.Errors
+	}
+	result.Test = mergeStatistics(tests, result.TestHistogram)
+	if raw && len(latencies) != 0 && result.Test != nil {
+		exact := StatisticFromFloat64Data(latencies, nil)
+		exact.QPS = result.Test.QPS
+		result.Test = exact
+		result.TestLatencies = latencies
+	}
+	result.Warmup = mergeStatistics(warmups, nil)
+	result.Reauthentication = mergeStatistics(reauths, nil)
+	result.Overhead = mergeStatistics(overheads, nil)
+	result.ProfilingResults = mergeProfilingStatistics(profiling)
+	result.HTTPPhases = mergeHTTPPhaseStatistics(phases)
+	result.TLSHandshakes = mergeTLSHandshakeStatistics(handshakes)
+	for app, statistics := range perApp {
+		if st := mergeStatistics(statistics, nil); st != nil {
+			if result.PerApp == nil {
+				result.PerApp = make(map[string]*Statistic)
+			}
+			result.PerApp[app] = st
+		}
+	}
+	result.Checks = checkThresholds(thresholds, result)
+	setStartSkews(sources)
+	result.Sources = sources
+	return &TestSummary{TestTime: testTime, Config: &config, Result: result}, nil
+}
+
+// mergeStatistics combines the statistics of the sources: the counts and QPS
+// are added, the average, minimum, maximum and standard deviation are exact.
+// The percentiles come from the histogram of all latencies, without one they
+// are the count weighted means of the percentiles of the sources.
+func mergeStatistics(statistics []*Statistic, h *LatencyHistogram) *Statistic {
+	merged := &Statistic{Min: math.Inf(1)}
+	var sum, sumOfSquares float64
+	var percentiles [5]float64
+	for _, st := range statistics {
+		if st == nil || st.QueryNumber == 0 {
+			continue
+		}
+		n := float64(st.QueryNumber)
+		merged.QueryNumber += st.QueryNumber
+		if st.QPS != nil {
+			qps := *st.QPS
+			if merged.QPS != nil {
+				qps += *merged.QPS
+			}
+			merged.QPS = &qps
+		}
+		merged.Min = math.Min(merged.Min, st.Min)
+		merged.Max = math.Max(merged.Max, st.Max)
+		sum += n * st.Avg
+		// population standard deviations: sd² = mean of squares - mean²
+		sumOfSquares += n * (st.Sd*st.Sd + st.Avg*st.Avg)
+		for i, p := range []float64{st.P50, st.P75, st.P90, st.P95, st.P99} {
+			percentiles[i] += n * p
+		}
+	}
+	if merged.QueryNumber == 0 {
+		return nil
+	}
+	n := float64(merged.QueryNumber)
+	merged.Avg = sum / n
+	merged.Sd = math.Sqrt(math.Max(0, sumOfSquares/n-merged.Avg*merged.Avg))
+	if h != nil && h.Count() != 0 {
+		merged.P50, merged.P75, merged.P90, merged.P95, merged.P99 = h.Percentile(50), h.Percentile(75), h.Percentile(90), h.Percentile(95), h.Percentile(99)
+	} else {
+		merged.P50, merged.P75, merged.P90, merged.P95, merged.P99 = percentiles[0]/n, percentiles[1]/n, percentiles[2]/n, percentiles[3]/n, percentiles[4]/n
+	}
+	return merged
+}
+
+// mergeProfilingStatistics merges the statistic of each profiling stage.
+func mergeProfilingStatistics(profiling []*ProfilingStatistics) *ProfilingStatistics {
+	stages := make(map[string][]*Statistic)
+	for _, ps := range profiling {
+		if ps == nil {
+			continue
+		}
+		for stage, st := range profilingStages(ps) {
+			stages[stage] = append(stages[stage], st)
+		}
+	}
+	if len(stages) == 0 {
+		return nil
+	}
+	merged := &ProfilingStatistics{Additional: make(map[string]Statistic)}
+	fixed := profilingStages(merged)
+	for stage, statistics := range stages {
+		st := mergeStatistics(statistics, nil)
+		if st == nil {
+			continue
+		}
+		if isFixedProfilingStage(stage) {
+			*fixed[stage] = *st
+		} else {
+			merged.Additional[stage] = *st
+		}
+	}
+	return merged
+}
+
+func mergeHTTPPhaseStatistics(phases []*HTTPPhaseStatistics) *HTTPPhaseStatistics {
+	var merged *HTTPPhaseStatistics
+	var dns, connect, tls, ttfb, bodyRead []*Statistic
+	for _, hps := range phases {
+		if hps == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &HTTPPhaseStatistics{}
+		}
+		dns = append(dns, hps.DNS)
+		connect = append(connect, hps.Connect)
+		tls = append(tls, hps.TLS)
+		ttfb = append(ttfb, hps.TTFB)
+		bodyRead = append(bodyRead, hps.BodyRead)
+		merged.ReusedConnections += hps.ReusedConnections
+	}
+	if merged == nil {
+		return nil
+	}
+	merged.DNS = mergeStatistics(dns, nil)
+	merged.Connect = mergeStatistics(connect, nil)
+	merged.TLS = mergeStatistics(tls, nil)
+	merged.TTFB = mergeStatistics(ttfb, nil)
+	merged.BodyRead = mergeStatistics(bodyRead, nil)
+	return merged
+}
+
+// mergeTLSHandshakeStatistics merges the handshakes of the sources, those
+// that negotiated the same parameters are merged into one group.
+func mergeTLSHandshakeStatistics(handshakes []*TLSHandshakeStatistics) *TLSHandshakeStatistics {
+	var merged *TLSHandshakeStatistics
+	var full, resumed []*Statistic
+	groups := make(map[TLSHandshakeGroup][]*Statistic)
+	for _, ths := range handshakes {
+		if ths == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &TLSHandshakeStatistics{}
+		}
+		full = append(full, ths.Full)
+		resumed = append(resumed, ths.Resumed)
+		for _, group := range ths.ByParameters {
+			st := group.Statistic
+			group.Statistic = nil
+			groups[group] = append(groups[group], st)
+		}
+	}
+	if merged == nil {
+		return nil
+	}
+	merged.Full = mergeStatistics(full, nil)
+	merged.Resumed = mergeStatistics(resumed, nil)
+	var fullCount, resumedCount uint
+	if merged.Full != nil {
+		fullCount = merged.Full.QueryNumber
+	}
+	if merged.Resumed != nil {
+		resumedCount = merged.Resumed.QueryNumber
+	}
+	if fullCount+resumedCount > 0 {
+		merged.ResumptionRate = float64(resumedCount) / float64(fullCount+resumedCount)
+	}
+	for group, statistics := range groups {
+		group.Statistic = mergeStatistics(statistics, nil)
+		merged.ByParameters = append(merged.ByParameters, group)
+	}
+	sort.Slice(merged.ByParameters, func(i, j int) bool {
+		return merged.ByParameters[i].label() < merged.ByParameters[j].label()
+	})
+	return merged
+}
+
+// mergeDispatchStatistics adds the tokens of ds to merged, the lateness of
+// the tokens is not kept.
+func mergeDispatchStatistics(merged *DispatchStatistics, ds *DispatchStatistics) *DispatchStatistics {
+	if ds == nil {
+		return merged
+	}
+	if merged == nil {
+		merged = &DispatchStatistics{OpenLoop: ds.OpenLoop}
+	}
+	merged.MaxInFlight += ds.MaxInFlight
+	merged.TargetQPS += ds.TargetQPS
+	merged.SendRate += ds.SendRate
+	merged.Sent += ds.Sent
+	merged.Dropped += ds.Dropped
+	merged.Late += ds.Late
+	return merged
+}
+
+func mergeConnectionStatistics(merged *ConnectionStatistics, cs *ConnectionStatistics) *ConnectionStatistics {
+	if cs == nil {
+		return merged
+	}
+	if merged == nil {
+		merged = &ConnectionStatistics{}
+	}
+	merged.Dials += cs.Dials
+	merged.Reused += cs.Reused
+	merged.IdleEvicted += cs.IdleEvicted
+	merged.ClosedByServer += cs.ClosedByServer
+	merged.ClosedInUse += cs.ClosedInUse
+	merged.Open += cs.Open
+	return merged
+}
+
+// setStartSkews computes when each source started after the first one, in
+// the reference clock. Sources with an unknown TestTime are skipped.
+func setStartSkews(sources []MergeSource) {
+	var first time.Time
+	starts := make([]time.Time, len(sources))
+	for i := range sources {
+		t, err := time.Parse(time.RFC3339, sources[i].TestTime)
+		if err != nil {
+			continue
+		}
+		starts[i] = t.Add(-sources[i].ClockOffset)
+		if first.IsZero() || starts[i].Before(first) {
+			first = starts[i]
+		}
+	}
+	for i := range sources {
+		if !starts[i].IsZero() {
+			sources[i].StartSkew = starts[i].Sub(first)
+		}
+	}
+}
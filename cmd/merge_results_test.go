@@ -0,0 +1,200 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/montanaflynn/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func summaryOfLatencies(name string, latencies stats.Float64Data, duration time.Duration) *TestSummary {
+	return &TestSummary{
+		TestTime: "2026-10-18T10:00:00Z",
+		Config:   &TestConfig{TestName: name, Connections: 2, TargetQPS: 100},
+		Result: &TestResult{
+			Warmup:             StatisticFromFloat64Data(latencies[:2], &duration),
+			Test:               StatisticFromFloat64Data(latencies, &duration),
+			ActualTestDuration: duration,
+			TestHistogram:      HistogramFromFloat64Data(latencies),
+			Errors:             map[string]uint{"HTTP 503": 1},
+			ConnectionStats:    &ConnectionStatistics{Dials: 2, Reused: 10},
+		},
+	}
+}
+
+func TestMergeTestSummaries(t *testing.T) {
+	var fast, slow stats.Float64Data
+	for i := 0; i < 6000; i++ {
+		fast = append(fast, 1e6+rand.ExpFloat64()*1e5)
+	}
+	for i := 0; i < 4000; i++ {
+		slow = append(slow, 5e6+rand.ExpFloat64()*1e6)
+	}
+	sources := []MergeSource{{Name: "a", ClockOffset: time.Millisecond}, {Name: "b"}}
+	merged, err := mergeTestSummaries(sources, []*TestSummary{
+		summaryOfLatencies("test", fast, 10*time.Second),
+		summaryOfLatencies("test", slow, 20*time.Second),
+	}, false)
+	require.NoError(t, err)
+
+	all := append(append(stats.Float64Data(nil), fast...), slow...)
+	exact := StatisticFromFloat64Data(all, nil)
+	test := merged.Result.Test
+	assert.Equal(t, uint(10000), test.QueryNumber)
+	assert.InDelta(t, 600+200, *test.QPS, 1e-9, "QPS of the sources are added")
+	assert.InDelta(t, exact.Avg, test.Avg, 1)
+	assert.InDelta(t, exact.Sd, test.Sd, 1)
+	assert.Equal(t, exact.Min, test.Min)
+	assert.Equal(t, exact.Max, test.Max)
+	for _, p := range [][2]float64{{exact.P50, test.P50}, {exact.P90, test.P90}, {exact.P99, test.P99}} {
+		assert.InEpsilon(t, p[0], p[1], 0.01)
+	}
+	// the mean of the medians would be far off
+	assert.Less(t, test.P50, (fast[0]+slow[0])/2)
+
+	assert.Equal(t, uint(4), merged.Config.Connections)
+	assert.Equal(t, 200.0, merged.Config.TargetQPS)
+	assert.Equal(t, 20*time.Second, merged.Result.ActualTestDuration)
+	assert.Equal(t, map[string]uint{"HTTP 503": 2}, merged.Result.Errors)
+	assert.Equal(t, &ConnectionStatistics{Dials: 4, Reused: 20}, merged.Result.ConnectionStats)
+	assert.Equal(t, uint64(10000), merged.Result.TestHistogram.Count())
+	require.Len(t, merged.Result.Sources, 2)
+	assert.Equal(t, time.Millisecond, merged.Result.Sources[0].ClockOffset)
+	assert.Equal(t, uint(4000), merged.Result.Sources[1].Test.QueryNumber)
+	assert.Equal(t, uint(4), merged.Result.Warmup.QueryNumber)
+
+	other := summaryOfLatencies("other", slow, time.Second)
+	other.Config.TargetQPS = 200
+	_, err = mergeTestSummaries(sources, []*TestSummary{summaryOfLatencies("test", fast, time.Second), other}, false)
+	assert.EqualError(t, err, `cannot merge results of different test configurations, a vs b: test name: "test" vs "other", target QPS: 100 vs 200`)
+	merged, err = mergeTestSummaries(sources, []*TestSummary{summaryOfLatencies("test", fast, time.Second), other}, true)
+	require.NoError(t, err)
+	assert.Equal(t, "test", merged.Config.TestName)
+	assert.Equal(t, 300.0, merged.Config.TargetQPS)
+}
+
+func TestMergeDetailedStatistics(t *testing.T) {
+	duration := time.Second
+	a := summaryOfLatencies("test", stats.Float64Data{1e6, 2e6, 3e6}, duration)
+	b := summaryOfLatencies("test", stats.Float64Data{5e6, 6e6}, duration)
+	stat := func(latencies ...float64) *Statistic {
+		return StatisticFromFloat64Data(latencies, &duration)
+	}
+	threshold, err := parseThreshold("p99<=4ms")
+	require.NoError(t, err)
+	for _, s := range []*TestSummary{a, b} {
+		s.Result.Checks = checkThresholds([]Threshold{threshold}, s.Result)
+	}
+	a.Result.ProfilingResults = &ProfilingStatistics{Total: *stat(100, 200), Additional: map[string]Statistic{"/sign": *stat(50)}}
+	b.Result.ProfilingResults = &ProfilingStatistics{Total: *stat(300), Additional: map[string]Statistic{"/plugin": *stat(10)}}
+	a.Result.Overhead = stat(1, 2)
+	b.Result.Reauthentication = stat(7)
+	a.Result.PerApp = map[string]*Statistic{"app-1": stat(1e6, 2e6), "app-2": stat(3e6)}
+	b.Result.PerApp = map[string]*Statistic{"app-1": stat(5e6)}
+	a.Result.HTTPPhases = &HTTPPhaseStatistics{TTFB: stat(10, 20), ReusedConnections: 2}
+	b.Result.HTTPPhases = &HTTPPhaseStatistics{TTFB: stat(30), TLS: stat(40), ReusedConnections: 1}
+	full := TLSHandshakeGroup{Version: "TLS 1.3", CipherSuite: "TLS_AES_128_GCM_SHA256", KeyExchange: "X25519"}
+	resumed := full
+	resumed.Resumed = true
+	withStatistic := func(group TLSHandshakeGroup, st *Statistic) TLSHandshakeGroup {
+		group.Statistic = st
+		return group
+	}
+	a.Result.TLSHandshakes = &TLSHandshakeStatistics{Full: stat(8), ByParameters: []TLSHandshakeGroup{withStatistic(full, stat(8))}}
+	b.Result.TLSHandshakes = &TLSHandshakeStatistics{Full: stat(6), Resumed: stat(2, 4, 6), ResumptionRate: 0.75,
+		ByParameters: []TLSHandshakeGroup{withStatistic(full, stat(6)), withStatistic(resumed, stat(2, 4, 6))}}
+
+	merged, err := mergeTestSummaries([]MergeSource{{Name: "a"}, {Name: "b"}}, []*TestSummary{a, b}, false)
+	require.NoError(t, err)
+	r := merged.Result
+	require.NotNil(t, r.ProfilingResults)
+	assert.Equal(t, uint(3), r.ProfilingResults.Total.QueryNumber)
+	assert.Equal(t, 200.0, r.ProfilingResults.Total.Avg)
+	assert.Equal(t, uint(1), r.ProfilingResults.Additional["/sign"].QueryNumber)
+	assert.Equal(t, uint(1), r.ProfilingResults.Additional["/plugin"].QueryNumber)
+	assert.Zero(t, r.ProfilingResults.Operate.QueryNumber)
+	assert.Equal(t, uint(2), r.Overhead.QueryNumber)
+	assert.Equal(t, 7.0, r.Reauthentication.Avg)
+	assert.Equal(t, uint(3), r.PerApp["app-1"].QueryNumber)
+	assert.Equal(t, uint(1), r.PerApp["app-2"].QueryNumber)
+	assert.Equal(t, uint(3), r.HTTPPhases.TTFB.QueryNumber)
+	assert.Equal(t, uint(1), r.HTTPPhases.TLS.QueryNumber)
+	assert.Nil(t, r.HTTPPhases.DNS)
+	assert.Equal(t, uint(3), r.HTTPPhases.ReusedConnections)
+	require.NotNil(t, r.TLSHandshakes)
+	assert.Equal(t, uint(2), r.TLSHandshakes.Full.QueryNumber)
+	assert.Equal(t, 0.6, r.TLSHandshakes.ResumptionRate)
+	require.Len(t, r.TLSHandshakes.ByParameters, 2)
+	assert.Equal(t, uint(2), r.TLSHandshakes.ByParameters[0].Statistic.QueryNumber)
+	assert.False(t, r.TLSHandshakes.ByParameters[0].Resumed)
+	// a passed and b failed, the merged p99 is checked again
+	assert.True(t, a.Result.Checks[0].Passed)
+	assert.False(t, b.Result.Checks[0].Passed)
+	require.Len(t, r.Checks, 1)
+	assert.False(t, r.Checks[0].Passed)
+	assert.InEpsilon(t, 6e6, *r.Checks[0].Measured, 0.01)
+}
+
+func TestMergeRawLatencies(t *testing.T) {
+	a := summaryOfLatencies("test", stats.Float64Data{1e6, 2e6, 3e6}, time.Second)
+	a.Result.TestLatencies = []float64{1e6, 2e6, 3e6}
+	a.Result.TestHistogram = nil
+	b := summaryOfLatencies("test", stats.Float64Data{10e6, 20e6}, time.Second)
+	b.Result.TestLatencies = []float64{10e6, 20e6}
+
+	merged, err := mergeTestSummaries([]MergeSource{{Name: "a"}, {Name: "b"}}, []*TestSummary{a, b}, false)
+	require.NoError(t, err)
+	exact := StatisticFromFloat64Data(stats.Float64Data{1e6, 2e6, 3e6, 10e6, 20e6}, nil)
+	assert.Equal(t, exact.P50, merged.Result.Test.P50)
+	assert.Equal(t, exact.P99, merged.Result.Test.P99)
+	assert.InDelta(t, 5, *merged.Result.Test.QPS, 1e-9)
+	assert.Len(t, merged.Result.TestLatencies, 5)
+	assert.Equal(t, uint64(5), merged.Result.TestHistogram.Count(), "histogram built from the raw latencies")
+
+	// without raw latencies of every source, the histogram is used
+	b.Result.TestLatencies = nil
+	merged, err = mergeTestSummaries([]MergeSource{{Name: "a"}, {Name: "b"}}, []*TestSummary{a, b}, false)
+	require.NoError(t, err)
+	assert.Nil(t, merged.Result.TestLatencies)
+	assert.InEpsilon(t, 3e6, merged.Result.Test.P50, 0.01)
+
+	b.Result.TestHistogram = nil
+	_, err = mergeTestSummaries([]MergeSource{{Name: "a"}, {Name: "b"}}, []*TestSummary{a, b}, false)
+	assert.EqualError(t, err, "result of b has neither a latency histogram nor raw latencies")
+}
+
+func TestMergeTrialsAndStartSkews(t *testing.T) {
+	latencies := stats.Float64Data{1e6, 2e6}
+	a := summaryOfLatencies("test", latencies, time.Second)
+	b := summaryOfLatencies("test", latencies, time.Second)
+	b.TestTime = "2026-10-18T10:00:05Z"
+	sources := []MergeSource{
+		{Name: "a", ClockOffsetSource: clockOffsetAssumed},
+		{Name: "b", ClockOffset: 4 * time.Second, ClockOffsetSource: clockOffsetGiven},
+	}
+
+	merged, err := mergeTrials(sources, [][]*TestSummary{{a, a}, {b, b}}, false)
+	require.NoError(t, err)
+	require.Len(t, merged, 2)
+	assert.Equal(t, "2026-10-18T10:00:00Z", merged[1].TestTime)
+	assert.Equal(t, []time.Duration{0, time.Second}, []time.Duration{merged[1].Result.Sources[0].StartSkew, merged[1].Result.Sources[1].StartSkew})
+	assert.Equal(t, clockOffsetGiven, merged[0].Result.Sources[1].ClockOffsetSource)
+	assert.Empty(t, startSkewWarnings(merged[0].Result))
+
+	sources[1].ClockOffset = 0
+	merged, err = mergeTrials(sources, [][]*TestSummary{{a}, {b}}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b started 5s after the first result, the results may not overlap"}, startSkewWarnings(merged[0].Result))
+
+	_, err = mergeTrials(sources, [][]*TestSummary{{a, a}, {b}}, false)
+	assert.EqualError(t, err, "b has 1 trials instead of 2")
+}
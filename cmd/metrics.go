@@ -0,0 +1,322 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var metricsListen string
+
+// liveMetrics is nil unless --metrics-listen is given, all its methods are
+// no-ops on a nil receiver.
+var liveMetrics *loadTestMetrics
+
+// latencyBuckets are the upper bounds of the request latency histogram in
+// seconds.
+var latencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+const (
+	outcomeSuccess     string = "success"
+	outcomeClientError string = "client_error"
+	outcomeServerError string = "server_error"
+	outcomeError       string = "error"
+)
+
+type requestLabels struct {
+	operation string
+	stage     string
+	outcome   string
+}
+
+type latencyLabels struct {
+	operation string
+	stage     string
+}
+
+type latencyHistogram struct {
+	buckets []uint64 // cumulative counts are computed when exposed
+	count   uint64
+	sum     float64
+}
+
+// loadTestMetrics holds the live metrics of running load tests exposed in the
+// Prometheus text format.
+type loadTestMetrics struct {
+	inFlight      int64
+	activeWorkers int64
+
+	mutex     sync.Mutex
+	targetQPS float64
+	requests  map[requestLabels]uint64
+	latencies map[latencyLabels]*latencyHistogram
+	queue     func() int
+	dispatch  *dispatchCounters
+	profiling map[string]float64
+}
+
+func newLoadTestMetrics() *loadTestMetrics {
+	return &loadTestMetrics{
+		requests:  make(map[requestLabels]uint64),
+		latencies: make(map[latencyLabels]*latencyHistogram),
+		profiling: make(map[string]float64),
+	}
+}
+
+// startMetricsServer starts serving the live metrics on --metrics-listen.
+func startMetricsServer() {
+	if metricsListen == "" {
+		return
+	}
+	liveMetrics = newLoadTestMetrics()
+	listener, err := net.Listen("tcp", metricsListen)
+	if err != nil {
+		log.Fatalf("Failed to listen for metrics: %v\n", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", liveMetrics)
+	log.Printf("Serving metrics on http://%v/metrics\n", listener.Addr())
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("Error: metrics server: %v\n", err)
+		}
+	}()
+}
+
+func (m *loadTestMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// startTest resets the per test gauges for a new load test. queue is nil in
+// open loop mode, where tokens are not queued.
+func (m *loadTestMetrics) startTest(targetQPS float64, queue func() int, dispatch *dispatchCounters) {
+	if m == nil {
+		return
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.targetQPS = targetQPS
+	m.queue = queue
+	m.dispatch = dispatch
+	m.profiling = make(map[string]float64)
+}
+
+func (m *loadTestMetrics) workerStarted() {
+	if m != nil {
+		atomic.AddInt64(&m.activeWorkers, 1)
+	}
+}
+
+func (m *loadTestMetrics) workerStopped() {
+	if m != nil {
+		atomic.AddInt64(&m.activeWorkers, -1)
+	}
+}
+
+func (m *loadTestMetrics) requestStarted() {
+	if m != nil {
+		atomic.AddInt64(&m.inFlight, 1)
+	}
+}
+
+// requestFinished counts a finished request and records its latency if it
+// succeeded.
+func (m *loadTestMetrics) requestFinished(operation string, stage loadTestStage, d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.inFlight, -1)
+	stageName := stage.String()
+	outcome := requestOutcome(err)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.requests[requestLabels{operation, stageName, outcome}]++
+	if err != nil {
+		return
+	}
+	key := latencyLabels{operation, stageName}
+	h, ok := m.latencies[key]
+	if !ok {
+		h = &latencyHistogram{buckets: make([]uint64, len(latencyBuckets))}
+		m.latencies[key] = h
+	}
+	seconds := d.Seconds()
+	for i, le := range latencyBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// observeProfiling records the stage timings of the latest response with
+// profiling data.
+func (m *loadTestMetrics) observeProfiling(p profilingMetricStr) {
+	if m == nil || p == "" {
+		return
+	}
+	var data profilingData
+	if err := json.Unmarshal([]byte(p), &data); err != nil {
+		return
+	}
+	stages := map[string]float64{
+		"in_queue":       float64(data.InQueue),
+		"parse_request":  float64(data.ParseRequest),
+		"session_lookup": float64(data.SessionLookup),
+		"validate_input": float64(data.ValidateInput),
+		"check_access":   float64(data.CheckAccess),
+		"operate":        float64(data.Operate),
+		"db_flush":       float64(data.DbFlush),
+		"total":          float64(data.Total),
+	}
+	for key, ns := range flattenAdditionalProfilingData(data.AdditionalProfilingData) {
+		stages[key] = float64(ns)
+	}
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for stage, ns := range stages {
+		m.profiling[stage] = ns / 1e9
+	}
+}
+
+func requestOutcome(err error) string {
+	if err == nil {
+		return outcomeSuccess
+	}
+	var backendErr *sdkms.BackendError
+	if errors.As(err, &backendErr) {
+		switch {
+		case backendErr.StatusCode >= 500:
+			return outcomeServerError
+		case backendErr.StatusCode >= 400:
+			return outcomeClientError
+		}
+	}
+	return outcomeError
+}
+
+func (s loadTestStage) String() string {
+	switch s {
+	case warmupStage:
+		return "warmup"
+	case testStage:
+		return "test"
+	default:
+		return "unknown"
+	}
+}
+
+// write writes all metrics in the Prometheus text exposition format.
+func (m *loadTestMetrics) write(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP dsm_perf_requests_total Requests sent by the load test.\n")
+	fmt.Fprintf(w, "# TYPE dsm_perf_requests_total counter\n")
+	requestKeys := make([]requestLabels, 0, len(m.requests))
+	for key := range m.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		a, b := requestKeys[i], requestKeys[j]
+		if a.operation != b.operation {
+			return a.operation < b.operation
+		}
+		if a.stage != b.stage {
+			return a.stage < b.stage
+		}
+		return a.outcome < b.outcome
+	})
+	for _, key := range requestKeys {
+		fmt.Fprintf(w, "dsm_perf_requests_total{operation=%s,stage=%s,outcome=%s} %d\n",
+			quoteLabel(key.operation), quoteLabel(key.stage), quoteLabel(key.outcome), m.requests[key])
+	}
+
+	fmt.Fprintf(w, "# HELP dsm_perf_request_duration_seconds Latency of successful requests.\n")
+	fmt.Fprintf(w, "# TYPE dsm_perf_request_duration_seconds histogram\n")
+	latencyKeys := make([]latencyLabels, 0, len(m.latencies))
+	for key := range m.latencies {
+		latencyKeys = append(latencyKeys, key)
+	}
+	sort.Slice(latencyKeys, func(i, j int) bool {
+		a, b := latencyKeys[i], latencyKeys[j]
+		if a.operation != b.operation {
+			return a.operation < b.operation
+		}
+		return a.stage < b.stage
+	})
+	for _, key := range latencyKeys {
+		h := m.latencies[key]
+		labels := fmt.Sprintf("operation=%s,stage=%s", quoteLabel(key.operation), quoteLabel(key.stage))
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += h.buckets[i]
+			fmt.Fprintf(w, "dsm_perf_request_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, le, cumulative)
+		}
+		fmt.Fprintf(w, "dsm_perf_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "dsm_perf_request_duration_seconds_sum{%s} %g\n", labels, h.sum)
+		fmt.Fprintf(w, "dsm_perf_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	writeGauge(w, "dsm_perf_requests_in_flight", "Requests waiting for a response.", float64(atomic.LoadInt64(&m.inFlight)))
+	if m.queue != nil {
+		writeGauge(w, "dsm_perf_token_queue_depth", "Request tokens produced but not yet taken by a worker.", float64(m.queue()))
+	}
+	if m.dispatch != nil {
+		dropped, late := m.dispatch.counts()
+		writeCounter(w, "dsm_perf_tokens_dropped_total", "Request tokens dropped in open loop mode as all workers were busy.", dropped)
+		writeCounter(w, "dsm_perf_tokens_late_total", "Request tokens taken by a worker later than --late-threshold after their schedule.", late)
+	}
+	writeGauge(w, "dsm_perf_active_workers", "Workers (connections) of the running load test.", float64(atomic.LoadInt64(&m.activeWorkers)))
+	writeGauge(w, "dsm_perf_target_qps", "Target QPS of the running load test.", m.targetQPS)
+
+	fmt.Fprintf(w, "# HELP dsm_perf_profiling_stage_seconds Server side stage timings of the latest response with profiling data.\n")
+	fmt.Fprintf(w, "# TYPE dsm_perf_profiling_stage_seconds gauge\n")
+	stages := make([]string, 0, len(m.profiling))
+	for stage := range m.profiling {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	for _, stage := range stages {
+		fmt.Fprintf(w, "dsm_perf_profiling_stage_seconds{stage=%s} %g\n", quoteLabel(stage), m.profiling[stage])
+	}
+}
+
+func writeGauge(w io.Writer, name string, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %g\n", name, value)
+}
+
+func writeCounter(w io.Writer, name string, help string, value uint) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(value string) string {
+	return `"` + labelEscaper.Replace(value) + `"`
+}
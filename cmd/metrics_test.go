@@ -0,0 +1,78 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadTestMetrics(t *testing.T) {
+	defer func(l time.Duration) { lateTokenThreshold = l }(lateTokenThreshold)
+	lateTokenThreshold = 10 * time.Millisecond
+	var dispatch dispatchCounters
+	dispatch.drop()
+	dispatch.dispatched(time.Now().Add(-time.Second))
+	dispatch.dispatched(time.Now())
+	m := newLoadTestMetrics()
+	m.startTest(100, func() int { return 3 }, &dispatch)
+	m.workerStarted()
+	for _, d := range []time.Duration{300 * time.Microsecond, 2 * time.Millisecond, 20 * time.Second} {
+		m.requestStarted()
+		m.requestFinished(`AES "GCM"`, testStage, d, nil)
+	}
+	m.requestStarted()
+	m.requestFinished(`AES "GCM"`, testStage, 0, &sdkms.BackendError{StatusCode: http.StatusServiceUnavailable})
+	m.requestStarted()
+	m.observeProfiling(`{"total":1500000,"operate":1000000,"additional_profiling":[{"action":"plugin","took_ns":500000}]}`)
+
+	var buf bytes.Buffer
+	m.write(&buf)
+	out := buf.String()
+	assert.Contains(t, out, `dsm_perf_requests_total{operation="AES \"GCM\"",stage="test",outcome="success"} 3`)
+	assert.Contains(t, out, `dsm_perf_requests_total{operation="AES \"GCM\"",stage="test",outcome="server_error"} 1`)
+	assert.Contains(t, out, `dsm_perf_request_duration_seconds_bucket{operation="AES \"GCM\"",stage="test",le="0.0005"} 1`)
+	assert.Contains(t, out, `dsm_perf_request_duration_seconds_bucket{operation="AES \"GCM\"",stage="test",le="10"} 2`)
+	assert.Contains(t, out, `dsm_perf_request_duration_seconds_bucket{operation="AES \"GCM\"",stage="test",le="+Inf"} 3`)
+	assert.Contains(t, out, "dsm_perf_requests_in_flight 1\n")
+	assert.Contains(t, out, "dsm_perf_token_queue_depth 3\n")
+	assert.Contains(t, out, "dsm_perf_tokens_dropped_total 1\n")
+	assert.Contains(t, out, "dsm_perf_tokens_late_total 1\n")
+	assert.Contains(t, out, "dsm_perf_active_workers 1\n")
+	assert.Contains(t, out, `dsm_perf_profiling_stage_seconds{stage="total"} 0.0015`)
+	assert.Contains(t, out, `dsm_perf_profiling_stage_seconds{stage="/plugin"} 0.0005`)
+
+	// repeated actions are summed per request, unlike in the profiling statistics
+	repeated := `{"total":2000,"additional_profiling":[{"action":"sign","took_ns":300,"sub_actions":[{"action":"hsm","took_ns":200}]},{"action":"sign","took_ns":100}]}`
+	m.observeProfiling(profilingMetricStr(repeated))
+	assert.Equal(t, 400e-9, m.profiling["/sign"])
+	assert.Equal(t, 200e-9, m.profiling["/sign/hsm"])
+	var data profilingData
+	require.NoError(t, json.Unmarshal([]byte(repeated), &data))
+	stats := getProfilingMetrics(profilingDataArr{data, data})
+	assert.Equal(t, uint(4), stats.Additional["/sign"].QueryNumber, "one value per occurrence")
+	assert.Equal(t, 200.0, stats.Additional["/sign"].Avg)
+	assert.Equal(t, uint(2), stats.Additional["/sign/hsm"].QueryNumber)
+
+	// open loop mode has no token queue
+	m.startTest(100, nil, &dispatch)
+	buf.Reset()
+	m.write(&buf)
+	assert.NotContains(t, buf.String(), "dsm_perf_token_queue_depth")
+	assert.Contains(t, buf.String(), "dsm_perf_tokens_dropped_total 1\n")
+
+	var nilMetrics *loadTestMetrics
+	nilMetrics.requestStarted()
+	nilMetrics.requestFinished("op", testStage, time.Millisecond, nil)
+}
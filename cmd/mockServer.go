@@ -0,0 +1,110 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/fortanix/dsm-perf-tool/mockserver"
+	"github.com/spf13/cobra"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var mockListenAddr string
+var mockLatency string
+var mockErrorRate float64
+var mockErrorStatus int
+var mockSessionTTL time.Duration
+var mockProfilingData bool
+var mockSeed int64
+var mockCAFile string
+
+var mockServerCmd = &cobra.Command{
+	Use:   "mock-server",
+	Short: "Run a mock DSM server",
+	Long: `Run a mock DSM server emulating the APIs used by this tool: authentication,
+encrypt/decrypt, sign/verify, security objects, plugins, accounts, groups and
+apps. Operations can be slowed down with a latency distribution, fail at a
+configured rate and return synthetic Profiling-Data headers. The server uses
+a self-signed certificate, point the load tests to it with --insecure.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runMockServer()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mockServerCmd)
+
+	mockServerCmd.Flags().StringVar(&mockListenAddr, "listen", "127.0.0.1:8443", "Address to listen on")
+	mockServerCmd.Flags().StringVar(&mockLatency, "latency", "0s", "Simulated operation latency, e.g. 2ms, uniform:1ms-3ms, normal:2ms,500us or exp:2ms")
+	mockServerCmd.Flags().Float64Var(&mockErrorRate, "error-rate", 0, "Fraction of operations failing with --error-status, between 0 and 1")
+	mockServerCmd.Flags().IntVar(&mockErrorStatus, "error-status", http.StatusInternalServerError, "HTTP status of injected errors")
+	mockServerCmd.Flags().DurationVar(&mockSessionTTL, "session-ttl", time.Hour, "Session lifetime, after which requests fail with 401")
+	mockServerCmd.Flags().BoolVar(&mockProfilingData, "profiling-data", true, "Return synthetic Profiling-Data headers")
+	mockServerCmd.Flags().Int64Var(&mockSeed, "seed", 0, "Seed of the latency and error injection, 0 means a time based seed")
+	mockServerCmd.Flags().StringVar(&mockCAFile, "ca-out", "", "File to write the PEM CA certificate of the server to, so it can be trusted instead of using --insecure")
+}
+
+func runMockServer() {
+	latency, err := mockserver.ParseLatency(mockLatency)
+	if err != nil {
+		log.Fatalf("Invalid latency: %v\n", err)
+	}
+	if mockErrorRate < 0 || mockErrorRate > 1 {
+		log.Fatalf("Invalid error rate: %v\n", mockErrorRate)
+	}
+	server := mockserver.New(mockserver.Config{
+		Latency:       latency,
+		ErrorRate:     mockErrorRate,
+		ErrorStatus:   mockErrorStatus,
+		SessionTTL:    mockSessionTTL,
+		ProfilingData: mockProfilingData,
+		Seed:          mockSeed,
+	})
+	tlsConfig, err := mockServerTLSConfig()
+	if err != nil {
+		log.Fatalf("Failed to create server certificate: %v\n", err)
+	}
+	listener, err := net.Listen("tcp", mockListenAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v\n", err)
+	}
+	log.Printf("Mock DSM server listening on %v, latency: %v, error rate: %v\n", listener.Addr(), latency, mockErrorRate)
+	// ServeTLS offers HTTP/2 besides HTTP/1.1, like DSM
+	httpServer := &http.Server{Handler: server, TLSConfig: tlsConfig}
+	log.Fatal(httpServer.ServeTLS(listener, "", ""))
+}
+
+func mockServerTLSConfig() (*tls.Config, error) {
+	ca, err := generateTestCA("dsm-perf-tool mock server CA")
+	if err != nil {
+		return nil, err
+	}
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, err := net.SplitHostPort(mockListenAddr); err == nil && host != "" {
+		hosts = append([]string{host}, hosts...)
+	}
+	cert, err := issueServerCertificate(ca, hosts)
+	if err != nil {
+		return nil, err
+	}
+	if mockCAFile != "" {
+		if err := ca.writeCertificatePEM(mockCAFile, ""); err != nil {
+			return nil, err
+		}
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Der, ca.Der}, PrivateKey: cert.Key, Leaf: cert.Cert}},
+		// certificate authentication needs the client certificate
+		ClientAuth: tls.RequestClientCert,
+	}, nil
+}
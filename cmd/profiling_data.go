@@ -7,13 +7,6 @@
 package cmd
 
 import (
-	"encoding/csv"
-	"encoding/json"
-	"log"
-	"os"
-	"reflect"
-	"strconv"
-
 	"github.com/montanaflynn/stats"
 )
 
@@ -79,6 +72,8 @@ func getProfilingMetrics(dataArr profilingDataArr) *ProfilingStatistics {
 	}
 }
 
+// processAdditionalProfilingData adds one sample per occurrence of each
+// additional action to output, by /action/sub_action path.
 func processAdditionalProfilingData(path string, input []additionalProfilingData, output map[string]stats.Float64Data) {
 	for _, item := range input {
 		key := path + "/" + item.Action
@@ -87,58 +82,21 @@ func processAdditionalProfilingData(path string, input []additionalProfilingData
 	}
 }
 
-func (dataArr profilingDataArr) getCSVHeaders() (header []string) {
-	e := reflect.ValueOf(&dataArr[0]).Elem()
-	for i := 0; i < e.NumField(); i++ {
-		varName := e.Type().Field(i).Name
-		header = append(header, varName)
-	}
-	return header
-}
-
-func (dataArr profilingDataArr) getCSVValues() (values [][]string) {
-	for _, data := range dataArr {
-		values = append(values, []string{
-			strconv.FormatUint(data.InQueue, 10),
-			strconv.FormatUint(data.ParseRequest, 10),
-			strconv.FormatUint(data.SessionLookup, 10),
-			strconv.FormatUint(data.ValidateInput, 10),
-			strconv.FormatUint(data.CheckAccess, 10),
-			strconv.FormatUint(data.Operate, 10),
-			strconv.FormatUint(data.DbFlush, 10),
-			strconv.FormatUint(data.Total, 10),
-		})
-	}
-	return values
-}
-
-func saveProfilingMetricsToCSV(dataArr profilingDataArr) {
-	csvFile, err := os.CreateTemp(".", "profilingData.*.csv")
-	if err != nil {
-		log.Fatalf("Fatal error: %v\n", err)
-	}
-	w := csv.NewWriter(csvFile)
-	headers := dataArr.getCSVHeaders()
-	values := dataArr.getCSVValues()
-	if err := w.Write(headers); err != nil {
-		log.Fatalf("Fatal error: %v\n", err)
-	}
-	if err := w.WriteAll(values); err != nil {
-		log.Fatalf("Fatal error: %v\n", err)
-	}
-
-	log.Println("Saved profiling data to:", csvFile.Name())
-}
-
-func parseProfilingMetricStrArr(profilingDataStrArr []profilingMetricStr) profilingDataArr {
-	var dataArr profilingDataArr
-	for _, profilingDataStr := range profilingDataStrArr {
-		var profilingData profilingData
-		err := json.Unmarshal([]byte(string(profilingDataStr)), &profilingData)
-		if err != nil {
-			log.Fatalf("Fatal error: %v\n", err)
+// flattenAdditionalProfilingData returns the time spent in each additional
+// action of one request by /action/sub_action path. An action repeated within
+// the request, e.g. once per item of a batch, is summed, so that every path
+// has one value per request in the live metrics and the profiling data
+// exports. The profiling statistics keep one sample per occurrence instead.
+func flattenAdditionalProfilingData(items []additionalProfilingData) map[string]uint64 {
+	timings := make(map[string]uint64)
+	var add func(path string, items []additionalProfilingData)
+	add = func(path string, items []additionalProfilingData) {
+		for _, item := range items {
+			key := path + "/" + item.Action
+			timings[key] += item.TookNs
+			add(key, item.SubActions)
 		}
-		dataArr = append(dataArr, profilingData)
 	}
-	return dataArr
+	add("", items)
+	return timings
 }
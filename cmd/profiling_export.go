@@ -0,0 +1,315 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var profilingDataFile string
+var profilingDataFormat = profilingFormatCSV
+
+type profilingFormat string
+
+const (
+	profilingFormatCSV      profilingFormat = "csv"
+	profilingFormatJSONL    profilingFormat = "jsonl"
+	profilingFormatColumnar profilingFormat = "columnar"
+)
+
+// impl pflag.Value interface for profilingFormat
+
+func (f *profilingFormat) String() string {
+	return string(*f)
+}
+
+func (f *profilingFormat) Set(v string) error {
+	switch v {
+	case "csv":
+		*f = profilingFormatCSV
+	case "jsonl", "json-lines":
+		*f = profilingFormatJSONL
+	case "columnar":
+		*f = profilingFormatColumnar
+	default:
+		return fmt.Errorf("invalid profiling data format: %v", v)
+	}
+	return nil
+}
+
+func (f *profilingFormat) Type() string {
+	return "ProfilingFormat"
+}
+
+func (f profilingFormat) extension() string {
+	switch f {
+	case profilingFormatJSONL:
+		return "jsonl"
+	case profilingFormatColumnar:
+		return "json"
+	default:
+		return "csv"
+	}
+}
+
+// profilingRecord is the profiling data of one request together with the
+// client side measurements of the request.
+type profilingRecord struct {
+	Time    time.Time     // When the worker sent the request, not the scheduled time of its token
+	Latency time.Duration // Client side latency
+	Worker  uint
+	raw     profilingMetricStr
+	Data    profilingData
+}
+
+// parseProfilingRecords parses the profiling data of all records.
+func parseProfilingRecords(records []profilingRecord) profilingDataArr {
+	dataArr := make(profilingDataArr, 0, len(records))
+	for i := range records {
+		if err := json.Unmarshal([]byte(records[i].raw), &records[i].Data); err != nil {
+			log.Fatalf("Fatal error: %v\n", err)
+		}
+		dataArr = append(dataArr, records[i].Data)
+	}
+	return dataArr
+}
+
+// overhead is the part of the client latency not spent in the server, i.e.
+// the network, TLS and load balancer cost of the request.
+func (r *profilingRecord) overhead() time.Duration {
+	return r.Latency - time.Duration(r.Data.Total)
+}
+
+// requestOverheads returns the start time and overhead of each record, the
+// records must be parsed.
+func requestOverheads(records []profilingRecord) ([]time.Time, []time.Duration) {
+	times := make([]time.Time, len(records))
+	overheads := make([]time.Duration, len(records))
+	for i := range records {
+		times[i] = records[i].Time
+		overheads[i] = records[i].overhead()
+	}
+	return times, overheads
+}
+
+// fixedTimings returns the fixed profiling stages of the record in the order
+// of fixedProfilingStages.
+func (r *profilingRecord) fixedTimings() []uint64 {
+	d := &r.Data
+	return []uint64{d.InQueue, d.ParseRequest, d.SessionLookup, d.ValidateInput, d.CheckAccess, d.Operate, d.DbFlush, d.Total}
+}
+
+// additionalTimings flattens the additional profiling data of the record,
+// see flattenAdditionalProfilingData.
+func (r *profilingRecord) additionalTimings() map[string]uint64 {
+	return flattenAdditionalProfilingData(r.Data.AdditionalProfilingData)
+}
+
+// additionalPaths returns the sorted union of the additional profiling paths
+// of all records.
+func additionalPaths(records []profilingRecord) []string {
+	paths := make(map[string]struct{})
+	for i := range records {
+		for path := range records[i].additionalTimings() {
+			paths[path] = struct{}{}
+		}
+	}
+	return sortedKeys(paths)
+}
+
+// saveProfilingData writes the profiling data of every request of a load test
+// to --profiling-data-file, or to a new file in the working directory if only
+// --store-profiling-data is given. trial is the number of a repeated trial,
+// which is added to the file name, or 0.
+func saveProfilingData(summary *TestSummary, trial uint) {
+	if (!storeProfilingData && profilingDataFile == "") || len(summary.profiling) == 0 {
+		return
+	}
+	var file *os.File
+	var err error
+	if profilingDataFile == "" {
+		file, err = os.CreateTemp(".", "profilingData.*."+profilingDataFormat.extension())
+	} else {
+		file, err = os.Create(trialFileName(profilingDataFile, trial))
+	}
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	w := bufio.NewWriter(file)
+	switch profilingDataFormat {
+	case profilingFormatJSONL:
+		err = writeProfilingJSONL(w, summary.profiling)
+	case profilingFormatColumnar:
+		err = writeProfilingColumnar(w, summary.profiling)
+	default:
+		err = writeProfilingCSV(w, summary.profiling)
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	log.Println("Saved profiling data to:", file.Name())
+}
+
+// trialFileName adds the trial number before the extension of the path, e.g.
+// profiling.trial-2.csv.
+func trialFileName(path string, trial uint) string {
+	if trial == 0 {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return fmt.Sprintf("%s.trial-%d%s", strings.TrimSuffix(path, ext), trial, ext)
+}
+
+var profilingRecordColumns = []string{"timestamp", "client_latency_ns", "worker"}
+
+// writeProfilingCSV writes one row per request, the additional profiling
+// columns are the union of the paths of all requests. Cells of paths a
+// request did not report are empty.
+func writeProfilingCSV(w io.Writer, records []profilingRecord) error {
+	paths := additionalPaths(records)
+	writer := csv.NewWriter(w)
+	header := append(append(append([]string(nil), profilingRecordColumns...), fixedProfilingStages...), paths...)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for i := range records {
+		r := &records[i]
+		row := []string{r.Time.Format(time.RFC3339Nano), strconv.FormatInt(r.Latency.Nanoseconds(), 10), strconv.FormatUint(uint64(r.Worker), 10)}
+		for _, v := range r.fixedTimings() {
+			row = append(row, strconv.FormatUint(v, 10))
+		}
+		additional := r.additionalTimings()
+		for _, path := range paths {
+			if v, ok := additional[path]; ok {
+				row = append(row, strconv.FormatUint(v, 10))
+			} else {
+				row = append(row, "")
+			}
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+type profilingJSONRecord struct {
+	Timestamp       time.Time         `json:"timestamp"`
+	ClientLatencyNs int64             `json:"client_latency_ns"`
+	Worker          uint              `json:"worker"`
+	InQueue         uint64            `json:"in_queue"`
+	ParseRequest    uint64            `json:"parse_request"`
+	SessionLookup   uint64            `json:"session_lookup"`
+	ValidateInput   uint64            `json:"validate_input"`
+	CheckAccess     uint64            `json:"check_access"`
+	Operate         uint64            `json:"operate"`
+	DbFlush         uint64            `json:"db_flush"`
+	Total           uint64            `json:"total"`
+	Additional      map[string]uint64 `json:"additional,omitempty"`
+}
+
+// writeProfilingJSONL writes one JSON object per line and request.
+func writeProfilingJSONL(w io.Writer, records []profilingRecord) error {
+	encoder := json.NewEncoder(w)
+	for i := range records {
+		r := &records[i]
+		d := &r.Data
+		record := profilingJSONRecord{
+			Timestamp:       r.Time,
+			ClientLatencyNs: r.Latency.Nanoseconds(),
+			Worker:          r.Worker,
+			InQueue:         d.InQueue,
+			ParseRequest:    d.ParseRequest,
+			SessionLookup:   d.SessionLookup,
+			ValidateInput:   d.ValidateInput,
+			CheckAccess:     d.CheckAccess,
+			Operate:         d.Operate,
+			DbFlush:         d.DbFlush,
+			Total:           d.Total,
+		}
+		if additional := r.additionalTimings(); len(additional) != 0 {
+			record.Additional = additional
+		}
+		if err := encoder.Encode(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// profilingColumns is the columnar layout: every column is an array with one
+// value per request, e.g. for pandas.DataFrame(doc["columns"]).
+type profilingColumns struct {
+	Rows    int                    `json:"rows"`
+	Names   []string               `json:"names"` // Column names in order
+	Columns map[string]interface{} `json:"columns"`
+}
+
+// writeProfilingColumnar writes a single JSON document with one array per
+// column. Additional paths a request did not report are null.
+func writeProfilingColumnar(w io.Writer, records []profilingRecord) error {
+	paths := additionalPaths(records)
+	doc := profilingColumns{
+		Rows:    len(records),
+		Names:   append(append(append([]string(nil), profilingRecordColumns...), fixedProfilingStages...), paths...),
+		Columns: make(map[string]interface{}),
+	}
+	timestamps := make([]string, len(records))
+	latencies := make([]int64, len(records))
+	workers := make([]uint, len(records))
+	fixed := make([][]uint64, len(fixedProfilingStages))
+	for j := range fixed {
+		fixed[j] = make([]uint64, len(records))
+	}
+	additional := make([][]*uint64, len(paths))
+	for j := range additional {
+		additional[j] = make([]*uint64, len(records))
+	}
+	for i := range records {
+		r := &records[i]
+		timestamps[i] = r.Time.Format(time.RFC3339Nano)
+		latencies[i] = r.Latency.Nanoseconds()
+		workers[i] = r.Worker
+		for j, v := range r.fixedTimings() {
+			fixed[j][i] = v
+		}
+		timings := r.additionalTimings()
+		for j, path := range paths {
+			if v, ok := timings[path]; ok {
+				additional[j][i] = &v
+			}
+		}
+	}
+	doc.Columns["timestamp"] = timestamps
+	doc.Columns["client_latency_ns"] = latencies
+	doc.Columns["worker"] = workers
+	for j, stage := range fixedProfilingStages {
+		doc.Columns[stage] = fixed[j]
+	}
+	for j, path := range paths {
+		doc.Columns[path] = additional[j]
+	}
+	return json.NewEncoder(w).Encode(doc)
+}
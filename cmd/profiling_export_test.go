@@ -0,0 +1,98 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newProfilingRecords(t *testing.T) []profilingRecord {
+	start := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []profilingRecord{
+		{Time: start, Latency: 3 * time.Millisecond, Worker: 1, raw: `{"operate":1000,"total":2000,"additional_profiling":[{"action":"sign","took_ns":300,"sub_actions":[{"action":"hsm","took_ns":200}]},{"action":"sign","took_ns":100}]}`},
+		{Time: start.Add(time.Second), Latency: 4 * time.Millisecond, Worker: 2, raw: `{"operate":1500,"total":2500,"additional_profiling":[{"action":"audit","took_ns":50}]}`},
+	}
+	dataArr := parseProfilingRecords(records)
+	require.Len(t, dataArr, 2)
+	return records
+}
+
+func TestAdditionalTimings(t *testing.T) {
+	records := newProfilingRecords(t)
+	assert.Equal(t, map[string]uint64{"/sign": 400, "/sign/hsm": 200}, records[0].additionalTimings())
+	assert.Equal(t, []string{"/audit", "/sign", "/sign/hsm"}, additionalPaths(records))
+}
+
+func TestRequestOverheads(t *testing.T) {
+	records := newProfilingRecords(t)
+	times, overheads := requestOverheads(records)
+	assert.Equal(t, []time.Duration{3*time.Millisecond - 2000, 4*time.Millisecond - 2500}, overheads)
+
+	start := records[0].Time
+	timeline := timelineFromDurations(start, 2*time.Second, time.Second, times, []time.Duration{3 * time.Millisecond, 4 * time.Millisecond}, nil)
+	addOverheadTimeline(timeline, start, time.Second, times, overheads)
+	require.Len(t, timeline, 2)
+	for i, interval := range timeline {
+		require.NotNil(t, interval.Overhead)
+		assert.Equal(t, uint(1), interval.Overhead.QueryNumber)
+		assert.Equal(t, float64(overheads[i]), interval.Overhead.P50)
+	}
+}
+
+func TestWriteProfilingCSV(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeProfilingCSV(&buf, newProfilingRecords(t)))
+	rows, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, rows, 3)
+	assert.Equal(t, []string{"timestamp", "client_latency_ns", "worker", "in_queue", "parse_request", "session_lookup",
+		"validate_input", "check_access", "operate", "db_flush", "total", "/audit", "/sign", "/sign/hsm"}, rows[0])
+	assert.Equal(t, []string{"2023-01-02T03:04:05Z", "3000000", "1", "0", "0", "0", "0", "0", "1000", "0", "2000", "", "400", "200"}, rows[1])
+	assert.Equal(t, "50", rows[2][11])
+}
+
+func TestWriteProfilingJSONL(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeProfilingJSONL(&buf, newProfilingRecords(t)))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	var record profilingJSONRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &record))
+	assert.Equal(t, int64(4e6), record.ClientLatencyNs)
+	assert.Equal(t, uint(2), record.Worker)
+	assert.Equal(t, uint64(2500), record.Total)
+	assert.Equal(t, map[string]uint64{"/audit": 50}, record.Additional)
+}
+
+func TestWriteProfilingColumnar(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, writeProfilingColumnar(&buf, newProfilingRecords(t)))
+	var doc struct {
+		Rows    int                        `json:"rows"`
+		Names   []string                   `json:"names"`
+		Columns map[string]json.RawMessage `json:"columns"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, 2, doc.Rows)
+	assert.Len(t, doc.Columns, len(doc.Names))
+	assert.JSONEq(t, `[1000, 1500]`, string(doc.Columns["operate"]))
+	assert.JSONEq(t, `[null, 50]`, string(doc.Columns["/audit"]))
+}
+
+func TestTrialFileName(t *testing.T) {
+	assert.Equal(t, "out/profiling.csv", trialFileName("out/profiling.csv", 0))
+	assert.Equal(t, "out/profiling.trial-2.csv", trialFileName("out/profiling.csv", 2))
+	assert.Equal(t, "profiling.trial-1", trialFileName("profiling", 1))
+}
@@ -0,0 +1,167 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var foldedStacksFile string
+
+// profilingNode is an additional profiling action and its sub actions.
+type profilingNode struct {
+	name     string
+	stat     *Statistic // nil if only sub actions were reported
+	children []*profilingNode
+}
+
+// profilingTree rebuilds the nesting of the additional profiling actions from
+// their /action/sub_action paths. The children of each node are sorted by
+// name.
+func profilingTree(additional map[string]Statistic) []*profilingNode {
+	root := &profilingNode{}
+	for _, path := range sortedKeys(additional) {
+		node := root
+		for _, name := range strings.Split(strings.TrimPrefix(path, "/"), "/") {
+			var child *profilingNode
+			for _, c := range node.children {
+				if c.name == name {
+					child = c
+					break
+				}
+			}
+			if child == nil {
+				child = &profilingNode{name: name}
+				node.children = append(node.children, child)
+			}
+			node = child
+		}
+		st := additional[path]
+		node.stat = &st
+	}
+	return root.children
+}
+
+// sum is the total time in nanoseconds spent in the node by all requests.
+func (n *profilingNode) sum() float64 {
+	if n.stat == nil {
+		var sum float64
+		for _, c := range n.children {
+			sum += c.sum()
+		}
+		return sum
+	}
+	return statisticSum(n.stat)
+}
+
+func statisticSum(st *Statistic) float64 {
+	return st.Avg * float64(st.QueryNumber)
+}
+
+// shareOfTotal formats the time spent in a stage as percentage of the total
+// time of all requests.
+func shareOfTotal(sum float64, total *Statistic) string {
+	totalSum := statisticSum(total)
+	if totalSum == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%.1f%% of Total)", sum/totalSum*100)
+}
+
+// printProfilingTree prints the nodes indented by their depth.
+func printProfilingTree(w io.Writer, nodes []*profilingNode, depth int, keyLen int, total *Statistic) {
+	for _, n := range nodes {
+		label := strings.Repeat("  ", depth) + "/" + n.name
+		stat := ""
+		if n.stat != nil {
+			stat = n.stat.String()
+		}
+		fmt.Fprintf(w, "%s: %s%s\n", StrPad(label, keyLen, " ", "RIGHT"), stat, shareOfTotal(n.sum(), total))
+		printProfilingTree(w, n.children, depth+1, keyLen, total)
+	}
+}
+
+func profilingTreeLabelLen(nodes []*profilingNode, depth int) int {
+	maxLen := 0
+	for _, n := range nodes {
+		maxLen = Max(maxLen, 2*depth+1+len(n.name))
+		maxLen = Max(maxLen, profilingTreeLabelLen(n.children, depth+1))
+	}
+	return maxLen
+}
+
+// WriteFoldedStacks writes the profiling statistics in the folded stack
+// format of flamegraph tools, one line per stack with the total time in
+// nanoseconds spent in its last frame. The fixed stages are children of the
+// total, the additional actions are nested under operate as they are recorded
+// while the operation runs. A frame's own time is its time minus the time of
+// its children.
+func (ps *ProfilingStatistics) WriteFoldedStacks(w io.Writer, root string) error {
+	stages := profilingStages(ps)
+	tree := profilingTree(ps.Additional)
+	var write func(stack string, sum float64, children []*profilingNode) error
+	write = func(stack string, sum float64, children []*profilingNode) error {
+		self := sum
+		for _, c := range children {
+			self -= c.sum()
+		}
+		if self = math.Round(self); self > 0 {
+			if _, err := fmt.Fprintf(w, "%s %.0f\n", stack, self); err != nil {
+				return err
+			}
+		}
+		for _, c := range children {
+			if err := write(stack+";"+foldedFrameEscaper.Replace(c.name), c.sum(), c.children); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var stageNodes []*profilingNode
+	for _, stage := range fixedProfilingStages {
+		if stage == "total" {
+			continue
+		}
+		node := &profilingNode{name: stage, stat: stages[stage]}
+		if stage == "operate" {
+			node.children = tree
+		}
+		stageNodes = append(stageNodes, node)
+	}
+	return write(foldedFrameEscaper.Replace(root), statisticSum(&ps.Total), stageNodes)
+}
+
+// foldedFrameEscaper keeps frame names from splitting a stack.
+var foldedFrameEscaper = strings.NewReplacer(";", ":", "\n", " ")
+
+// saveFoldedStacks writes the folded stacks of a load test to
+// --folded-stacks-file, trial is the number of a repeated trial or 0.
+func saveFoldedStacks(summary *TestSummary, trial uint) {
+	ps := summary.Result.ProfilingResults
+	if foldedStacksFile == "" || ps == nil {
+		return
+	}
+	path := trialFileName(foldedStacksFile, trial)
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	err = ps.WriteFoldedStacks(file, testDisplayName(summary.Config))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	log.Println("Saved folded stacks to:", path)
+}
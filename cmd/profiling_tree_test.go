@@ -0,0 +1,84 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newNestedProfilingStatistics() *ProfilingStatistics {
+	stat := func(avg float64) Statistic {
+		return Statistic{QueryNumber: 10, Avg: avg}
+	}
+	return &ProfilingStatistics{
+		InQueue: stat(100),
+		Operate: stat(700),
+		DbFlush: stat(100),
+		Total:   stat(1000),
+		Additional: map[string]Statistic{
+			"/plugin":          stat(500),
+			"/plugin/sign":     stat(300),
+			"/plugin/sign/hsm": stat(200),
+			"/plugin/audit":    stat(50),
+			"/cache/lookup":    stat(20),
+		},
+	}
+}
+
+func TestProfilingTree(t *testing.T) {
+	tree := profilingTree(newNestedProfilingStatistics().Additional)
+	require.Len(t, tree, 2)
+	assert.Equal(t, "cache", tree[0].name)
+	assert.Nil(t, tree[0].stat)
+	assert.Equal(t, 200.0, tree[0].sum())
+	plugin := tree[1]
+	assert.Equal(t, "plugin", plugin.name)
+	require.Len(t, plugin.children, 2)
+	assert.Equal(t, "audit", plugin.children[0].name)
+	assert.Equal(t, "hsm", plugin.children[1].children[0].name)
+}
+
+func TestProfilingStatisticsPrintTree(t *testing.T) {
+	var buf bytes.Buffer
+	newNestedProfilingStatistics().Print(&buf)
+	out := buf.String()
+	assert.Contains(t, out, "(70.0% of Total)")
+	lines := strings.Split(out, "\n")
+	var tree []string
+	for i, line := range lines {
+		if line == "Additional:" {
+			tree = lines[i+1:]
+		}
+	}
+	require.GreaterOrEqual(t, len(tree), 7)
+	assert.True(t, strings.HasPrefix(tree[0], "/cache"))
+	assert.True(t, strings.HasPrefix(tree[1], "  /lookup"))
+	assert.True(t, strings.HasPrefix(tree[2], "/plugin"))
+	assert.Contains(t, tree[2], "(50.0% of Total)")
+	assert.True(t, strings.HasPrefix(tree[5], "    /hsm"))
+	assert.Contains(t, tree[5], "(20.0% of Total)")
+}
+
+func TestWriteFoldedStacks(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, newNestedProfilingStatistics().WriteFoldedStacks(&buf, "Test;1"))
+	assert.Equal(t, `Test:1 1000
+Test:1;in_queue 1000
+Test:1;operate 1800
+Test:1;operate;cache;lookup 200
+Test:1;operate;plugin 1500
+Test:1;operate;plugin;audit 500
+Test:1;operate;plugin;sign 1000
+Test:1;operate;plugin;sign;hsm 2000
+Test:1;db_flush 1000
+`, buf.String())
+}
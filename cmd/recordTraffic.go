@@ -0,0 +1,216 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var recordTraceFile string
+var recordListenAddr string
+var recordImportFile string
+var recordCertFile string
+var recordKeyFile string
+
+var recordCmd = &cobra.Command{
+	Use:   "record",
+	Short: "Record DSM traffic into a trace for load-test replay",
+	Long: `Record the time, operation and key of DSM REST calls into a trace file that
+load-test replay sends against a test cluster.
+
+With --listen the tool is a proxy to --server: point the applications at it
+and stop it with Ctrl-C. With --import the calls are read from an access log,
+in the Common or Combined Log Format (an optional request time in seconds may
+follow) or as JSON lines with the fields time, method, path, status, key, alg
+and latency. Access logs have no request bodies, so only the keys in the path
+or in the key field are known.
+
+Only encrypt, decrypt, sign, verify, plugin invocations, key generation and
+version calls are recorded.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if (recordListenAddr == "") == (recordImportFile == "") {
+			log.Fatalf("Either --listen or --import is required\n")
+		}
+		if recordImportFile != "" {
+			importTrace()
+		} else {
+			recordProxy()
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(recordCmd)
+
+	recordCmd.Flags().StringVar(&recordTraceFile, "trace", "trace.jsonl", "Trace file to write")
+	recordCmd.Flags().StringVar(&recordListenAddr, "listen", "", "Address of the recording proxy to --server, e.g. 127.0.0.1:8080")
+	recordCmd.Flags().StringVar(&recordCertFile, "tls-cert", "", "PEM certificate of the proxy, it serves plain HTTP without")
+	recordCmd.Flags().StringVar(&recordKeyFile, "tls-key", "", "PEM private key of --tls-cert")
+	recordCmd.Flags().StringVar(&recordImportFile, "import", "", "Access log of DSM REST calls to convert instead of running a proxy")
+}
+
+func importTrace() {
+	in, err := os.Open(recordImportFile)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	defer in.Close()
+	records, recorder, invalid, err := importLog(in)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	out, err := os.Create(recordTraceFile)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	w := bufio.NewWriter(out)
+	for _, record := range records {
+		if err := writeTraceRecord(w, record); err != nil {
+			log.Fatalf("Fatal error: %v\n", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	if invalid != 0 {
+		log.Printf("Skipped %d lines in an unknown format\n", invalid)
+	}
+	logSkippedRequests(recorder)
+	log.Printf("Wrote %d requests to %v\n", len(records), recordTraceFile)
+}
+
+func logSkippedRequests(recorder *traceRecorder) {
+	for request, count := range recorder.skipped {
+		log.Printf("Not recorded: %v (%d)\n", request, count)
+	}
+}
+
+// traceWriter appends the records of the proxy to the trace file.
+type traceWriter struct {
+	mutex    sync.Mutex
+	w        *bufio.Writer
+	recorder traceRecorder
+	count    uint
+}
+
+func (tw *traceWriter) add(req traceRequest) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	record, ok := tw.recorder.record(req)
+	if !ok {
+		return
+	}
+	if err := writeTraceRecord(tw.w, record); err != nil {
+		log.Printf("Error: %v\n", err)
+		return
+	}
+	tw.count++
+}
+
+func (tw *traceWriter) flush() error {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	return tw.w.Flush()
+}
+
+// statusRecorder keeps the status of a proxied response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func recordProxy() {
+	target := &url.URL{Scheme: "https", Host: net.JoinHostPort(serverName, fmt.Sprint(serverPort))}
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	director := proxy.Director
+	proxy.Director = func(r *http.Request) {
+		director(r)
+		r.Host = target.Host
+	}
+	client := sdkmsClient()
+	proxy.Transport = client.HTTPClient.Transport
+
+	out, err := os.Create(recordTraceFile)
+	if err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	traces := &traceWriter{w: bufio.NewWriter(out)}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body []byte
+		if r.Body != nil {
+			body, _ = io.ReadAll(r.Body)
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		t0 := time.Now()
+		proxy.ServeHTTP(sr, r)
+		traces.add(traceRequest{
+			time:    t0,
+			method:  r.Method,
+			path:    r.URL.Path,
+			body:    body,
+			latency: time.Since(t0),
+			status:  sr.status,
+		})
+	})
+
+	listener, err := net.Listen("tcp", recordListenAddr)
+	if err != nil {
+		log.Fatalf("Failed to listen: %v\n", err)
+	}
+	server := &http.Server{Handler: handler}
+	go func() {
+		var err error
+		if recordCertFile != "" {
+			err = server.ServeTLS(listener, recordCertFile, recordKeyFile)
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != http.ErrServerClosed {
+			log.Fatalf("Fatal error: %v\n", err)
+		}
+	}()
+	log.Printf("Recording proxy to %v listening on %v, press Ctrl-C to stop\n", target.Host, listener.Addr())
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	server.Close()
+	if err := traces.flush(); err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Fatal error: %v\n", err)
+	}
+	logSkippedRequests(&traces.recorder)
+	log.Printf("Wrote %d requests to %v\n", traces.count, recordTraceFile)
+}
@@ -0,0 +1,46 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var reportOutput string
+
+var reportCmd = &cobra.Command{
+	Use:   "report result.json...",
+	Short: "Render load test results as an HTML report",
+	Long: `Render load test results written with --output-format json as a single
+HTML file with charts of the latency over time, the latency percentiles, the
+achieved vs target QPS, the errors and the profiling stages of each test. The
+file has no external dependencies and can be viewed offline.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var summaries []*TestSummary
+		for _, path := range args {
+			s, err := readTestSummaries(path)
+			if err != nil {
+				log.Fatalf("Fatal error: %v\n", err)
+			}
+			summaries = append(summaries, s...)
+		}
+		if err := writeHTMLReportFile(reportOutput, summaries); err != nil {
+			log.Fatalf("Fatal error: %v\n", err)
+		}
+		log.Printf("Wrote report to %v\n", reportOutput)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+
+	reportCmd.Flags().StringVarP(&reportOutput, "output", "o", "report.html", "Path of the HTML report")
+}
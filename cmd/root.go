@@ -20,9 +20,12 @@ const defaultRequestTimeout = 60 * time.Second
 const defaultIdleConnectionTimeout = 0 * time.Second
 
 const (
-	Plain string = "plain"
-	JSON  string = "json"
-	YAML  string = "yaml"
+	Plain    string = "plain"
+	JSON     string = "json"
+	YAML     string = "yaml"
+	JUnit    string = "junit"
+	Markdown string = "markdown"
+	CSV      string = "csv"
 )
 
 // TODO: get rid of global variables, tracking issue: #16
@@ -32,6 +35,8 @@ var insecureTLS bool
 var requestTimeout time.Duration
 var idleConnectionTimeout time.Duration
 var outputFormat string
+var clientCertFile string
+var clientKeyFile string
 
 var rootCmd = &cobra.Command{
 	Use:   "dsm-perf-tool",
@@ -44,11 +49,20 @@ var rootCmd = &cobra.Command{
 			// Plain is accepted
 		case JSON:
 			// JSON is accepted
+		case JUnit:
+			// JUnit is accepted
+		case Markdown:
+			// Markdown is accepted
+		case CSV:
+			// CSV is accepted
 		case YAML:
 			return fmt.Errorf("yaml is not yet supported")
 		default:
 			return fmt.Errorf("unacceptable output format option: %v", outputFormat)
 		}
+		if _, err := tlsClientConfig(); err != nil {
+			return fmt.Errorf("invalid TLS configuration: %v", err)
+		}
 		return nil
 	},
 }
@@ -61,7 +75,18 @@ func init() {
 	rootCmd.PersistentFlags().StringVarP(&serverName, "server", "s", "sdkms.test.fortanix.com", "DSM server host name")
 	rootCmd.PersistentFlags().Uint16VarP(&serverPort, "port", "p", 443, "DSM server port")
 	rootCmd.PersistentFlags().BoolVar(&insecureTLS, "insecure", false, "Do not validate server's TLS certificate")
-	rootCmd.PersistentFlags().StringVar(&outputFormat, "output-format", Plain, "Output format, accepted options are: 'plain', 'json'")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "output-format", Plain, "Output format, accepted options are: 'plain', 'json', 'junit', 'markdown', 'csv'")
 	rootCmd.PersistentFlags().DurationVar(&requestTimeout, "request-timeout", 60*time.Second, "HTTP request timeout, 0 means no timeout")
+	rootCmd.PersistentFlags().StringVar(&clientCertFile, "client-cert", "", "PEM file with the TLS client certificate (chain) to present to the server")
+	rootCmd.PersistentFlags().StringVar(&clientKeyFile, "client-key", "", "PEM file with the private key of the TLS client certificate")
+	rootCmd.PersistentFlags().StringVar(&caFile, "ca-file", "", "PEM file with the CA certificates to verify the server with instead of the system trust store")
+	rootCmd.PersistentFlags().Var(&tlsMinVersion, "tls-min-version", "Minimum TLS version, support: 1.0, 1.1, 1.2, 1.3")
+	rootCmd.PersistentFlags().Var(&tlsMaxVersion, "tls-max-version", "Maximum TLS version, support: 1.0, 1.1, 1.2, 1.3")
+	rootCmd.PersistentFlags().StringSliceVar(&cipherSuiteNames, "cipher-suites", nil, "Comma separated TLS 1.0-1.2 cipher suites to offer, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (TLS 1.3 suites are not configurable)")
+	rootCmd.PersistentFlags().StringSliceVar(&curveNames, "curves", nil, "Comma separated key exchange groups to offer in order of preference, support: X25519, P-256, P-384, P-521")
+	rootCmd.PersistentFlags().StringVar(&tlsServerName, "server-name", "", "TLS server name (SNI) to send and verify the server certificate against, defaults to --server")
+	rootCmd.PersistentFlags().StringArrayVar(&pinnedKeys, "pin-sha256", nil, "SHA-256 of a pinned public key (SubjectPublicKeyInfo) in base64 or hex, one server certificate must have a pinned key. May be repeated")
+	rootCmd.PersistentFlags().Var(&httpVersion, "http-version", "HTTP version to use, support: 1.1, 2")
+	rootCmd.PersistentFlags().IntVar(&maxIdleConnections, "max-idle-connections", 100, "Maximum number of idle connections kept by each client, more are closed")
 	rootCmd.PersistentFlags().DurationVar(&idleConnectionTimeout, "idle-connection-timeout", 0, "Idle connection timeout, 0 means no timeout (default behavior)")
 }
@@ -11,6 +11,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/fortanix/sdkms-client-go/sdkms"
 	"github.com/google/uuid"
@@ -23,6 +26,11 @@ const (
 )
 
 var createTestUser bool
+var createCertApp bool
+var certDir string
+var testAppCount uint
+var apiKeyOutFile string
+var poolKeyCount uint
 
 var (
 	testUserEmail    = DEFAULT_USER
@@ -45,6 +53,11 @@ func init() {
 	testSetupCmd.PersistentFlags().BoolVar(&createTestUser, "create-test-user", false, fmt.Sprintf("Create test user `%v`", testUserEmail))
 	testSetupCmd.PersistentFlags().StringVar(&testUserEmail, "test-user", DEFAULT_USER, "User name for login/creating account")
 	testSetupCmd.PersistentFlags().StringVar(&testUserPassword, "test-user-pwd", DEFAULT_USER_PASSWORD, "User password for login/creating account")
+	testSetupCmd.PersistentFlags().UintVar(&testAppCount, "apps", 1, "Number of API key apps to create")
+	testSetupCmd.PersistentFlags().StringVar(&apiKeyOutFile, "api-key-out", "perf-test-api-keys.txt", "File to write the API keys of all apps to when creating more than one app")
+	testSetupCmd.PersistentFlags().UintVar(&poolKeyCount, "pool-keys", 0, "Number of keys of each type (AES, RSA, EC) to create in separate key pool groups")
+	testSetupCmd.PersistentFlags().BoolVar(&createCertApp, "create-cert-app", false, "Also create an app authenticating with a client certificate issued by a locally generated CA")
+	testSetupCmd.PersistentFlags().StringVar(&certDir, "cert-dir", ".", "Directory to write the generated CA and client certificate files to")
 }
 
 func testSetup() {
@@ -88,6 +101,18 @@ func testSetup() {
 	})
 	checkErr("create group", err)
 
+	// create a group per key type for key pools if requested
+	var keyPoolGroups []*sdkms.Group
+	if poolKeyCount > 0 {
+		for _, t := range keyPoolTypes {
+			poolGroup, err := client.CreateGroup(ctx, sdkms.GroupRequest{
+				Name: someString(fmt.Sprintf("Test %v Key Pool", t)),
+			})
+			checkErr(fmt.Sprintf("create %v key pool group", t), err)
+			keyPoolGroups = append(keyPoolGroups, poolGroup)
+		}
+	}
+
 	// create an app and get its credential
 	app_permissions := sdkms.AppPermissionsSign | sdkms.AppPermissionsVerify |
 		sdkms.AppPermissionsEncrypt | sdkms.AppPermissionsDecrypt |
@@ -95,16 +120,65 @@ func testSetup() {
 		sdkms.AppPermissionsDerivekey | sdkms.AppPermissionsAgreekey |
 		sdkms.AppPermissionsMacgenerate | sdkms.AppPermissionsMacverify |
 		sdkms.AppPermissionsExport | sdkms.AppPermissionsManage
+	appGroups := sdkms.AppGroups{group.GroupID: &app_permissions}
+	for _, poolGroup := range keyPoolGroups {
+		appGroups[poolGroup.GroupID] = &app_permissions
+	}
 
-	app, err := client.CreateApp(ctx, &sdkms.GetAppParams{}, sdkms.AppRequest{
-		DefaultGroup: someString(group.GroupID),
-		AddGroups:    &sdkms.AppGroups{group.GroupID: &app_permissions},
-		Name:         someString("Test App"),
-	})
-	checkErr("create app", err)
+	if testAppCount == 0 {
+		log.Fatalf("At least one app is required\n")
+	}
+	var appIDs, apiKeys []string
+	for i := uint(1); i <= testAppCount; i++ {
+		name := "Test App"
+		if i > 1 {
+			name = fmt.Sprintf("Test App %d", i)
+		}
+		app, err := client.CreateApp(ctx, &sdkms.GetAppParams{}, sdkms.AppRequest{
+			DefaultGroup: someString(group.GroupID),
+			AddGroups:    &appGroups,
+			Name:         someString(name),
+		})
+		checkErr("create app", err)
+
+		appCred, err := client.GetAppCredential(ctx, app.AppID)
+		checkErr("get app's credential", err)
+		appIDs = append(appIDs, app.AppID)
+		apiKeys = append(apiKeys, encodeAPIKey(app, appCred))
+	}
+	if testAppCount > 1 {
+		err = os.WriteFile(apiKeyOutFile, []byte(strings.Join(apiKeys, "\n")+"\n"), 0600)
+		checkErr("write API keys", err)
+	}
 
-	appCred, err := client.GetAppCredential(ctx, app.AppID)
-	checkErr("get app's credential", err)
+	// create an app trusting a locally generated CA if requested
+	var certApp *sdkms.App
+	var clientCertPath, clientKeyPath string
+	if createCertApp {
+		ca, err := generateTestCA("dsm-perf-tool test CA")
+		checkErr("generate test CA", err)
+		clientCert, err := issueClientCertificate(ca, "dsm-perf-tool client")
+		checkErr("issue client certificate", err)
+		clientCertPath = filepath.Join(certDir, "perf-test-client.pem")
+		clientKeyPath = filepath.Join(certDir, "perf-test-client-key.pem")
+		checkErr("write CA certificate", ca.writeCertificatePEM(filepath.Join(certDir, "perf-test-ca.pem"), ""))
+		checkErr("write client certificate", clientCert.writeCertificatePEM(clientCertPath, clientKeyPath))
+
+		certApp, err = client.CreateApp(ctx, &sdkms.GetAppParams{}, sdkms.AppRequest{
+			DefaultGroup: someString(group.GroupID),
+			AddGroups:    &appGroups,
+			Name:         someString("Test Certificate App"),
+			Credential: &sdkms.AppCredential{
+				TrustedCa: &sdkms.TrustedCaCredential{
+					Subject: sdkms.TrustAnchorSubject{
+						Subject: &[][2]string{{"CN", clientCert.Cert.Subject.CommonName}},
+					},
+					CaCertificate: ca.Der,
+				},
+			},
+		})
+		checkErr("create certificate app", err)
+	}
 
 	// create an EC-NistP256 key
 	ecNistP256Curve := sdkms.EllipticCurveNistP256
@@ -205,13 +279,29 @@ func testSetup() {
 	checkErr("create Echo plugin", err)
 	lookupKeyPlugin, err := createPlugin(&client, ctx, group.GroupID, "LookupKey", "function run(input) return assert(Sobject { name = input.key }) end")
 	checkErr("create LookupKey plugin", err)
+	// seed the key pools
+	for i, poolGroup := range keyPoolGroups {
+		t := keyPoolTypes[i]
+		for n := uint(1); n <= poolKeyCount; n++ {
+			_, err := client.CreateSobject(ctx, keyPoolSobjectRequest(t, poolGroup.GroupID, n))
+			checkErr(fmt.Sprintf("create %v pool key %d", t, n), err)
+		}
+	}
 	// terminate session
 	client.TerminateSession(ctx)
 	fmt.Printf("export TEST_ACCT_NAME=%v\n", acct.Name)
 	fmt.Printf("export TEST_ACCT_ID=%v\n", acct.AcctID)
 	fmt.Printf("export TEST_GROUP_ID=%v\n", group.GroupID)
-	fmt.Printf("export TEST_APP_ID=%v\n", app.AppID)
-	fmt.Printf("export TEST_API_KEY=%v\n", encodeAPIKey(app, appCred))
+	fmt.Printf("export TEST_APP_ID=%v\n", appIDs[0])
+	fmt.Printf("export TEST_API_KEY=%v\n", apiKeys[0])
+	if testAppCount > 1 {
+		fmt.Printf("export TEST_API_KEY_FILE=%v\n", apiKeyOutFile)
+	}
+	if certApp != nil {
+		fmt.Printf("export TEST_CERT_APP_ID=%v\n", certApp.AppID)
+		fmt.Printf("export TEST_CLIENT_CERT=%v\n", clientCertPath)
+		fmt.Printf("export TEST_CLIENT_KEY=%v\n", clientKeyPath)
+	}
 	fmt.Printf("export TEST_RSA_KEY_ID=%v\n", *rsaKey.Kid)
 	fmt.Printf("export TEST_RSA_4096_KEY_ID=%v\n", *rsa4096Key.Kid)
 	fmt.Printf("export TEST_EC_NIST_P256_KEY_ID=%v\n", *ecNistP256Key.Kid)
@@ -219,12 +309,35 @@ func testSetup() {
 	fmt.Printf("export TEST_HIVOL_AES_KEY_ID=%v\n", *highVolumeAesKey.Kid)
 	fmt.Printf("export TEST_AES_192_KEY_ID=%v\n", *aes192Key.Kid)
 	fmt.Printf("export TEST_HIVOL_AES_192_KEY_ID=%v\n", *highVolumeAes192Key.Kid)
+	for i, poolGroup := range keyPoolGroups {
+		fmt.Printf("export TEST_%v_KEY_POOL_GROUP_ID=%v\n", keyPoolTypes[i], poolGroup.GroupID)
+	}
 	fmt.Printf("export TEST_EMPTY_PLUGIN_ID=%v\n", emptyPlugin.PluginID)
 	fmt.Printf("export TEST_HELLO_PLUGIN_ID=%v\n", helloPlugin.PluginID)
 	fmt.Printf("export TEST_ECHO_PLUGIN_ID=%v\n", echoPlugin.PluginID)
 	fmt.Printf("export TEST_LOOKUP_KEY_PLUGIN_ID=%v\n", lookupKeyPlugin.PluginID)
 }
 
+var keyPoolTypes = []objectType{objectTypeAES, objectTypeRSA, objectTypeEC}
+
+func keyPoolSobjectRequest(t objectType, groupID string, n uint) sdkms.SobjectRequest {
+	req := sdkms.SobjectRequest{
+		Name:    someString(fmt.Sprintf("Test %v Pool Key %d", t, n)),
+		GroupID: someString(groupID),
+		ObjType: convertObjectType(t),
+	}
+	switch t {
+	case objectTypeAES:
+		req.KeySize = someUint32(256)
+	case objectTypeRSA:
+		req.KeySize = someUint32(2048)
+	case objectTypeEC:
+		curve := sdkms.EllipticCurveNistP256
+		req.EllipticCurve = &curve
+	}
+	return req
+}
+
 func someString(s string) *string                           { return &s }
 func someKeyOps(s sdkms.KeyOperations) *sdkms.KeyOperations { return &s }
 
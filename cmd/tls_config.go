@@ -0,0 +1,291 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// TODO: get rid of global variables, tracking issue: #16
+var caFile string
+var tlsMinVersion tlsVersion
+var tlsMaxVersion tlsVersion
+var cipherSuiteNames []string
+var curveNames []string
+var tlsServerName string
+var pinnedKeys []string
+
+type tlsVersion uint16
+
+// impl pflag.Value interface for tlsVersion
+
+func (v *tlsVersion) String() string {
+	if *v == 0 {
+		return ""
+	}
+	return tlsVersionName(uint16(*v))
+}
+
+func (v *tlsVersion) Set(s string) error {
+	switch strings.TrimPrefix(strings.ToLower(strings.ReplaceAll(s, " ", "")), "tls") {
+	case "1.0", "10":
+		*v = tls.VersionTLS10
+	case "1.1", "11":
+		*v = tls.VersionTLS11
+	case "1.2", "12":
+		*v = tls.VersionTLS12
+	case "1.3", "13":
+		*v = tls.VersionTLS13
+	default:
+		return fmt.Errorf("invalid TLS version: %v", s)
+	}
+	return nil
+}
+
+func (v *tlsVersion) Type() string {
+	return "TLSVersion"
+}
+
+// TLSSettings are the TLS options of a test and the parameters negotiated
+// with the server.
+type TLSSettings struct {
+	CAFile       string         `json:"ca_file,omitempty" yaml:"ca_file,omitempty"`
+	ServerName   string         `json:"server_name,omitempty" yaml:"server_name,omitempty"` // SNI, if different from the server host name
+	MinVersion   string         `json:"min_version,omitempty" yaml:"min_version,omitempty"`
+	MaxVersion   string         `json:"max_version,omitempty" yaml:"max_version,omitempty"`
+	CipherSuites []string       `json:"cipher_suites,omitempty" yaml:"cipher_suites,omitempty"`
+	Curves       []string       `json:"curves,omitempty" yaml:"curves,omitempty"`
+	PinnedKeys   []string       `json:"pinned_keys,omitempty" yaml:"pinned_keys,omitempty"`
+	Negotiated   *TLSParameters `json:"negotiated,omitempty" yaml:"negotiated,omitempty"` // Parameters of the first connection
+}
+
+// TLSParameters are the parameters negotiated for a connection.
+type TLSParameters struct {
+	Version     string `json:"version" yaml:"version"`
+	CipherSuite string `json:"cipher_suite" yaml:"cipher_suite"`
+	KeyExchange string `json:"key_exchange" yaml:"key_exchange"`
+	Protocol    string `json:"protocol,omitempty" yaml:"protocol,omitempty"` // Negotiated with ALPN, e.g. h2
+}
+
+func (ts *TLSSettings) Print(w io.Writer) {
+	var options []string
+	add := func(name, value string) {
+		if value != "" {
+			options = append(options, name+": "+value)
+		}
+	}
+	add("ca-file", ts.CAFile)
+	add("server-name", ts.ServerName)
+	add("min", ts.MinVersion)
+	add("max", ts.MaxVersion)
+	add("cipher-suites", strings.Join(ts.CipherSuites, ","))
+	add("curves", strings.Join(ts.Curves, ","))
+	if len(ts.PinnedKeys) != 0 {
+		add("pinned-keys", fmt.Sprint(len(ts.PinnedKeys)))
+	}
+	if ts.Negotiated != nil {
+		add("negotiated", strings.TrimSpace(fmt.Sprintf("%s %s %s %s", ts.Negotiated.Version, ts.Negotiated.CipherSuite, ts.Negotiated.KeyExchange, ts.Negotiated.Protocol)))
+	}
+	fmt.Fprintf(w, "TLS:            %s\n", strings.Join(options, ", "))
+}
+
+// tlsSettings returns the TLS options of the test, the negotiated parameters
+// are filled in once a connection was made.
+func tlsSettings() *TLSSettings {
+	return &TLSSettings{
+		CAFile:       caFile,
+		ServerName:   tlsServerName,
+		MinVersion:   tlsMinVersion.String(),
+		MaxVersion:   tlsMaxVersion.String(),
+		CipherSuites: cipherSuiteNames,
+		Curves:       curveNames,
+		PinnedKeys:   pinnedKeys,
+	}
+}
+
+var tlsConfigOnce sync.Once
+var tlsConfig *tls.Config
+var tlsConfigErr error
+
+// tlsClientConfig returns the TLS configuration of the command line options,
+// callers must clone it before making changes.
+func tlsClientConfig() (*tls.Config, error) {
+	tlsConfigOnce.Do(func() {
+		tlsConfig, tlsConfigErr = newTLSClientConfig()
+	})
+	return tlsConfig, tlsConfigErr
+}
+
+func newTLSClientConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: insecureTLS,
+		ServerName:         tlsServerName,
+		MinVersion:         uint16(tlsMinVersion),
+		MaxVersion:         uint16(tlsMaxVersion),
+		VerifyConnection:   recordNegotiatedTLS,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", caFile)
+		}
+	}
+	if clientCertFile != "" || clientKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	for _, name := range cipherSuiteNames {
+		id, err := parseCipherSuite(name)
+		if err != nil {
+			return nil, err
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+	for _, name := range curveNames {
+		id, err := parseCurve(name)
+		if err != nil {
+			return nil, err
+		}
+		config.CurvePreferences = append(config.CurvePreferences, id)
+	}
+	if len(pinnedKeys) != 0 {
+		pins, err := parsePinnedKeys(pinnedKeys)
+		if err != nil {
+			return nil, err
+		}
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			certs := cs.PeerCertificates
+			for _, chain := range cs.VerifiedChains {
+				certs = append(certs, chain...)
+			}
+			if err := verifyPinnedKeys(pins, certs); err != nil {
+				return err
+			}
+			return recordNegotiatedTLS(cs)
+		}
+	}
+	return config, nil
+}
+
+// parseCipherSuite accepts the IANA name of a cipher suite supported by
+// crypto/tls, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256.
+func parseCipherSuite(name string) (uint16, error) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, suite := range suites {
+			if strings.EqualFold(suite.Name, name) {
+				return suite.ID, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unsupported cipher suite: %v", name)
+}
+
+// curves are the supported key exchange groups, the first name is the one
+// reported.
+var curves = []struct {
+	id    tls.CurveID
+	names []string
+}{
+	{tls.X25519, []string{"X25519"}},
+	{tls.CurveP256, []string{"P-256", "P256", "secp256r1", "prime256v1"}},
+	{tls.CurveP384, []string{"P-384", "P384", "secp384r1"}},
+	{tls.CurveP521, []string{"P-521", "P521", "secp521r1"}},
+}
+
+func curveName(id tls.CurveID) string {
+	for _, curve := range curves {
+		if curve.id == id {
+			return curve.names[0]
+		}
+	}
+	return fmt.Sprintf("0x%04X", uint16(id))
+}
+
+func parseCurve(name string) (tls.CurveID, error) {
+	for _, curve := range curves {
+		for _, n := range curve.names {
+			if strings.EqualFold(n, name) {
+				return curve.id, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unsupported curve: %v", name)
+}
+
+// parsePinnedKeys decodes SHA-256 hashes of SubjectPublicKeyInfo, in base64
+// as used by HPKP and curl's --pinnedpubkey (an optional sha256// prefix is
+// removed) or in hex.
+func parsePinnedKeys(keys []string) ([][]byte, error) {
+	var pins [][]byte
+	for _, key := range keys {
+		key = strings.TrimPrefix(key, "sha256//")
+		pin, err := hex.DecodeString(strings.ReplaceAll(key, ":", ""))
+		if err != nil || len(pin) != sha256.Size {
+			pin, err = base64.StdEncoding.DecodeString(key)
+		}
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("invalid pinned key, expected the SHA-256 of the public key in base64 or hex: %v", key)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// verifyPinnedKeys checks that the public key of one of the certificates sent
+// by the server or of their verified chains is pinned.
+func verifyPinnedKeys(pins [][]byte, certs []*x509.Certificate) error {
+	for _, cert := range certs {
+		hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		for _, pin := range pins {
+			if bytes.Equal(hash[:], pin) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("none of the server certificates has a pinned public key")
+}
+
+var negotiatedTLSMutex sync.Mutex
+var negotiatedTLS *TLSParameters
+
+// recordNegotiatedTLS keeps the parameters of the first connection.
+func recordNegotiatedTLS(cs tls.ConnectionState) error {
+	negotiatedTLSMutex.Lock()
+	defer negotiatedTLSMutex.Unlock()
+	if negotiatedTLS == nil {
+		negotiatedTLS = &TLSParameters{
+			Version:     tlsVersionName(cs.Version),
+			CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+			KeyExchange: tlsKeyExchange(cs.Version, cs.CipherSuite),
+			Protocol:    cs.NegotiatedProtocol,
+		}
+	}
+	return nil
+}
+
+func negotiatedTLSParameters() *TLSParameters {
+	negotiatedTLSMutex.Lock()
+	defer negotiatedTLSMutex.Unlock()
+	return negotiatedTLS
+}
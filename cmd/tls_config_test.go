@@ -0,0 +1,140 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSVersionFlag(t *testing.T) {
+	var v tlsVersion
+	assert.Equal(t, "", v.String())
+	for _, s := range []string{"1.2", "TLS1.2", "tls 1.2", "12"} {
+		require.NoError(t, v.Set(s))
+		assert.Equal(t, tlsVersion(tls.VersionTLS12), v)
+	}
+	require.NoError(t, v.Set("1.3"))
+	assert.Equal(t, "TLS 1.3", v.String())
+	assert.Error(t, v.Set("1.4"))
+}
+
+func TestParseCipherSuiteAndCurve(t *testing.T) {
+	id, err := parseCipherSuite("tls_ecdhe_rsa_with_aes_128_gcm_sha256")
+	require.NoError(t, err)
+	assert.Equal(t, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, id)
+	_, err = parseCipherSuite("TLS_NOPE")
+	assert.Error(t, err)
+
+	curve, err := parseCurve("secp256r1")
+	require.NoError(t, err)
+	assert.Equal(t, tls.CurveP256, curve)
+	assert.Equal(t, "P-256", curveName(curve))
+	_, err = parseCurve("P-192")
+	assert.Error(t, err)
+}
+
+func TestPinnedKeys(t *testing.T) {
+	ca, err := generateTestCA("Pinning test CA")
+	require.NoError(t, err)
+	hash := sha256.Sum256(ca.Cert.RawSubjectPublicKeyInfo)
+
+	for _, key := range []string{hex.EncodeToString(hash[:]), "sha256//" + base64.StdEncoding.EncodeToString(hash[:])} {
+		pins, err := parsePinnedKeys([]string{key})
+		require.NoError(t, err)
+		assert.Equal(t, [][]byte{hash[:]}, pins)
+	}
+	_, err = parsePinnedKeys([]string{"abcd"})
+	assert.Error(t, err)
+
+	other, err := generateTestCA("Other CA")
+	require.NoError(t, err)
+	pins := [][]byte{hash[:]}
+	assert.NoError(t, verifyPinnedKeys(pins, []*x509.Certificate{other.Cert, ca.Cert}))
+	assert.Error(t, verifyPinnedKeys(pins, []*x509.Certificate{other.Cert}))
+}
+
+// withTLSOptions clears the negotiated parameters and restores the TLS options
+// and the cached configuration after the test.
+func withTLSOptions(t *testing.T) {
+	savedCAFile, savedPins, savedInsecure := caFile, pinnedKeys, insecureTLS
+	negotiatedTLS = nil
+	t.Cleanup(func() {
+		caFile, pinnedKeys, insecureTLS = savedCAFile, savedPins, savedInsecure
+		tlsConfigOnce = sync.Once{}
+		negotiatedTLS = nil
+	})
+}
+
+func TestTLSClientConfig(t *testing.T) {
+	withTLSOptions(t)
+	ca, err := generateTestCA("TLS test CA")
+	require.NoError(t, err)
+	serverCert, err := issueServerCertificate(ca, []string{"127.0.0.1"})
+	require.NoError(t, err)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{serverCert.Der}, PrivateKey: serverCert.Key}}}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca.pem")
+	require.NoError(t, ca.writeCertificatePEM(caPath, ""))
+	notPEM := filepath.Join(dir, "not-pem.txt")
+	require.NoError(t, os.WriteFile(notPEM, []byte("no certificates"), 0644))
+	caHash := sha256.Sum256(ca.Cert.RawSubjectPublicKeyInfo)
+	other, err := generateTestCA("Other CA")
+	require.NoError(t, err)
+	otherHash := sha256.Sum256(other.Cert.RawSubjectPublicKeyInfo)
+
+	get := func() error {
+		tlsConfigOnce = sync.Once{}
+		config, err := tlsClientConfig()
+		if err != nil {
+			return err
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config.Clone()}}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	insecureTLS = false
+	caFile, pinnedKeys = "", nil
+	assert.ErrorContains(t, get(), "certificate signed by unknown authority")
+	assert.Nil(t, negotiatedTLSParameters(), "only verified connections are recorded")
+
+	caFile = notPEM
+	assert.EqualError(t, get(), "no certificates found in "+notPEM)
+
+	caFile = caPath
+	require.NoError(t, get())
+	negotiated := negotiatedTLSParameters()
+	require.NotNil(t, negotiated)
+	assert.Equal(t, "TLS 1.3", negotiated.Version)
+	assert.NotEmpty(t, negotiated.CipherSuite)
+	assert.NotEmpty(t, negotiated.KeyExchange)
+
+	pinnedKeys = []string{hex.EncodeToString(caHash[:])}
+	assert.NoError(t, get())
+	pinnedKeys = []string{hex.EncodeToString(otherHash[:])}
+	assert.ErrorContains(t, get(), "none of the server certificates has a pinned public key")
+}
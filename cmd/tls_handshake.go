@@ -0,0 +1,143 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tlsHandshake is the outcome of the TLS handshake of a new connection.
+type tlsHandshake struct {
+	Duration    time.Duration
+	Version     uint16
+	CipherSuite uint16
+	Resumed     bool
+}
+
+// TLSHandshakeStatistics summarizes the TLS handshakes of the test requests.
+type TLSHandshakeStatistics struct {
+	Full           *Statistic          `json:"full,omitempty" yaml:"full,omitempty"`       // Handshakes that negotiated a new session
+	Resumed        *Statistic          `json:"resumed,omitempty" yaml:"resumed,omitempty"` // Handshakes that resumed a session, e.g. with a ticket
+	ResumptionRate float64             `json:"resumption_rate" yaml:"resumption_rate"`     // Share of the handshakes that resumed a session
+	ByParameters   []TLSHandshakeGroup `json:"by_parameters" yaml:"by_parameters"`
+}
+
+// TLSHandshakeGroup is the statistic of the handshakes that negotiated the
+// same parameters.
+type TLSHandshakeGroup struct {
+	Version     string     `json:"version" yaml:"version"`
+	CipherSuite string     `json:"cipher_suite" yaml:"cipher_suite"`
+	KeyExchange string     `json:"key_exchange" yaml:"key_exchange"`
+	Resumed     bool       `json:"resumed" yaml:"resumed"`
+	Statistic   *Statistic `json:"statistic" yaml:"statistic"`
+}
+
+func (g *TLSHandshakeGroup) label() string {
+	kind := "full"
+	if g.Resumed {
+		kind = "resumed"
+	}
+	return fmt.Sprintf("%s %s %s (%s)", g.Version, g.CipherSuite, g.KeyExchange, kind)
+}
+
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	default:
+		return fmt.Sprintf("0x%04X", version)
+	}
+}
+
+// tlsKeyExchange derives the key exchange from the cipher suite. TLS 1.3
+// suites do not name it, it is always an ephemeral (EC)DHE exchange. The
+// negotiated group is not exposed by crypto/tls, it is only known if --curves
+// allows a single one.
+func tlsKeyExchange(version uint16, cipherSuite uint16) string {
+	var kx string
+	name := tls.CipherSuiteName(cipherSuite)
+	switch {
+	case version >= tls.VersionTLS13:
+		kx = "ECDHE"
+	case strings.HasPrefix(name, "TLS_ECDHE_ECDSA_"):
+		kx = "ECDHE-ECDSA"
+	case strings.HasPrefix(name, "TLS_ECDHE_RSA_"):
+		kx = "ECDHE-RSA"
+	case strings.HasPrefix(name, "TLS_RSA_"):
+		return "RSA"
+	default:
+		return "unknown"
+	}
+	if len(curveNames) == 1 {
+		if id, err := parseCurve(curveNames[0]); err == nil {
+			kx += " " + curveName(id)
+		}
+	}
+	return kx
+}
+
+// tlsHandshakeStatisticsFromHandshakes summarizes the handshakes of a test of
+// the given duration.
+func tlsHandshakeStatisticsFromHandshakes(handshakes []tlsHandshake, duration time.Duration) *TLSHandshakeStatistics {
+	if len(handshakes) == 0 {
+		return nil
+	}
+	var full, resumed []time.Duration
+	groups := make(map[TLSHandshakeGroup][]time.Duration)
+	for _, h := range handshakes {
+		if h.Resumed {
+			resumed = append(resumed, h.Duration)
+		} else {
+			full = append(full, h.Duration)
+		}
+		key := TLSHandshakeGroup{
+			Version:     tlsVersionName(h.Version),
+			CipherSuite: tls.CipherSuiteName(h.CipherSuite),
+			KeyExchange: tlsKeyExchange(h.Version, h.CipherSuite),
+			Resumed:     h.Resumed,
+		}
+		groups[key] = append(groups[key], h.Duration)
+	}
+	ths := &TLSHandshakeStatistics{
+		Full:           StatisticFromDurations(full, duration),
+		Resumed:        StatisticFromDurations(resumed, duration),
+		ResumptionRate: float64(len(resumed)) / float64(len(handshakes)),
+	}
+	for key, durations := range groups {
+		key.Statistic = StatisticFromDurations(durations, duration)
+		ths.ByParameters = append(ths.ByParameters, key)
+	}
+	sort.Slice(ths.ByParameters, func(i, j int) bool {
+		return ths.ByParameters[i].label() < ths.ByParameters[j].label()
+	})
+	return ths
+}
+
+func (ths *TLSHandshakeStatistics) Print(w io.Writer) {
+	if ths.Full != nil {
+		fmt.Fprintf(w, "Full:           %s\n", ths.Full.String())
+	}
+	if ths.Resumed != nil {
+		fmt.Fprintf(w, "Resumed:        %s\n", ths.Resumed.String())
+	}
+	fmt.Fprintf(w, "ResumptionRate: %.1f%%\n", ths.ResumptionRate*100)
+	for i := range ths.ByParameters {
+		g := &ths.ByParameters[i]
+		fmt.Fprintf(w, "%s: %s\n", g.label(), g.Statistic.String())
+	}
+}
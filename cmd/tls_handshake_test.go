@@ -0,0 +1,51 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"crypto/tls"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSKeyExchange(t *testing.T) {
+	assert.Equal(t, "ECDHE", tlsKeyExchange(tls.VersionTLS13, tls.TLS_AES_128_GCM_SHA256))
+	assert.Equal(t, "ECDHE-RSA", tlsKeyExchange(tls.VersionTLS12, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256))
+	assert.Equal(t, "ECDHE-ECDSA", tlsKeyExchange(tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384))
+	assert.Equal(t, "RSA", tlsKeyExchange(tls.VersionTLS12, tls.TLS_RSA_WITH_AES_128_GCM_SHA256))
+}
+
+func TestTLSHandshakeStatistics(t *testing.T) {
+	assert.Nil(t, tlsHandshakeStatisticsFromHandshakes(nil, time.Second))
+
+	handshakes := []tlsHandshake{
+		{Duration: 4 * time.Millisecond, Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256},
+		{Duration: 1 * time.Millisecond, Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, Resumed: true},
+		{Duration: 2 * time.Millisecond, Version: tls.VersionTLS13, CipherSuite: tls.TLS_AES_128_GCM_SHA256, Resumed: true},
+		{Duration: 6 * time.Millisecond, Version: tls.VersionTLS12, CipherSuite: tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	}
+	ths := tlsHandshakeStatisticsFromHandshakes(handshakes, time.Second)
+	require.NotNil(t, ths)
+	assert.Equal(t, 0.5, ths.ResumptionRate)
+	assert.Equal(t, uint(2), ths.Full.QueryNumber)
+	assert.Equal(t, uint(2), ths.Resumed.QueryNumber)
+	assert.Equal(t, float64(1500*time.Microsecond), ths.Resumed.Avg)
+
+	require.Len(t, ths.ByParameters, 3)
+	assert.Equal(t, "TLS 1.2 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 ECDHE-RSA (full)", ths.ByParameters[0].label())
+	assert.Equal(t, "TLS 1.3 TLS_AES_128_GCM_SHA256 ECDHE (full)", ths.ByParameters[1].label())
+	assert.Equal(t, "TLS 1.3 TLS_AES_128_GCM_SHA256 ECDHE (resumed)", ths.ByParameters[2].label())
+	assert.Equal(t, uint(2), ths.ByParameters[2].Statistic.QueryNumber)
+
+	var buf bytes.Buffer
+	ths.Print(&buf)
+	assert.Contains(t, buf.String(), "ResumptionRate: 50.0%\n")
+}
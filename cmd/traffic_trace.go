@@ -0,0 +1,295 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations of a traffic trace, each is replayed with the helper of the
+// matching load test.
+const (
+	traceOpEncrypt     = "encrypt"
+	traceOpDecrypt     = "decrypt"
+	traceOpAsymEncrypt = "asym-encrypt"
+	traceOpAsymDecrypt = "asym-decrypt"
+	traceOpSign        = "sign"
+	traceOpVerify      = "verify"
+	traceOpPlugin      = "invoke-plugin"
+	traceOpGenerateKey = "generate-key"
+	traceOpVersion     = "version"
+)
+
+// TraceRecord is one request of a traffic trace. A trace file has one JSON
+// record per line.
+type TraceRecord struct {
+	Offset    float64 `json:"t"`                 // Seconds since the first request of the trace
+	Operation string  `json:"op"`                // e.g. encrypt, sign or invoke-plugin
+	Key       string  `json:"key,omitempty"`     // Key ID or name of the request, plugin ID for invoke-plugin
+	Latency   float64 `json:"latency,omitempty"` // Seconds, as recorded
+	Status    int     `json:"status,omitempty"`
+}
+
+// traceRequest is a DSM REST call seen by the proxy or read from a log.
+type traceRequest struct {
+	time    time.Time
+	method  string
+	path    string
+	body    []byte // nil if not known
+	key     string // from the log, if the body is not known
+	alg     string
+	latency time.Duration
+	status  int
+}
+
+// symmetricAlgorithms are the algorithms of /crypto/v1/encrypt and decrypt
+// replayed as symmetric operations, the others as asymmetric.
+var symmetricAlgorithms = map[string]bool{"AES": true, "DES": true, "DES3": true, "ARIA": true, "SEED": true}
+
+// traceOperation returns the trace operation and key of a DSM REST call, or
+// an empty operation if the call is not replayed.
+func traceOperation(req traceRequest) (op string, key string) {
+	var body struct {
+		Key *struct {
+			Kid  string `json:"kid"`
+			Name string `json:"name"`
+		} `json:"key"`
+		Alg string `json:"alg"`
+	}
+	if len(req.body) != 0 {
+		// requests without a JSON body keep the key of the log
+		_ = json.Unmarshal(req.body, &body)
+	}
+	key, alg := req.key, req.alg
+	if body.Key != nil {
+		key = body.Key.Kid
+		if key == "" {
+			key = body.Key.Name
+		}
+	}
+	if body.Alg != "" {
+		alg = body.Alg
+	}
+	path := strings.TrimSuffix(strings.SplitN(req.path, "?", 2)[0], "/")
+	// the older API has the key ID in the path: /crypto/v1/keys/{kid}/encrypt
+	if strings.HasPrefix(path, "/crypto/v1/keys/") {
+		parts := strings.Split(strings.TrimPrefix(path, "/crypto/v1/keys/"), "/")
+		if len(parts) == 2 {
+			key = parts[0]
+			path = "/crypto/v1/" + parts[1]
+		}
+	}
+	symmetric := alg == "" || symmetricAlgorithms[strings.ToUpper(alg)]
+	switch {
+	case req.method == http.MethodGet && path == "/sys/v1/version":
+		return traceOpVersion, ""
+	case req.method != http.MethodPost:
+		return "", ""
+	case path == "/crypto/v1/encrypt" && symmetric:
+		return traceOpEncrypt, key
+	case path == "/crypto/v1/encrypt":
+		return traceOpAsymEncrypt, key
+	case path == "/crypto/v1/decrypt" && symmetric:
+		return traceOpDecrypt, key
+	case path == "/crypto/v1/decrypt":
+		return traceOpAsymDecrypt, key
+	case path == "/crypto/v1/sign":
+		return traceOpSign, key
+	case path == "/crypto/v1/verify":
+		return traceOpVerify, key
+	case path == "/crypto/v1/keys":
+		return traceOpGenerateKey, ""
+	case strings.HasPrefix(path, "/sys/v1/plugins/"):
+		return traceOpPlugin, strings.TrimPrefix(path, "/sys/v1/plugins/")
+	}
+	return "", ""
+}
+
+// traceRecorder turns requests into trace records with offsets from the
+// first request.
+type traceRecorder struct {
+	start   time.Time
+	skipped map[string]uint // Requests not replayed, by path
+}
+
+func (tr *traceRecorder) record(req traceRequest) (TraceRecord, bool) {
+	op, key := traceOperation(req)
+	if op == "" {
+		if tr.skipped == nil {
+			tr.skipped = make(map[string]uint)
+		}
+		tr.skipped[req.method+" "+req.path]++
+		return TraceRecord{}, false
+	}
+	if tr.start.IsZero() {
+		tr.start = req.time
+	}
+	return TraceRecord{
+		Offset:    req.time.Sub(tr.start).Seconds(),
+		Operation: op,
+		Key:       key,
+		Latency:   req.latency.Seconds(),
+		Status:    req.status,
+	}, true
+}
+
+// commonLogLine matches the Common and Combined Log Format of access logs,
+// an optional request time in seconds may follow.
+var commonLogLine = regexp.MustCompile(`\[([^\]]+)\] "(\S+) (\S+)[^"]*" (\d{3}) \S+(?: "[^"]*" "[^"]*")?(?: ([0-9.]+))?`)
+
+// parseLogLine reads a DSM REST call of an access log line, either in the
+// Common or Combined Log Format, or as JSON with the fields time (RFC 3339),
+// method, path, status, key, alg and latency (seconds).
+func parseLogLine(line string) (traceRequest, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		var entry struct {
+			Time    time.Time `json:"time"`
+			Method  string    `json:"method"`
+			Path    string    `json:"path"`
+			Status  int       `json:"status"`
+			Key     string    `json:"key"`
+			Alg     string    `json:"alg"`
+			Latency float64   `json:"latency"`
+		}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return traceRequest{}, err
+		}
+		if entry.Time.IsZero() || entry.Method == "" || entry.Path == "" {
+			return traceRequest{}, fmt.Errorf("time, method and path are required")
+		}
+		return traceRequest{
+			time:    entry.Time,
+			method:  entry.Method,
+			path:    entry.Path,
+			key:     entry.Key,
+			alg:     entry.Alg,
+			latency: time.Duration(entry.Latency * float64(time.Second)),
+			status:  entry.Status,
+		}, nil
+	}
+	m := commonLogLine.FindStringSubmatch(line)
+	if m == nil {
+		return traceRequest{}, fmt.Errorf("unknown log format")
+	}
+	t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[1])
+	if err != nil {
+		return traceRequest{}, err
+	}
+	status, _ := strconv.Atoi(m[4])
+	req := traceRequest{time: t, method: m[2], path: m[3], status: status}
+	if m[5] != "" {
+		seconds, _ := strconv.ParseFloat(m[5], 64)
+		req.latency = time.Duration(seconds * float64(time.Second))
+	}
+	return req, nil
+}
+
+// importLog converts an access log to trace records, in the order of the
+// requests. Lines that cannot be parsed are counted as invalid.
+func importLog(r io.Reader) (records []TraceRecord, recorder *traceRecorder, invalid uint, err error) {
+	var requests []traceRequest
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		req, err := parseLogLine(scanner.Text())
+		if err != nil {
+			invalid++
+			continue
+		}
+		requests = append(requests, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, invalid, err
+	}
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].time.Before(requests[j].time) })
+	recorder = &traceRecorder{}
+	for _, req := range requests {
+		if record, ok := recorder.record(req); ok {
+			records = append(records, record)
+		}
+	}
+	return records, recorder, invalid, nil
+}
+
+func writeTraceRecord(w io.Writer, record TraceRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// readTrace reads a trace file, sorted by offset.
+func readTrace(r io.Reader) ([]TraceRecord, error) {
+	var records []TraceRecord
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var record TraceRecord
+		if err := json.Unmarshal([]byte(text), &record); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if record.Operation == "" {
+			return nil, fmt.Errorf("line %d: no operation", line)
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("empty trace")
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Offset < records[j].Offset })
+	return records, nil
+}
+
+// traceIntervals returns the time before each request of the trace. The
+// first is the gap before the trace starts again when it is replayed in a
+// loop, the mean interval of the trace, so that the loop keeps its rate.
+func traceIntervals(records []TraceRecord) []time.Duration {
+	intervals := make([]time.Duration, len(records))
+	for i := 1; i < len(records); i++ {
+		intervals[i] = time.Duration((records[i].Offset - records[i-1].Offset) * float64(time.Second))
+	}
+	if len(records) > 1 {
+		intervals[0] = time.Duration(traceSpan(records) / float64(len(records)-1) * float64(time.Second))
+	}
+	return intervals
+}
+
+// traceSpan is the time in seconds from the first to the last request.
+func traceSpan(records []TraceRecord) float64 {
+	return records[len(records)-1].Offset - records[0].Offset
+}
+
+// traceDuration is the time to replay the trace once, including the gap
+// before its first request.
+func traceDuration(records []TraceRecord, speed float64) time.Duration {
+	span := traceSpan(records)
+	if len(records) > 1 {
+		span += span / float64(len(records)-1)
+	}
+	return time.Duration(span / speed * float64(time.Second))
+}
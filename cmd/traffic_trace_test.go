@@ -0,0 +1,198 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortanix/dsm-perf-tool/mockserver"
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceOperation(t *testing.T) {
+	tests := []struct {
+		req traceRequest
+		op  string
+		key string
+	}{
+		{traceRequest{method: "POST", path: "/crypto/v1/encrypt", body: []byte(`{"key":{"kid":"k1"},"alg":"AES"}`)}, traceOpEncrypt, "k1"},
+		{traceRequest{method: "POST", path: "/crypto/v1/encrypt", body: []byte(`{"key":{"name":"rsa"},"alg":"RSA"}`)}, traceOpAsymEncrypt, "rsa"},
+		{traceRequest{method: "POST", path: "/crypto/v1/decrypt", alg: "RSA", key: "r1"}, traceOpAsymDecrypt, "r1"},
+		{traceRequest{method: "POST", path: "/crypto/v1/keys/k2/decrypt"}, traceOpDecrypt, "k2"},
+		{traceRequest{method: "POST", path: "/crypto/v1/sign/", body: []byte(`{"key":{"kid":"s1"}}`)}, traceOpSign, "s1"},
+		{traceRequest{method: "POST", path: "/crypto/v1/verify?x=1", key: "s1"}, traceOpVerify, "s1"},
+		{traceRequest{method: "POST", path: "/crypto/v1/keys"}, traceOpGenerateKey, ""},
+		{traceRequest{method: "POST", path: "/sys/v1/plugins/p1"}, traceOpPlugin, "p1"},
+		{traceRequest{method: "GET", path: "/sys/v1/version"}, traceOpVersion, ""},
+		{traceRequest{method: "GET", path: "/sys/v1/plugins/p1"}, "", ""},
+		{traceRequest{method: "POST", path: "/sys/v1/session/auth"}, "", ""},
+	}
+	for _, test := range tests {
+		op, key := traceOperation(test.req)
+		assert.Equal(t, test.op, op, test.req.path)
+		assert.Equal(t, test.key, key, test.req.path)
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	req, err := parseLogLine(`10.0.0.1 - - [18/Oct/2026:10:00:00 +0200] "POST /crypto/v1/keys/k1/encrypt HTTP/1.1" 200 120 "-" "curl/8" 0.004`)
+	require.NoError(t, err)
+	assert.Equal(t, "POST", req.method)
+	assert.Equal(t, "/crypto/v1/keys/k1/encrypt", req.path)
+	assert.Equal(t, 200, req.status)
+	assert.Equal(t, 4*time.Millisecond, req.latency)
+	assert.True(t, req.time.Equal(time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC)))
+
+	req, err = parseLogLine(`{"time":"2026-10-18T10:00:02Z","method":"POST","path":"/crypto/v1/sign","key":"s1","latency":0.01}`)
+	require.NoError(t, err)
+	assert.Equal(t, "s1", req.key)
+	assert.Equal(t, 10*time.Millisecond, req.latency)
+
+	_, err = parseLogLine(`{"method":"POST"}`)
+	assert.Error(t, err)
+	_, err = parseLogLine("garbage")
+	assert.Error(t, err)
+}
+
+func TestImportLog(t *testing.T) {
+	log := strings.Join([]string{
+		`{"time":"2026-10-18T10:00:02Z","method":"POST","path":"/crypto/v1/sign","key":"s1"}`,
+		`10.0.0.1 - - [18/Oct/2026:10:00:00 +0000] "GET /sys/v1/version HTTP/1.1" 200 80`,
+		`10.0.0.1 - - [18/Oct/2026:10:00:01 +0000] "POST /sys/v1/session/auth HTTP/1.1" 200 80`,
+		`garbage`,
+	}, "\n")
+	records, recorder, invalid, err := importLog(strings.NewReader(log))
+	require.NoError(t, err)
+	assert.Equal(t, uint(1), invalid)
+	assert.Equal(t, map[string]uint{"POST /sys/v1/session/auth": 1}, recorder.skipped)
+	assert.Equal(t, []TraceRecord{
+		{Offset: 0, Operation: traceOpVersion, Status: 200},
+		{Offset: 2, Operation: traceOpSign, Key: "s1"},
+	}, records)
+}
+
+func TestTraceRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	for _, record := range []TraceRecord{
+		{Offset: 0.5, Operation: traceOpSign, Key: "s1"},
+		{Offset: 0, Operation: traceOpEncrypt, Key: "k1", Latency: 0.002, Status: 200},
+		{Offset: 2, Operation: traceOpVersion},
+	} {
+		require.NoError(t, writeTraceRecord(&buf, record))
+	}
+	records, err := readTrace(&buf)
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, traceOpEncrypt, records[0].Operation, "sorted by offset")
+	intervals := traceIntervals(records)
+	assert.Equal(t, []time.Duration{time.Second, 500 * time.Millisecond, 1500 * time.Millisecond}, intervals, "the mean interval before the trace starts again")
+	assert.Equal(t, 1500*time.Millisecond, traceDuration(records, 2))
+	ta := &traceArrivals{intervals: intervals}
+	assert.InDelta(t, 1, ta.rate(), 1e-9, "2 intervals in 2s, also in a loop")
+
+	_, err = readTrace(strings.NewReader(`{"t":1}`))
+	assert.EqualError(t, err, "line 1: no operation")
+	_, err = readTrace(strings.NewReader("\n"))
+	assert.Error(t, err)
+}
+
+func TestReplayRequests(t *testing.T) {
+	defer func(m map[string]string, k, s, p string) {
+		replayKeyMap, keyID, signKeyID, pluginID = m, k, s, p
+	}(replayKeyMap, keyID, signKeyID, pluginID)
+	replayKeyMap = map[string]string{"prod-key": "test-key"}
+	keyID, signKeyID, pluginID = "default-aes", "", ""
+
+	requests, err := replayRequests([]TraceRecord{
+		{Operation: traceOpEncrypt, Key: "prod-key"},
+		{Operation: traceOpDecrypt, Key: "other"},
+		{Operation: traceOpSign, Key: "signer"},
+		{Operation: traceOpVersion},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []replayRequest{
+		{traceOpEncrypt, "test-key"},
+		{traceOpDecrypt, "default-aes"},
+		{traceOpSign, "signer"},
+		{traceOpVersion, ""},
+	}, requests)
+
+	_, err = replayRequests([]TraceRecord{{Operation: traceOpVerify}, {Operation: traceOpPlugin}})
+	assert.EqualError(t, err, "no key recorded or given for: invoke-plugin, verify")
+	_, err = replayRequests([]TraceRecord{{Operation: "export"}})
+	assert.Error(t, err)
+
+	assert.Equal(t, "encrypt: 2, sign: 2, verify: 1", formatOperationCounts(map[string]uint{"verify": 1, "sign": 2, "encrypt": 2}))
+}
+
+func TestResolveReplayKeyNames(t *testing.T) {
+	withAuthMethod(t, appAuthMethodAPIKey)
+	defer func(key string) { apiKey = key }(apiKey)
+	apiKey = "YXBwOnNlY3JldA=="
+	mock := mockserver.New(mockserver.Config{})
+	prodKid := mock.CreateKey("prod-key", sdkms.ObjectTypeAes, 256)
+	signerKid := mock.CreateKey("signer", sdkms.ObjectTypeRsa, 2048)
+	startTestServer(t, mock)
+
+	requests := []replayRequest{
+		{traceOpEncrypt, "prod-key"},
+		{traceOpDecrypt, prodKid},
+		{traceOpSign, "signer"},
+		{traceOpPlugin, "plugin"},
+		{traceOpVersion, ""},
+	}
+	require.NoError(t, resolveReplayKeyNames(requests))
+	assert.Equal(t, []replayRequest{
+		{traceOpEncrypt, prodKid},
+		{traceOpDecrypt, prodKid},
+		{traceOpSign, signerKid},
+		{traceOpPlugin, "plugin"},
+		{traceOpVersion, ""},
+	}, requests)
+
+	err := resolveReplayKeyNames([]replayRequest{{traceOpEncrypt, "missing"}})
+	assert.ErrorContains(t, err, "failed to look up key missing by name, map it to a test key with --key-map")
+}
+
+func TestReplayKeys(t *testing.T) {
+	withAuthMethod(t, appAuthMethodAPIKey)
+	defer func(key string, mode sdkms.CipherMode) { apiKey, cipherMode = key, mode }(apiKey, cipherMode)
+	apiKey, cipherMode = "YXBwOnNlY3JldA==", sdkms.CipherModeCbc
+	mock := mockserver.New(mockserver.Config{})
+	aes := mock.CreateKey("aes", sdkms.ObjectTypeAes, 256)
+	rsa := mock.CreateKey("rsa", sdkms.ObjectTypeRsa, 2048)
+	calls := make(map[string]int)
+	var mutex sync.Mutex
+	startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		calls[r.URL.Path]++
+		mutex.Unlock()
+		mock.ServeHTTP(w, r)
+	}))
+	client := sdkmsClient()
+	require.NoError(t, authenticateApp(&client, firstAppCredential(), false))
+
+	requests := []replayRequest{
+		{traceOpDecrypt, aes}, {traceOpDecrypt, aes}, {traceOpVerify, rsa}, {traceOpEncrypt, aes}, {traceOpVerify, rsa},
+	}
+	var keys replayKeys
+	require.NoError(t, keys.prepare(&client, requests))
+	assert.Equal(t, map[string]int{"/crypto/v1/encrypt": 1, "/crypto/v1/sign": 1}, calls, "one per key before the test")
+
+	for _, req := range requests {
+		_, _, err := replay(&client, &keys, req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, map[string]int{"/crypto/v1/encrypt": 2, "/crypto/v1/sign": 1, "/crypto/v1/decrypt": 2, "/crypto/v1/verify": 2}, calls, "only the requests of the trace")
+}
@@ -0,0 +1,134 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package mockserver
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+// Distribution is the shape of the simulated processing latency.
+type Distribution string
+
+const (
+	DistributionConstant    Distribution = "constant"
+	DistributionUniform     Distribution = "uniform"
+	DistributionNormal      Distribution = "normal"
+	DistributionExponential Distribution = "exponential"
+)
+
+// Latency describes the simulated server side processing time of a request.
+//
+// For DistributionConstant the latency is always Mean, for DistributionUniform
+// it is uniformly distributed between Min and Max, for DistributionNormal it
+// is normally distributed with Mean and StdDev, and for DistributionExponential
+// it is exponentially distributed with Mean. Samples are never negative.
+type Latency struct {
+	Distribution Distribution
+	Mean         time.Duration
+	StdDev       time.Duration
+	Min          time.Duration
+	Max          time.Duration
+}
+
+// ParseLatency parses a latency specification of the form:
+//
+//	2ms                  constant
+//	constant:2ms         constant
+//	uniform:1ms-3ms      uniform between 1ms and 3ms
+//	normal:2ms,500us     normal with mean 2ms and standard deviation 500us
+//	exponential:2ms      exponential with mean 2ms (also "exp:2ms")
+func ParseLatency(spec string) (Latency, error) {
+	kind, params, found := strings.Cut(spec, ":")
+	if !found {
+		kind, params = string(DistributionConstant), spec
+	}
+	switch Distribution(kind) {
+	case DistributionConstant:
+		mean, err := time.ParseDuration(params)
+		if err != nil {
+			return Latency{}, err
+		}
+		return Latency{Distribution: DistributionConstant, Mean: mean}, nil
+	case DistributionUniform:
+		minStr, maxStr, found := strings.Cut(params, "-")
+		if !found {
+			return Latency{}, fmt.Errorf("uniform latency must be given as <min>-<max>: %v", params)
+		}
+		min, err := time.ParseDuration(minStr)
+		if err != nil {
+			return Latency{}, err
+		}
+		max, err := time.ParseDuration(maxStr)
+		if err != nil {
+			return Latency{}, err
+		}
+		if max < min {
+			return Latency{}, fmt.Errorf("uniform latency max is less than min: %v", params)
+		}
+		return Latency{Distribution: DistributionUniform, Min: min, Max: max, Mean: (min + max) / 2}, nil
+	case DistributionNormal:
+		meanStr, sdStr, found := strings.Cut(params, ",")
+		if !found {
+			return Latency{}, fmt.Errorf("normal latency must be given as <mean>,<stddev>: %v", params)
+		}
+		mean, err := time.ParseDuration(meanStr)
+		if err != nil {
+			return Latency{}, err
+		}
+		sd, err := time.ParseDuration(sdStr)
+		if err != nil {
+			return Latency{}, err
+		}
+		return Latency{Distribution: DistributionNormal, Mean: mean, StdDev: sd}, nil
+	case DistributionExponential, "exp":
+		mean, err := time.ParseDuration(params)
+		if err != nil {
+			return Latency{}, err
+		}
+		return Latency{Distribution: DistributionExponential, Mean: mean}, nil
+	default:
+		return Latency{}, fmt.Errorf("unknown latency distribution: %v", kind)
+	}
+}
+
+// Sample draws one latency from the distribution.
+func (l Latency) Sample(r *rand.Rand) time.Duration {
+	var d time.Duration
+	switch l.Distribution {
+	case DistributionUniform:
+		d = l.Min
+		if l.Max > l.Min {
+			d += time.Duration(r.Int63n(int64(l.Max - l.Min)))
+		}
+	case DistributionNormal:
+		d = l.Mean + time.Duration(r.NormFloat64()*float64(l.StdDev))
+	case DistributionExponential:
+		d = time.Duration(r.ExpFloat64() * float64(l.Mean))
+	default:
+		d = l.Mean
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+func (l Latency) String() string {
+	switch l.Distribution {
+	case DistributionUniform:
+		return fmt.Sprintf("uniform:%v-%v", l.Min, l.Max)
+	case DistributionNormal:
+		return fmt.Sprintf("normal:%v,%v", l.Mean, l.StdDev)
+	case DistributionExponential:
+		return fmt.Sprintf("exponential:%v", l.Mean)
+	default:
+		return fmt.Sprintf("constant:%v", l.Mean)
+	}
+}
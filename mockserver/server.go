@@ -0,0 +1,689 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+// Package mockserver emulates the subset of the DSM REST API used by
+// dsm-perf-tool, so load scenarios can be developed and tested without a
+// real cluster.
+//
+// The emulation is functional, not cryptographic: ciphertexts and signatures
+// are only meant to round-trip through the mock itself.
+//
+// Any API key or JWT is accepted. Certificate authentication, an app ID
+// without a secret, requires a client certificate on the TLS connection, so
+// the server has to request one (tls.RequestClientCert), but the certificate
+// is not checked against the app.
+package mockserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/google/uuid"
+)
+
+const defaultSessionTTL = 3600 * time.Second
+
+// Config controls the behaviour of the mock server. The zero value serves
+// every request immediately and without errors.
+type Config struct {
+	// Simulated processing time of operations (crypto, key generation,
+	// plugin invocation and version requests).
+	Latency Latency
+	// Fraction of operations failing with ErrorStatus, between 0 and 1.
+	ErrorRate float64
+	// HTTP status of injected errors, defaults to 500.
+	ErrorStatus int
+	// Lifetime of sessions, after which requests using them fail with 401.
+	// Defaults to one hour.
+	SessionTTL time.Duration
+	// Whether to add a synthetic Profiling-Data header to operations.
+	ProfilingData bool
+	// Seed of the random number generator, 0 means a time based seed.
+	Seed int64
+}
+
+type session struct {
+	entityID string
+	expires  time.Time
+}
+
+// Server is an http.Handler emulating DSM.
+type Server struct {
+	config Config
+
+	mutex    sync.Mutex
+	rng      *rand.Rand
+	sessions map[string]session
+	sobjects map[string]map[string]interface{}
+	plugins  map[string]map[string]interface{}
+	apps     map[string]string // app ID to secret
+}
+
+// New creates a mock server with the given configuration.
+func New(config Config) *Server {
+	if config.ErrorStatus == 0 {
+		config.ErrorStatus = http.StatusInternalServerError
+	}
+	if config.SessionTTL == 0 {
+		config.SessionTTL = defaultSessionTTL
+	}
+	seed := config.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Server{
+		config:   config,
+		rng:      rand.New(rand.NewSource(seed)),
+		sessions: make(map[string]session),
+		sobjects: make(map[string]map[string]interface{}),
+		plugins:  make(map[string]map[string]interface{}),
+		apps:     make(map[string]string),
+	}
+}
+
+// CreateKey adds a security object to the mock and returns its key ID.
+func (s *Server) CreateKey(name string, objType sdkms.ObjectType, keySize uint32) string {
+	req := sdkms.SobjectRequest{Name: &name, ObjType: &objType}
+	if keySize != 0 {
+		req.KeySize = &keySize
+	}
+	return s.createSobject(req, "")["kid"].(string)
+}
+
+// CreatePlugin adds a plugin echoing its input to the mock and returns its ID.
+func (s *Server) CreatePlugin(name string) string {
+	return s.createPlugin(name, "")["plugin_id"].(string)
+}
+
+// ExpireSessions makes all current sessions expire immediately.
+func (s *Server) ExpireSessions() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for token, sess := range s.sessions {
+		sess.expires = time.Now()
+		s.sessions[token] = sess
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	switch {
+	case path == "/sys/v1/version" && r.Method == http.MethodGet:
+		s.operate(w, false, func() (interface{}, error) {
+			return map[string]interface{}{
+				"version":     "mock",
+				"api_version": "1.0",
+				"server_mode": "Mock",
+			}, nil
+		})
+	case path == "/sys/v1/session/auth" && r.Method == http.MethodPost:
+		s.handleAuth(w, r)
+	case path == "/sys/v1/users" && r.Method == http.MethodPost:
+		s.respond(w, map[string]interface{}{"user_id": uuid.NewString()})
+	default:
+		entity, ok := s.authorize(w, r)
+		if !ok {
+			return
+		}
+		s.route(w, r, entity)
+	}
+}
+
+func (s *Server) route(w http.ResponseWriter, r *http.Request, entity string) {
+	path := r.URL.Path
+	switch {
+	case path == "/sys/v1/session/terminate" && r.Method == http.MethodPost:
+		s.mutex.Lock()
+		delete(s.sessions, bearerToken(r))
+		s.mutex.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case path == "/sys/v1/session/select_account" && r.Method == http.MethodPost:
+		s.respond(w, map[string]interface{}{})
+	case path == "/sys/v1/accounts" && r.Method == http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		s.respond(w, map[string]interface{}{"acct_id": uuid.NewString(), "name": req.Name})
+	case path == "/sys/v1/groups" && r.Method == http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		s.respond(w, map[string]interface{}{"group_id": uuid.NewString(), "name": req.Name})
+	case path == "/sys/v1/apps" && r.Method == http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+		}
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		appID := uuid.NewString()
+		s.mutex.Lock()
+		s.apps[appID] = uuid.NewString()
+		s.mutex.Unlock()
+		s.respond(w, map[string]interface{}{"app_id": appID, "name": req.Name})
+	case strings.HasPrefix(path, "/sys/v1/apps/") && strings.HasSuffix(path, "/credential") && r.Method == http.MethodGet:
+		appID := strings.TrimSuffix(strings.TrimPrefix(path, "/sys/v1/apps/"), "/credential")
+		s.mutex.Lock()
+		secret, ok := s.apps[appID]
+		s.mutex.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "app not found")
+			return
+		}
+		s.respond(w, map[string]interface{}{"app_id": appID, "credential": map[string]interface{}{"secret": secret}})
+	case path == "/sys/v1/plugins" && r.Method == http.MethodPost:
+		var req struct {
+			Name         string `json:"name"`
+			DefaultGroup string `json:"default_group"`
+		}
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		s.respond(w, s.createPlugin(req.Name, req.DefaultGroup))
+	case strings.HasPrefix(path, "/sys/v1/plugins/"):
+		s.handlePlugin(w, r, strings.TrimPrefix(path, "/sys/v1/plugins/"))
+	case path == "/crypto/v1/keys" && r.Method == http.MethodPost:
+		var req sdkms.SobjectRequest
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		s.operate(w, false, func() (interface{}, error) {
+			return s.createSobject(req, entity), nil
+		})
+	case path == "/crypto/v1/keys" && r.Method == http.MethodGet:
+		s.handleListSobjects(w, r)
+	case path == "/crypto/v1/keys/info" && r.Method == http.MethodPost:
+		var req sdkms.SobjectDescriptor
+		if !decodeRequest(w, r, &req) {
+			return
+		}
+		sobject, ok := s.lookupSobject(&req)
+		if !ok {
+			writeError(w, http.StatusNotFound, "sobject not found")
+			return
+		}
+		s.respond(w, sobject)
+	case path == "/crypto/v1/encrypt" && r.Method == http.MethodPost:
+		s.handleEncrypt(w, r)
+	case path == "/crypto/v1/decrypt" && r.Method == http.MethodPost:
+		s.handleDecrypt(w, r)
+	case path == "/crypto/v1/sign" && r.Method == http.MethodPost:
+		s.handleSign(w, r)
+	case path == "/crypto/v1/verify" && r.Method == http.MethodPost:
+		s.handleVerify(w, r)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("%v %v is not supported by the mock server", r.Method, path))
+	}
+}
+
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	var entity string
+	switch {
+	case strings.HasPrefix(auth, "Basic "):
+		var ok bool
+		if entity, ok = basicEntity(w, r); !ok {
+			return
+		}
+	case strings.HasPrefix(auth, "Bearer "):
+		// a signed JWT, the mock does not validate it
+		entity = "jwt"
+	default:
+		writeError(w, http.StatusUnauthorized, "missing authorization")
+		return
+	}
+	token := "mock-session-" + uuid.NewString()
+	s.mutex.Lock()
+	s.sessions[token] = session{entityID: entity, expires: time.Now().Add(s.config.SessionTTL)}
+	s.mutex.Unlock()
+	s.respond(w, map[string]interface{}{
+		"token_type":   "Bearer",
+		"expires_in":   int(s.config.SessionTTL.Seconds()),
+		"access_token": token,
+		"entity_id":    entity,
+	})
+}
+
+// basicEntity returns the app ID of a basic authorization. An app ID without
+// a secret is certificate authentication, which requires a client
+// certificate.
+func basicEntity(w http.ResponseWriter, r *http.Request) (string, bool) {
+	encoded := strings.TrimPrefix(r.Header.Get("Authorization"), "Basic ")
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		decoded, err = base64.URLEncoding.DecodeString(encoded)
+	}
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, "malformed basic authorization")
+		return "", false
+	}
+	entity, secret, _ := strings.Cut(string(decoded), ":")
+	if secret == "" && (r.TLS == nil || len(r.TLS.PeerCertificates) == 0) {
+		writeError(w, http.StatusUnauthorized, "client certificate required")
+		return "", false
+	}
+	return entity, true
+}
+
+// authorize checks the credentials of a request, any API key or JWT is
+// accepted but sessions have to exist and must not have expired.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(auth, "Basic "):
+		if _, ok := basicEntity(w, r); !ok {
+			return "", false
+		}
+		return "app", true
+	case strings.HasPrefix(auth, "Bearer mock-session-"):
+		s.mutex.Lock()
+		sess, ok := s.sessions[bearerToken(r)]
+		s.mutex.Unlock()
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "Unknown session")
+			return "", false
+		}
+		if time.Now().After(sess.expires) {
+			writeError(w, http.StatusUnauthorized, "Session expired")
+			return "", false
+		}
+		return sess.entityID, true
+	case strings.HasPrefix(auth, "Bearer "):
+		return "jwt", true
+	default:
+		writeError(w, http.StatusUnauthorized, "missing authorization")
+		return "", false
+	}
+}
+
+func bearerToken(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// operate runs an operation with the configured latency, error injection
+// and profiling data. Plugin invocations also report nested additional
+// profiling actions.
+func (s *Server) operate(w http.ResponseWriter, plugin bool, op func() (interface{}, error)) {
+	s.mutex.Lock()
+	latency := s.config.Latency.Sample(s.rng)
+	fail := s.config.ErrorRate > 0 && s.rng.Float64() < s.config.ErrorRate
+	s.mutex.Unlock()
+
+	t0 := time.Now()
+	time.Sleep(latency)
+	if s.config.ProfilingData {
+		w.Header().Set("Profiling-Data", s.profilingData(time.Since(t0), plugin))
+	}
+	if fail {
+		writeError(w, s.config.ErrorStatus, "injected error")
+		return
+	}
+	resp, err := op()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	s.respond(w, resp)
+}
+
+// profilingData splits the elapsed time over the server stages reported by
+// DSM in the Profiling-Data header.
+func (s *Server) profilingData(elapsed time.Duration, plugin bool) string {
+	total := uint64(elapsed.Nanoseconds())
+	s.mutex.Lock()
+	jitter := func(share float64) uint64 {
+		return uint64(float64(total) * share * (0.8 + 0.4*s.rng.Float64()))
+	}
+	operate := jitter(0.7)
+	data := map[string]interface{}{
+		"in_queue":       jitter(0.05),
+		"parse_request":  jitter(0.03),
+		"session_lookup": jitter(0.04),
+		"validate_input": jitter(0.02),
+		"check_access":   jitter(0.06),
+		"operate":        operate,
+		"db_flush":       jitter(0.05),
+		"total":          total,
+	}
+	s.mutex.Unlock()
+	if plugin {
+		data["additional_profiling"] = []map[string]interface{}{{
+			"action":  "plugin_invoke",
+			"took_ns": operate * 9 / 10,
+			"sub_actions": []map[string]interface{}{
+				{"action": "load", "took_ns": operate * 3 / 10},
+				{"action": "run", "took_ns": operate * 6 / 10},
+			},
+		}}
+	}
+	b, _ := json.Marshal(data)
+	return string(b)
+}
+
+func (s *Server) respond(w http.ResponseWriter, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(b)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
+
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid request body: %v", err))
+		return false
+	}
+	return true
+}
+
+func (s *Server) createSobject(req sdkms.SobjectRequest, creator string) map[string]interface{} {
+	kid := uuid.NewString()
+	now := time.Now().UTC().Format("20060102T150405Z")
+	keyOps := sdkms.KeyOperationsSign | sdkms.KeyOperationsVerify | sdkms.KeyOperationsEncrypt |
+		sdkms.KeyOperationsDecrypt | sdkms.KeyOperationsAppmanageable
+	if req.KeyOps != nil {
+		keyOps = *req.KeyOps
+	}
+	sobject := map[string]interface{}{
+		"kid":         kid,
+		"name":        kid,
+		"key_ops":     keyOps,
+		"created_at":  now,
+		"lastused_at": now,
+		"enabled":     true,
+		"origin":      "FortanixHSM",
+	}
+	// The creator is always reported as an app, entities that are not
+	// identified by a UUID (users, JWT) map to the nil UUID.
+	if _, err := uuid.Parse(creator); err != nil {
+		creator = uuid.Nil.String()
+	}
+	sobject["creator"] = map[string]interface{}{"app": creator}
+	if req.Name != nil {
+		sobject["name"] = *req.Name
+	}
+	if req.ObjType != nil {
+		sobject["obj_type"] = *req.ObjType
+	}
+	if req.KeySize != nil {
+		sobject["key_size"] = *req.KeySize
+	}
+	if req.EllipticCurve != nil {
+		sobject["elliptic_curve"] = *req.EllipticCurve
+	}
+	if req.GroupID != nil {
+		sobject["group_id"] = *req.GroupID
+	}
+	transient := req.Transient != nil && *req.Transient
+	if transient {
+		sobject["transient_key"] = base64.StdEncoding.EncodeToString([]byte("transient-" + kid))
+		delete(sobject, "kid")
+		return sobject
+	}
+	s.mutex.Lock()
+	s.sobjects[kid] = sobject
+	s.mutex.Unlock()
+	return sobject
+}
+
+func (s *Server) lookupSobject(d *sdkms.SobjectDescriptor) (map[string]interface{}, bool) {
+	if d == nil {
+		return nil, false
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if d.Kid != nil {
+		sobject, ok := s.sobjects[*d.Kid]
+		return sobject, ok
+	}
+	if d.Name != nil {
+		for _, sobject := range s.sobjects {
+			if sobject["name"] == *d.Name {
+				return sobject, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (s *Server) handleListSobjects(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	groupID := query.Get("group_id")
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 1000
+	}
+	s.mutex.Lock()
+	var items []map[string]interface{}
+	for _, sobject := range s.sobjects {
+		if groupID == "" || sobject["group_id"] == groupID {
+			items = append(items, sobject)
+		}
+	}
+	s.mutex.Unlock()
+	// keep pagination stable
+	sort.Slice(items, func(i, j int) bool {
+		return items[i]["kid"].(string) < items[j]["kid"].(string)
+	})
+	if offset > len(items) {
+		offset = len(items)
+	}
+	items = items[offset:]
+	if len(items) > limit {
+		items = items[:limit]
+	}
+	if items == nil {
+		items = []map[string]interface{}{}
+	}
+	s.respond(w, items)
+}
+
+func (s *Server) handleEncrypt(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key    *sdkms.SobjectDescriptor `json:"key"`
+		Plain  []byte                   `json:"plain"`
+		TagLen *uint                    `json:"tag_len"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	sobject, ok := s.lookupSobject(req.Key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "sobject not found")
+		return
+	}
+	s.operate(w, false, func() (interface{}, error) {
+		kid := sobject["kid"].(string)
+		iv := make([]byte, 16)
+		s.mutex.Lock()
+		s.rng.Read(iv)
+		s.mutex.Unlock()
+		resp := map[string]interface{}{
+			"kid":    kid,
+			"cipher": xorKeystream(kid, iv, req.Plain),
+			"iv":     iv,
+		}
+		if req.TagLen != nil {
+			resp["tag"] = authTag(kid, iv, req.Plain, *req.TagLen)
+		}
+		return resp, nil
+	})
+}
+
+func (s *Server) handleDecrypt(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key    *sdkms.SobjectDescriptor `json:"key"`
+		Cipher []byte                   `json:"cipher"`
+		Iv     []byte                   `json:"iv"`
+		Tag    []byte                   `json:"tag"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	sobject, ok := s.lookupSobject(req.Key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "sobject not found")
+		return
+	}
+	s.operate(w, false, func() (interface{}, error) {
+		kid := sobject["kid"].(string)
+		plain := xorKeystream(kid, req.Iv, req.Cipher)
+		if req.Tag != nil && !hmac.Equal(req.Tag, authTag(kid, req.Iv, plain, uint(len(req.Tag)*8))) {
+			return nil, fmt.Errorf("tag mismatch")
+		}
+		return map[string]interface{}{"kid": kid, "plain": plain}, nil
+	})
+}
+
+func (s *Server) handleSign(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key  *sdkms.SobjectDescriptor `json:"key"`
+		Data []byte                   `json:"data"`
+		Hash []byte                   `json:"hash"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	sobject, ok := s.lookupSobject(req.Key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "sobject not found")
+		return
+	}
+	s.operate(w, false, func() (interface{}, error) {
+		kid := sobject["kid"].(string)
+		return map[string]interface{}{"kid": kid, "signature": signature(kid, req.Data, req.Hash)}, nil
+	})
+}
+
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Key       *sdkms.SobjectDescriptor `json:"key"`
+		Data      []byte                   `json:"data"`
+		Hash      []byte                   `json:"hash"`
+		Signature []byte                   `json:"signature"`
+	}
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	sobject, ok := s.lookupSobject(req.Key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "sobject not found")
+		return
+	}
+	s.operate(w, false, func() (interface{}, error) {
+		kid := sobject["kid"].(string)
+		result := hmac.Equal(req.Signature, signature(kid, req.Data, req.Hash))
+		return map[string]interface{}{"kid": kid, "result": result}, nil
+	})
+}
+
+func (s *Server) createPlugin(name string, groupID string) map[string]interface{} {
+	pluginID := uuid.NewString()
+	now := time.Now().UTC().Format("20060102T150405Z")
+	plugin := map[string]interface{}{
+		"plugin_id":      pluginID,
+		"name":           name,
+		"default_group":  groupID,
+		"groups":         []string{groupID},
+		"enabled":        true,
+		"plugin_type":    "STANDARD",
+		"created_at":     now,
+		"lastupdated_at": now,
+		"source":         map[string]interface{}{"language": "LUA", "code": "function run(input) return input end"},
+	}
+	s.mutex.Lock()
+	s.plugins[pluginID] = plugin
+	s.mutex.Unlock()
+	return plugin
+}
+
+func (s *Server) handlePlugin(w http.ResponseWriter, r *http.Request, pluginID string) {
+	s.mutex.Lock()
+	plugin, ok := s.plugins[pluginID]
+	s.mutex.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "plugin not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		s.respond(w, plugin)
+	case http.MethodPost:
+		var input json.RawMessage
+		if !decodeRequest(w, r, &input) {
+			return
+		}
+		s.operate(w, true, func() (interface{}, error) {
+			return input, nil
+		})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// xorKeystream is its own inverse, it stands in for encryption.
+func xorKeystream(kid string, iv []byte, data []byte) []byte {
+	out := make([]byte, len(data))
+	var block []byte
+	for i := range data {
+		if i%sha256.Size == 0 {
+			h := sha256.New()
+			h.Write([]byte(kid))
+			h.Write(iv)
+			h.Write([]byte(strconv.Itoa(i)))
+			block = h.Sum(nil)
+		}
+		out[i] = data[i] ^ block[i%sha256.Size]
+	}
+	return out
+}
+
+func authTag(kid string, iv []byte, plain []byte, tagLen uint) []byte {
+	mac := hmac.New(sha256.New, []byte(kid))
+	mac.Write(iv)
+	mac.Write(plain)
+	tag := mac.Sum(nil)
+	if n := int(tagLen / 8); n > 0 && n < len(tag) {
+		tag = tag[:n]
+	}
+	return tag
+}
+
+func signature(kid string, data []byte, hash []byte) []byte {
+	if data != nil {
+		digest := sha256.Sum256(data)
+		hash = digest[:]
+	}
+	mac := hmac.New(sha256.New, []byte(kid))
+	mac.Write(hash)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,193 @@
+/* Copyright (c) Fortanix, Inc.
+ *
+ * This Source Code Form is subject to the terms of the Mozilla Public
+ * License, v. 2.0. If a copy of the MPL was not distributed with this
+ * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
+
+package mockserver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fortanix/sdkms-client-go/sdkms"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestClient(t *testing.T, config Config) (*Server, *sdkms.Client) {
+	mock := New(config)
+	server := httptest.NewTLSServer(mock)
+	t.Cleanup(server.Close)
+	return mock, &sdkms.Client{HTTPClient: server.Client(), Endpoint: server.URL}
+}
+
+func TestCryptoOperations(t *testing.T) {
+	mock, client := newTestClient(t, Config{ProfilingData: true})
+	ctx := context.Background()
+	_, err := client.AuthenticateWithAPIKey(ctx, "YXBwOnNlY3JldA==")
+	assert.NoError(t, err)
+
+	aesKid := mock.CreateKey("aes", sdkms.ObjectTypeAes, 256)
+	sobject, err := client.GetSobject(ctx, nil, sdkms.SobjectDescriptor{Kid: &aesKid})
+	assert.NoError(t, err)
+	assert.Equal(t, sdkms.ObjectTypeAes, sobject.ObjType)
+	assert.Equal(t, uint32(256), *sobject.KeySize)
+
+	tagLen := uint(128)
+	rawCtx := sdkms.IncludeRawResponse(ctx)
+	encrypted, err := client.Encrypt(rawCtx, sdkms.EncryptRequest{
+		Key:    sdkms.SobjectByID(aesKid),
+		Alg:    sdkms.AlgorithmAes,
+		Plain:  []byte("0123456789ABCDEF"),
+		Mode:   sdkms.CryptModeSymmetric(sdkms.CipherModeGcm),
+		TagLen: &tagLen,
+	})
+	assert.NoError(t, err)
+	assert.NotEqual(t, []byte("0123456789ABCDEF"), encrypted.Cipher)
+	var profiling map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(sdkms.GetRawResponse(rawCtx).Header.Get("Profiling-Data")), &profiling))
+	assert.Contains(t, profiling, "total")
+
+	decrypted, err := client.Decrypt(ctx, sdkms.DecryptRequest{
+		Key:    sdkms.SobjectByID(aesKid),
+		Cipher: encrypted.Cipher,
+		Iv:     encrypted.Iv,
+		Tag:    encrypted.Tag,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("0123456789ABCDEF"), decrypted.Plain)
+
+	rsaKid := mock.CreateKey("rsa", sdkms.ObjectTypeRsa, 2048)
+	data := []byte("0123456789abcdef")
+	signed, err := client.Sign(ctx, sdkms.SignRequest{Key: sdkms.SobjectByID(rsaKid), HashAlg: sdkms.DigestAlgorithmSha256, Data: &data})
+	assert.NoError(t, err)
+	verified, err := client.Verify(ctx, sdkms.VerifyRequest{Key: sdkms.SobjectByID(rsaKid), HashAlg: sdkms.DigestAlgorithmSha256, Data: &data, Signature: signed.Signature})
+	assert.NoError(t, err)
+	assert.True(t, verified.Result)
+
+	pluginID := mock.CreatePlugin("Echo")
+	plugin, err := client.GetPlugin(ctx, pluginID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Echo", plugin.Name)
+	output, err := client.InvokePlugin(ctx, pluginID, map[string]string{"hello": "world"})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"hello":"world"}`, string(*output))
+
+	assert.NoError(t, client.TerminateSession(ctx))
+}
+
+func TestAccountSetup(t *testing.T) {
+	_, client := newTestClient(t, Config{})
+	ctx := context.Background()
+	_, err := client.AuthenticateWithUserPass(ctx, "user@example.com", "password")
+	assert.NoError(t, err)
+	acct, err := client.CreateAccount(ctx, sdkms.AccountRequest{Name: sdkms.Some("account")})
+	assert.NoError(t, err)
+	_, err = client.SelectAccount(ctx, sdkms.SelectAccountRequest{AcctID: acct.AcctID})
+	assert.NoError(t, err)
+	group, err := client.CreateGroup(ctx, sdkms.GroupRequest{Name: sdkms.Some("group")})
+	assert.NoError(t, err)
+	app, err := client.CreateApp(ctx, nil, sdkms.AppRequest{Name: sdkms.Some("app"), DefaultGroup: &group.GroupID})
+	assert.NoError(t, err)
+	cred, err := client.GetAppCredential(ctx, app.AppID)
+	assert.NoError(t, err)
+	assert.NotNil(t, cred.Credential.Secret)
+
+	for i := 0; i < 3; i++ {
+		_, err := client.CreateSobject(ctx, sdkms.SobjectRequest{
+			Name:    sdkms.Some("key"),
+			GroupID: &group.GroupID,
+			ObjType: sdkms.Some(sdkms.ObjectTypeAes),
+			KeySize: sdkms.Some(uint32(256)),
+		})
+		assert.NoError(t, err)
+	}
+	// Sort has to be set, the SDK does not handle a nil value
+	keys, err := client.ListSobjects(ctx, &sdkms.ListSobjectsParams{GroupID: &group.GroupID, Sort: &sdkms.SobjectSort{}})
+	assert.NoError(t, err)
+	assert.Len(t, keys.Items, 3)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	mock, client := newTestClient(t, Config{})
+	ctx := context.Background()
+	_, err := client.AuthenticateWithAPIKey(ctx, "YXBwOnNlY3JldA==")
+	assert.NoError(t, err)
+	_, err = client.Version(ctx, nil)
+	assert.NoError(t, err)
+	kid := mock.CreateKey("aes", sdkms.ObjectTypeAes, 256)
+
+	mock.ExpireSessions()
+	_, err = client.Encrypt(ctx, sdkms.EncryptRequest{Key: sdkms.SobjectByID(kid), Alg: sdkms.AlgorithmAes, Plain: []byte("data")})
+	var backendErr *sdkms.BackendError
+	assert.True(t, errors.As(err, &backendErr))
+	assert.Equal(t, http.StatusUnauthorized, backendErr.StatusCode)
+}
+
+func TestCertificateAuthentication(t *testing.T) {
+	mock := New(Config{})
+	server := httptest.NewUnstartedServer(mock)
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+	ctx := context.Background()
+	certAuth := base64.StdEncoding.EncodeToString([]byte("app-1:"))
+
+	client := &sdkms.Client{HTTPClient: server.Client(), Endpoint: server.URL}
+	_, err := client.AuthenticateWithAPIKey(ctx, certAuth)
+	var backendErr *sdkms.BackendError
+	if assert.True(t, errors.As(err, &backendErr)) {
+		assert.Equal(t, http.StatusUnauthorized, backendErr.StatusCode)
+	}
+	_, err = client.AuthenticateWithAPIKey(ctx, "YXBwOnNlY3JldA==")
+	assert.NoError(t, err, "an API key needs no certificate")
+
+	// any certificate is accepted, e.g. the one of the server
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = server.TLS.Certificates
+	client = &sdkms.Client{HTTPClient: &http.Client{Transport: transport}, Endpoint: server.URL}
+	resp, err := client.AuthenticateWithAPIKey(ctx, certAuth)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "app-1", resp.EntityID)
+	}
+}
+
+func TestErrorInjection(t *testing.T) {
+	_, client := newTestClient(t, Config{ErrorRate: 1, ErrorStatus: http.StatusTooManyRequests})
+	_, err := client.Version(context.Background(), nil)
+	var backendErr *sdkms.BackendError
+	assert.True(t, errors.As(err, &backendErr))
+	assert.Equal(t, http.StatusTooManyRequests, backendErr.StatusCode)
+}
+
+func TestParseLatency(t *testing.T) {
+	for spec, expected := range map[string]Latency{
+		"2ms":              {Distribution: DistributionConstant, Mean: 2 * time.Millisecond},
+		"uniform:1ms-3ms":  {Distribution: DistributionUniform, Min: time.Millisecond, Max: 3 * time.Millisecond, Mean: 2 * time.Millisecond},
+		"normal:2ms,500us": {Distribution: DistributionNormal, Mean: 2 * time.Millisecond, StdDev: 500 * time.Microsecond},
+		"exp:2ms":          {Distribution: DistributionExponential, Mean: 2 * time.Millisecond},
+	} {
+		latency, err := ParseLatency(spec)
+		assert.NoError(t, err, spec)
+		assert.Equal(t, expected, latency, spec)
+	}
+	for _, spec := range []string{"uniform:3ms-1ms", "normal:2ms", "pareto:1ms", "fast"} {
+		_, err := ParseLatency(spec)
+		assert.Error(t, err, spec)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	uniform := Latency{Distribution: DistributionUniform, Min: time.Millisecond, Max: 3 * time.Millisecond}
+	for i := 0; i < 100; i++ {
+		d := uniform.Sample(r)
+		assert.True(t, d >= time.Millisecond && d < 3*time.Millisecond)
+	}
+}